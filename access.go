@@ -0,0 +1,236 @@
+package memogram
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/usememos/memogram/store"
+)
+
+func parseIDs(raw string) (map[int64]struct{}, error) {
+	ids := make(map[int64]struct{})
+	for _, entry := range strings.Split(raw, ",") {
+		trimmed := strings.TrimSpace(entry)
+		if trimmed == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(trimmed, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ID %q", trimmed)
+		}
+		ids[id] = struct{}{}
+	}
+	return ids, nil
+}
+
+func (s *Service) isAdmin(userID int64) bool {
+	settings := s.settings()
+	_, ok := settings.adminUserIDs[userID]
+	return ok
+}
+
+// hasAllowlist reports whether access is restricted at all. Without any
+// allowlist entry or admin, everyone may use the bot.
+func (s *Service) hasAllowlist() bool {
+	settings := s.settings()
+	return len(settings.adminUserIDs) > 0 ||
+		len(settings.allowedUsernames) > 0 ||
+		len(settings.allowedUserIDs) > 0 ||
+		len(settings.allowedChatIDs) > 0 ||
+		len(s.store.ListAccessEntries()) > 0
+}
+
+// isAllowed reports whether the user may use the bot in the chat. Admins are
+// always allowed.
+func (s *Service) isAllowed(userID int64, username string, chatID int64) bool {
+	if s.isAdmin(userID) || !s.hasAllowlist() {
+		return true
+	}
+	settings := s.settings()
+
+	if username != "" {
+		normalized := strings.ToLower(strings.TrimSpace(username))
+		if _, ok := settings.allowedUsernames[normalized]; ok {
+			return true
+		}
+		if s.store.HasAccessEntry(store.AccessEntry{Kind: store.AccessUsername, Value: normalized}) {
+			return true
+		}
+	}
+	if _, ok := settings.allowedUserIDs[userID]; ok {
+		return true
+	}
+	if s.store.HasAccessEntry(store.AccessEntry{Kind: store.AccessUser, Value: strconv.FormatInt(userID, 10)}) {
+		return true
+	}
+	if chatID != 0 {
+		if _, ok := settings.allowedChatIDs[chatID]; ok {
+			return true
+		}
+		if s.store.HasAccessEntry(store.AccessEntry{Kind: store.AccessChat, Value: strconv.FormatInt(chatID, 10)}) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAccessArgs parses `<user_id>`, `@<username>` or `chat <chat_id>`.
+func parseAccessArgs(text string) (store.AccessEntry, error) {
+	args := strings.Fields(text)
+	switch {
+	case len(args) == 2 && args[0] == "chat":
+		return store.NewAccessEntry(store.AccessChat, args[1])
+	case len(args) != 1:
+		return store.AccessEntry{}, fmt.Errorf("expected a user ID, @username or chat <chat_id>")
+	case strings.HasPrefix(args[0], "@"):
+		return store.NewAccessEntry(store.AccessUsername, args[0])
+	default:
+		return store.NewAccessEntry(store.AccessUser, args[0])
+	}
+}
+
+func (s *Service) allowHandler(ctx context.Context, b *bot.Bot, m *models.Update) {
+	if !s.requireAdmin(ctx, m) {
+		return
+	}
+	entry, err := parseAccessArgs(strings.TrimPrefix(m.Message.Text, commandAllow))
+	if err != nil {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   fmt.Sprintf("Usage: /allow <user_id|@username> or /allow chat <chat_id> (%s)", err),
+		})
+		return
+	}
+	err = s.store.AddAccessEntry(entry)
+	result, details := auditResult(err)
+	s.audit.Record(auditEvent{
+		Action:  auditActionAdminAllow,
+		UserID:  m.Message.From.ID,
+		Result:  result,
+		Details: joinDetails(entry.String(), details),
+	})
+	if err != nil {
+		s.sendError(m.Message.Chat.ID, err)
+		return
+	}
+	s.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: m.Message.Chat.ID,
+		Text:   fmt.Sprintf("Allowed %s", entry),
+	})
+}
+
+func (s *Service) denyHandler(ctx context.Context, b *bot.Bot, m *models.Update) {
+	if !s.requireAdmin(ctx, m) {
+		return
+	}
+	entry, err := parseAccessArgs(strings.TrimPrefix(m.Message.Text, commandDeny))
+	if err != nil {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   fmt.Sprintf("Usage: /deny <user_id|@username> or /deny chat <chat_id> (%s)", err),
+		})
+		return
+	}
+	removed, err := s.store.RemoveAccessEntry(entry)
+	result, details := auditResult(err)
+	if err == nil && !removed {
+		result, details = auditResultFailure, "not in the runtime allowlist"
+	}
+	s.audit.Record(auditEvent{
+		Action:  auditActionAdminDeny,
+		UserID:  m.Message.From.ID,
+		Result:  result,
+		Details: joinDetails(entry.String(), details),
+	})
+	if err != nil {
+		s.sendError(m.Message.Chat.ID, err)
+		return
+	}
+	text := fmt.Sprintf("Denied %s", entry)
+	if !removed {
+		text = fmt.Sprintf("%s is not in the runtime allowlist. Entries from the configuration can only be removed there.", entry)
+	}
+	s.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: m.Message.Chat.ID,
+		Text:   text,
+	})
+}
+
+func (s *Service) usersHandler(ctx context.Context, b *bot.Bot, m *models.Update) {
+	if !s.requireAdmin(ctx, m) {
+		return
+	}
+	s.audit.Record(auditEvent{
+		Action: auditActionAdminUsers,
+		UserID: m.Message.From.ID,
+		Result: auditResultSuccess,
+	})
+
+	settings := s.settings()
+	var sb strings.Builder
+	if !s.hasAllowlist() {
+		sb.WriteString("No allowlist is configured, everyone may use this bot.\n")
+	}
+	writeSection := func(title string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		fmt.Fprintf(&sb, "\n%s:\n", title)
+		for _, value := range values {
+			fmt.Fprintf(&sb, "- %s\n", value)
+		}
+	}
+	writeSection("Admins", formatIDs("user", settings.adminUserIDs))
+	var configured []string
+	for username := range settings.allowedUsernames {
+		configured = append(configured, "username:"+username)
+	}
+	configured = append(configured, formatIDs("user", settings.allowedUserIDs)...)
+	configured = append(configured, formatIDs("chat", settings.allowedChatIDs)...)
+	sort.Strings(configured)
+	writeSection("Allowed by configuration", configured)
+	var runtime []string
+	for _, entry := range s.store.ListAccessEntries() {
+		runtime = append(runtime, entry.String())
+	}
+	writeSection("Allowed with /allow", runtime)
+
+	s.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: m.Message.Chat.ID,
+		Text:   strings.TrimSpace(sb.String()),
+	})
+}
+
+// requireAdmin replies with an error and returns false if the sender of the
+// message is not an admin.
+func (s *Service) requireAdmin(ctx context.Context, m *models.Update) bool {
+	if s.isAdmin(m.Message.From.ID) {
+		return true
+	}
+	s.audit.Record(auditEvent{
+		Action:  auditActionAccess,
+		UserID:  m.Message.From.ID,
+		ChatID:  m.Message.Chat.ID,
+		Result:  auditResultDenied,
+		Details: strings.Fields(m.Message.Text)[0],
+	})
+	s.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: m.Message.Chat.ID,
+		Text:   "Only admins can manage users",
+	})
+	return false
+}
+
+func formatIDs(kind string, ids map[int64]struct{}) []string {
+	values := make([]string, 0, len(ids))
+	for id := range ids {
+		values = append(values, fmt.Sprintf("%s:%d", kind, id))
+	}
+	sort.Strings(values)
+	return values
+}
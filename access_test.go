@@ -0,0 +1,115 @@
+package memogram
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/usememos/memogram/store"
+)
+
+func newTestAccessService(t *testing.T) *Service {
+	t.Helper()
+	s := store.NewStore(filepath.Join(t.TempDir(), "data.txt"))
+	if err := s.Init(); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	service := &Service{store: s, logger: slog.Default()}
+	service.live.Store(&liveSettings{
+		allowedUsernames: parseAllowedUsernames(""),
+		allowedUserIDs:   map[int64]struct{}{},
+		allowedChatIDs:   map[int64]struct{}{},
+		adminUserIDs:     map[int64]struct{}{1: {}},
+	})
+	return service
+}
+
+func TestIsAllowedWithoutAllowlist(t *testing.T) {
+	s := newTestAccessService(t)
+	clear(s.settings().adminUserIDs)
+	if !s.isAllowed(42, "", 42) {
+		t.Fatal("expected everyone to be allowed without an allowlist")
+	}
+}
+
+func TestIsAllowedWithOnlyAdmins(t *testing.T) {
+	s := newTestAccessService(t)
+	if s.isAllowed(42, "", 42) {
+		t.Fatal("expected admins alone to restrict access")
+	}
+	if !s.isAllowed(1, "", 1) {
+		t.Fatal("expected admins to be allowed")
+	}
+}
+
+func TestDenyLastRuntimeEntryKeepsBotClosed(t *testing.T) {
+	ctx := context.Background()
+	_, b := newFakeBotAPI(t)
+	s := newTestAccessService(t)
+	s.sender = newTestSender(b)
+
+	s.allowHandler(ctx, b, messageUpdate(1, commandAllow+" 7"))
+	if !s.isAllowed(7, "", 7) {
+		t.Fatal("expected user 7 to be allowed after /allow")
+	}
+	s.denyHandler(ctx, b, messageUpdate(1, commandDeny+" 7"))
+	if s.isAllowed(7, "", 7) || s.isAllowed(8, "", 8) {
+		t.Fatal("expected the bot to stay closed after denying the last entry")
+	}
+}
+
+func TestIsAllowedByIDs(t *testing.T) {
+	s := newTestAccessService(t)
+	s.settings().allowedUserIDs[42] = struct{}{}
+	s.settings().allowedChatIDs[-100] = struct{}{}
+
+	if !s.isAllowed(42, "", 42) {
+		t.Fatal("expected user 42 to be allowed by ID")
+	}
+	if !s.isAllowed(7, "", -100) {
+		t.Fatal("expected members of chat -100 to be allowed")
+	}
+	if s.isAllowed(7, "seven", 7) {
+		t.Fatal("expected user 7 to be denied")
+	}
+	if !s.isAllowed(1, "", 1) {
+		t.Fatal("expected admins to be allowed")
+	}
+}
+
+func TestIsAllowedByRuntimeEntries(t *testing.T) {
+	s := newTestAccessService(t)
+	entry, err := parseAccessArgs(" @Seven")
+	if err != nil {
+		t.Fatalf("parse access args: %v", err)
+	}
+	if err := s.store.AddAccessEntry(entry); err != nil {
+		t.Fatalf("add access entry: %v", err)
+	}
+
+	if !s.isAllowed(7, "seven", 7) {
+		t.Fatal("expected @seven to be allowed after /allow")
+	}
+	if s.isAllowed(8, "eight", 8) {
+		t.Fatal("expected @eight to be denied once an allowlist exists")
+	}
+}
+
+func TestParseAccessArgs(t *testing.T) {
+	for text, want := range map[string]store.AccessEntry{
+		" 42":           {Kind: store.AccessUser, Value: "42"},
+		" @Alice":       {Kind: store.AccessUsername, Value: "alice"},
+		" chat -100123": {Kind: store.AccessChat, Value: "-100123"},
+	} {
+		got, err := parseAccessArgs(text)
+		if err != nil || got != want {
+			t.Fatalf("parse %q: got %+v, %v", text, got, err)
+		}
+	}
+	for _, text := range []string{"", " alice", " chat", " 1 2"} {
+		if _, err := parseAccessArgs(text); err == nil {
+			t.Fatalf("expected %q to be rejected", text)
+		}
+	}
+}
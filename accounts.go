@@ -0,0 +1,156 @@
+package memogram
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// Account names end up in callback data, which Telegram limits to 64 bytes.
+var accountNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,16}$`)
+
+func isValidAccountName(name string) bool {
+	return accountNamePattern.MatchString(name)
+}
+
+func (s *Service) accountsHandler(ctx context.Context, b *bot.Bot, m *models.Update) {
+	credentials, active := s.store.ListUserCredentials(m.Message.From.ID)
+	if len(credentials) == 0 {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   "Please start the bot with /start <access_token>",
+		})
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Accounts:\n")
+	for _, credential := range credentials {
+		marker := "  "
+		if credential.Account == active {
+			marker = "* "
+		}
+		fmt.Fprintf(&sb, "%s%s (%s)\n", marker, credential.Account, s.instanceURL(credential.Instance))
+	}
+	sb.WriteString("\nUse /switch <name> to change the active account, or start a message with !<name> to use another account once.")
+	s.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: m.Message.Chat.ID,
+		Text:   sb.String(),
+	})
+}
+
+func (s *Service) switchHandler(ctx context.Context, b *bot.Bot, m *models.Update) {
+	account := strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(m.Message.Text, commandSwitch)), "!")
+	if account == "" {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   "Usage: /switch <name>",
+		})
+		return
+	}
+
+	if err := s.store.SwitchUserAccount(m.Message.From.ID, account); err != nil {
+		s.sendError(m.Message.Chat.ID, err)
+		return
+	}
+	s.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: m.Message.Chat.ID,
+		Text:   fmt.Sprintf("Switched to the %s account", account),
+	})
+}
+
+// splitAccountPrefix returns the account name of a leading `!name` in text and
+// the length of the prefix, including the whitespace after it, in UTF-16 code
+// units as used by message entity offsets.
+func splitAccountPrefix(text string) (string, int) {
+	if !strings.HasPrefix(text, "!") {
+		return "", 0
+	}
+	end := strings.IndexFunc(text, unicode.IsSpace)
+	if end < 0 {
+		return "", 0
+	}
+	name := text[1:end]
+	if !isValidAccountName(name) {
+		return "", 0
+	}
+	rest := strings.TrimLeftFunc(text[end:], unicode.IsSpace)
+	prefix := text[:len(text)-len(rest)]
+	return name, len(utf16.Encode([]rune(prefix)))
+}
+
+// trimUTF16Prefix removes the first n UTF-16 code units from text.
+func trimUTF16Prefix(text string, n int) string {
+	encoded := utf16.Encode([]rune(text))
+	if n >= len(encoded) {
+		return ""
+	}
+	return string(utf16.Decode(encoded[n:]))
+}
+
+// shiftEntities moves entities to match text with its first n UTF-16 code
+// units removed, dropping entities that only covered the removed part.
+func shiftEntities(entities []models.MessageEntity, n int) []models.MessageEntity {
+	shifted := make([]models.MessageEntity, 0, len(entities))
+	for _, entity := range entities {
+		if entity.Offset+entity.Length <= n {
+			continue
+		}
+		if entity.Offset < n {
+			entity.Length -= n - entity.Offset
+			entity.Offset = 0
+		} else {
+			entity.Offset -= n
+		}
+		shifted = append(shifted, entity)
+	}
+	return shifted
+}
+
+// logoutHandler removes the named account, or the active one, of the user.
+func (s *Service) logoutHandler(ctx context.Context, b *bot.Bot, m *models.Update) {
+	userID := m.Message.From.ID
+	account := strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(m.Message.Text, commandLogout)), "!")
+	credential, ok := s.store.GetUserCredential(userID)
+	if account != "" {
+		credential, ok = s.store.GetUserAccountCredential(userID, account)
+	}
+	if !ok {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   "No such account. Use /accounts to list your accounts.",
+		})
+		return
+	}
+
+	_, err := s.store.RemoveUserCredential(userID, credential.Account)
+	s.invalidateToken(credential.Instance, credential.AccessToken)
+	result, details := auditResult(err)
+	s.audit.Record(auditEvent{
+		Action:  auditActionTokenRemove,
+		UserID:  userID,
+		ChatID:  m.Message.Chat.ID,
+		Account: credential.Account,
+		Result:  result,
+		Details: details,
+	})
+	if err != nil {
+		s.sendError(m.Message.Chat.ID, err)
+		return
+	}
+
+	text := fmt.Sprintf("Logged out of the %s account.", credential.Account)
+	if _, active := s.store.ListUserCredentials(userID); active != "" {
+		text += fmt.Sprintf(" The active account is now %s.", active)
+	}
+	s.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: m.Message.Chat.ID,
+		Text:   text,
+	})
+}
@@ -0,0 +1,43 @@
+package memogram
+
+import (
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestSplitAccountPrefix(t *testing.T) {
+	name, length := splitAccountPrefix("!work  buy milk")
+	if name != "work" || length != 7 {
+		t.Fatalf("unexpected prefix %q with length %d", name, length)
+	}
+
+	for _, text := range []string{"buy milk", "!work", "! work", "!not/valid text"} {
+		if name, _ := splitAccountPrefix(text); name != "" {
+			t.Fatalf("expected no account prefix in %q, got %q", text, name)
+		}
+	}
+}
+
+func TestAccountPrefixKeepsEntities(t *testing.T) {
+	content := "!work See example.com and bold"
+	entities := []models.MessageEntity{
+		{
+			Type:   models.MessageEntityTypeURL,
+			Offset: 10,
+			Length: 11,
+		},
+		{
+			Type:   models.MessageEntityTypeBold,
+			Offset: 26,
+			Length: 4,
+		},
+	}
+
+	_, length := splitAccountPrefix(content)
+	got := formatContent(trimUTF16Prefix(content, length), shiftEntities(entities, length))
+	want := "See [example.com](example.com) and **bold**"
+	if got != want {
+		t.Fatalf("unexpected content:\nwant: %q\ngot:  %q", want, got)
+	}
+}
@@ -0,0 +1,142 @@
+package memogram
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+)
+
+// Audited actions.
+const (
+	auditActionTokenSet       = "token.set"
+	auditActionTokenRemove    = "token.remove"
+	auditActionMemoCreate     = "memo.create"
+	auditActionMemoUpdate     = "memo.update"
+	auditActionMemoVisibility = "memo.visibility"
+	auditActionAccess         = "access"
+	auditActionAccessRequest  = "access.request"
+	auditActionAccessApprove  = "access.approve"
+	auditActionAccessDeny     = "access.deny"
+	auditActionAdminAllow     = "admin.allow"
+	auditActionAdminDeny      = "admin.deny"
+	auditActionAdminUsers     = "admin.users"
+)
+
+// Results of audited actions.
+const (
+	auditResultSuccess = "success"
+	auditResultFailure = "failure"
+	auditResultDenied  = "denied"
+)
+
+// auditEvent is one line of the audit log.
+type auditEvent struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	// UserID is the Telegram user who performed the action.
+	UserID int64 `json:"user_id"`
+	// TargetUserID is the Telegram user the action was performed on, if any.
+	TargetUserID int64  `json:"target_user_id,omitempty"`
+	ChatID       int64  `json:"chat_id,omitempty"`
+	Account      string `json:"account,omitempty"`
+	Memo         string `json:"memo,omitempty"`
+	Result       string `json:"result"`
+	Details      string `json:"details,omitempty"`
+}
+
+// auditResult returns the result and details of an action that failed with
+// err, or succeeded if err is nil.
+func auditResult(err error) (string, string) {
+	if err != nil {
+		return auditResultFailure, err.Error()
+	}
+	return auditResultSuccess, ""
+}
+
+// joinDetails joins the non-empty parts of details.
+func joinDetails(parts ...string) string {
+	var nonEmpty []string
+	for _, part := range parts {
+		if part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+	return strings.Join(nonEmpty, ": ")
+}
+
+// auditTokenSet records an attempt to store a Memos access token for the
+// sender of m.
+func (s *Service) auditTokenSet(m *models.Update, account, result, details string) {
+	s.audit.Record(auditEvent{
+		Action:  auditActionTokenSet,
+		UserID:  m.Message.From.ID,
+		ChatID:  m.Message.Chat.ID,
+		Account: account,
+		Result:  result,
+		Details: details,
+	})
+}
+
+// auditMemoCreation records the creation of a memo from m.
+func (s *Service) auditMemoCreation(m *models.Update, account string, memo *v1pb.Memo, err error) {
+	result, details := auditResult(err)
+	s.audit.Record(auditEvent{
+		Action:  auditActionMemoCreate,
+		UserID:  m.Message.From.ID,
+		ChatID:  m.Message.Chat.ID,
+		Account: account,
+		Memo:    memo.GetName(),
+		Result:  result,
+		Details: details,
+	})
+}
+
+// auditLog appends events as JSON lines to a file. A nil auditLog discards
+// all events.
+type auditLog struct {
+	mutex  sync.Mutex
+	file   *os.File
+	logger *slog.Logger
+}
+
+func newAuditLog(path string, logger *slog.Logger) (*auditLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log %s: %w", path, err)
+	}
+	return &auditLog{file: file, logger: logger}, nil
+}
+
+// Record appends the event to the audit log.
+func (a *auditLog) Record(event auditEvent) {
+	if a == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	// Details often come from errors, which must not leak credentials into
+	// the audit log either.
+	event.Details = redactSecrets(event.Details)
+	line, err := json.Marshal(event)
+	if err != nil {
+		a.logger.Error("failed to encode audit event", slog.Any("err", err))
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		a.logger.Error("failed to write audit event", slog.String("action", event.Action), slog.Any("err", err))
+	}
+}
@@ -0,0 +1,61 @@
+package memogram
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLogAppendsJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	for _, memo := range []string{"memos/1", "memos/2"} {
+		// Reopen the log to check that existing events are kept.
+		audit, err := newAuditLog(path, slog.Default())
+		if err != nil {
+			t.Fatalf("open audit log: %v", err)
+		}
+		result, details := auditResult(nil)
+		audit.Record(auditEvent{Action: auditActionMemoCreate, UserID: 42, Memo: memo, Result: result, Details: details})
+	}
+	audit, err := newAuditLog(path, slog.Default())
+	if err != nil {
+		t.Fatalf("open audit log: %v", err)
+	}
+	result, details := auditResult(errors.New("unauthenticated: memos_pat_abc123"))
+	audit.Record(auditEvent{Action: auditActionTokenSet, UserID: 42, Result: result, Details: details})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 events, got %q", data)
+	}
+	var events []auditEvent
+	for _, line := range lines {
+		var event auditEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("decode %q: %v", line, err)
+		}
+		events = append(events, event)
+	}
+	if events[0].Memo != "memos/1" || events[1].Memo != "memos/2" || events[0].Time.IsZero() {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	if events[2].Result != auditResultFailure || strings.Contains(events[2].Details, "memos_pat_") {
+		t.Fatalf("expected a redacted failure, got %+v", events[2])
+	}
+}
+
+func TestNilAuditLogDiscardsEvents(t *testing.T) {
+	audit, err := newAuditLog("", slog.Default())
+	if err != nil || audit != nil {
+		t.Fatalf("expected no audit log, got %v, %v", audit, err)
+	}
+	audit.Record(auditEvent{Action: auditActionAccess, Result: auditResultDenied})
+}
@@ -2,15 +2,166 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
 
 	"github.com/usememos/memogram"
+	"github.com/usememos/memogram/store"
 )
 
+// version is set at build time with `-ldflags "-X main.version=<version>"`,
+// see scripts/build.sh, the Dockerfile and .goreleaser.yaml.
+var version = "dev"
+
+const usage = `Usage: memogram [command]
+
+Commands:
+  run                          Start the bot (default)
+  check                        Validate the config and test the connections to Memos and Telegram
+  users list                   List the accounts in the store
+  users revoke <user_id> [account]
+                               Remove an account, or all accounts of a user, from the store
+  rules test [flags] <text>    Show which rules match a message and how it would be saved
+                               (run "memogram rules test -h" for the flags)
+  migrate <from> <to>          Copy the store to another file, converting between the
+                               text and JSON formats by file extension (.json)
+  version                      Print build information
+`
+
 func main() {
-	ctx := context.Background()
-	service, err := memogram.NewService()
+	// Never write credentials to the log, whichever package logs them.
+	slog.SetDefault(slog.New(memogram.NewRedactingHandler(slog.NewTextHandler(os.Stderr, nil))))
+
+	if err := run(context.Background(), os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "memogram: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	command := "run"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "run":
+		service, err := memogram.NewService()
+		if err != nil {
+			return err
+		}
+		service.Start(ctx)
+		return nil
+	case "check":
+		config, err := memogram.LoadConfig()
+		if err != nil {
+			return err
+		}
+		return memogram.Check(ctx, config, os.Stdout)
+	case "users":
+		return users(args)
+	case "rules":
+		return rules(args)
+	case "migrate":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: memogram migrate <from> <to>")
+		}
+		if err := store.Migrate(args[0], args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Migrated %s to %s\n", args[0], args[1])
+		return nil
+	case "version":
+		printVersion()
+		return nil
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", command, usage)
+	}
+}
+
+func users(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: memogram users list|revoke")
+	}
+	// The store commands don't need the bot settings.
+	config, err := memogram.LoadStoreConfig()
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		return memogram.ListUsers(config, os.Stdout)
+	case "revoke":
+		if len(args) < 2 || len(args) > 3 {
+			return fmt.Errorf("usage: memogram users revoke <user_id> [account]")
+		}
+		userID, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid user ID %q", args[1])
+		}
+		var account string
+		if len(args) == 3 {
+			account = args[2]
+		}
+		if err := memogram.RevokeUser(config, userID, account); err != nil {
+			return err
+		}
+		fmt.Println("Revoked. Send SIGHUP to the running bot or restart it to apply the change.")
+		return nil
+	default:
+		return fmt.Errorf("unknown users command %q", args[0])
+	}
+}
+
+func rules(args []string) error {
+	if len(args) == 0 || args[0] != "test" {
+		return fmt.Errorf("usage: memogram rules test [flags] <text>")
+	}
+	flags := flag.NewFlagSet("rules test", flag.ContinueOnError)
+	var input memogram.RuleInput
+	flags.Int64Var(&input.ChatID, "chat", 0, "ID of the chat the message is sent in")
+	forwardFrom := flags.String("forward-from", "", "username or name the message is forwarded from")
+	flags.Int64Var(&input.ForwardChatID, "forward-chat", 0, "ID of the chat or channel the message is forwarded from")
+	flags.StringVar(&input.Media, "media", "text", "text, photo, document, voice or video")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	input.Content = strings.Join(flags.Args(), " ")
+	input.ForwardName = *forwardFrom
+	input.ForwardUsername = strings.TrimPrefix(*forwardFrom, "@")
+
+	config, err := memogram.LoadConfig()
+	if err != nil {
+		return err
+	}
+	return memogram.TestRules(config, input, os.Stdout)
+}
+
+func printVersion() {
+	fmt.Printf("memogram %s\n", version)
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	fmt.Printf("go: %s\n", info.GoVersion)
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision", "vcs.time", "vcs.modified":
+			fmt.Printf("%s: %s\n", setting.Key, setting.Value)
+		}
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/usememos/memos" {
+			fmt.Printf("memos: %s\n", dep.Version)
+		}
 	}
-	service.Start(ctx)
 }
@@ -0,0 +1,198 @@
+package memogram
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/go-telegram/bot"
+	"github.com/usememos/memogram/store"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+)
+
+// checkTimeout bounds each connectivity check.
+const checkTimeout = 10 * time.Second
+
+// Check tests that Memos and Telegram can be reached with the configuration,
+// writing one line per check to w.
+func Check(ctx context.Context, config *Config, w io.Writer) error {
+	var failed []string
+	report := func(name string, err error, detail string) {
+		if err != nil {
+			failed = append(failed, name)
+			fmt.Fprintf(w, "FAIL %s: %s\n", name, err)
+			return
+		}
+		fmt.Fprintf(w, "ok   %s: %s\n", name, detail)
+	}
+
+	fmt.Fprintln(w, "ok   config: valid")
+
+	memosCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	client, err := newConfiguredMemosClient(config.ServerAddr, config.MemosClient)
+	if err == nil {
+		profile, err := client.InstanceService.GetInstanceProfile(memosCtx, connect.NewRequest(&v1pb.GetInstanceProfileRequest{}))
+		if err == nil {
+			report("memos", nil, fmt.Sprintf("%s (version %s)", client.baseURL, profile.Msg.GetVersion()))
+		} else {
+			report("memos", err, "")
+		}
+	} else {
+		report("memos", err, "")
+	}
+
+	telegramCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	opts := []bot.Option{bot.WithSkipGetMe()}
+	if config.BotProxyAddr != "" {
+		opts = append(opts, bot.WithServerURL(config.BotProxyAddr))
+	}
+	b, err := bot.New(config.BotToken, opts...)
+	if err == nil {
+		me, err := b.GetMe(telegramCtx)
+		if err == nil {
+			report("telegram", nil, "@"+me.Username)
+		} else {
+			report("telegram", err, "")
+		}
+	} else {
+		report("telegram", err, "")
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("checks failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// ListUsers writes the accounts in the store to w. Access tokens are never
+// shown.
+func ListUsers(config *Config, w io.Writer) error {
+	s, err := openStore(config)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "USER ID\tACCOUNT\tINSTANCE\tACTIVE")
+	for _, userID := range s.ListUserIDs() {
+		credentials, active := s.ListUserCredentials(userID)
+		for _, credential := range credentials {
+			instance := credential.Instance
+			if instance == "" {
+				instance = "(default)"
+			}
+			marker := ""
+			if credential.Account == active {
+				marker = "*"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", userID, credential.Account, instance, marker)
+		}
+	}
+	return tw.Flush()
+}
+
+// RevokeUser removes the named account of the user from the store, or all of
+// the user's accounts if account is empty, and records it in the audit log.
+func RevokeUser(config *Config, userID int64, account string) error {
+	s, err := openStore(config)
+	if err != nil {
+		return err
+	}
+	audit, err := newAuditLog(config.AuditLog, slog.Default())
+	if err != nil {
+		return err
+	}
+
+	removed, err := s.RemoveUserCredential(userID, account)
+	result, details := auditResult(err)
+	if err == nil && !removed {
+		result, details = auditResultFailure, "no such account"
+	}
+	audit.Record(auditEvent{
+		Action:       auditActionTokenRemove,
+		TargetUserID: userID,
+		Account:      account,
+		Result:       result,
+		Details:      joinDetails("revoked with the CLI", details),
+	})
+	if err != nil {
+		return err
+	}
+	if !removed {
+		if account == "" {
+			return fmt.Errorf("user %d has no accounts", userID)
+		}
+		return fmt.Errorf("user %d has no account %q", userID, account)
+	}
+	return nil
+}
+
+// openStore opens the existing store of the configuration.
+func openStore(config *Config) (*store.Store, error) {
+	if _, err := os.Stat(config.Data); err != nil {
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+	s := store.NewStore(config.Data)
+	if err := s.Init(); err != nil {
+		return nil, fmt.Errorf("failed to init store: %w", err)
+	}
+	return s, nil
+}
+
+// TestRules runs the rules of the config on input and writes which rules
+// matched and how the message would be saved to w. Without hashtags, those of
+// the content are used.
+func TestRules(config *Config, input RuleInput, w io.Writer) error {
+	rules, err := compileRules(config.Rules)
+	if err != nil {
+		return err
+	}
+	if input.Media == "" {
+		input.Media = "text"
+	}
+	if input.Hashtags == nil {
+		for _, field := range strings.Fields(input.Content) {
+			if strings.HasPrefix(field, "#") && len(field) > 1 {
+				input.Hashtags = append(input.Hashtags, normalizeHashtag(field))
+			}
+		}
+	}
+
+	result := applyRules(rules, input)
+	for _, r := range rules {
+		marker := "-"
+		if slices.Contains(result.matched, r.config.Name) {
+			marker = "+"
+		}
+		fmt.Fprintf(w, "%s %s\n", marker, r.config.Name)
+	}
+	fmt.Fprintln(w)
+	if result.droppedBy != "" {
+		fmt.Fprintf(w, "dropped by %s\n", result.droppedBy)
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	visibility := "(route or default)"
+	if result.visibility != v1pb.Visibility_VISIBILITY_UNSPECIFIED {
+		visibility = result.visibility.String()
+	}
+	account := "(active)"
+	if result.account != "" {
+		account = result.account
+	}
+	fmt.Fprintf(tw, "account:\t%s\n", account)
+	fmt.Fprintf(tw, "visibility:\t%s\n", visibility)
+	fmt.Fprintf(tw, "pin:\t%t\n", result.pinnedBy != "")
+	fmt.Fprintf(tw, "content:\t%q\n", appendTags(result.stripContent(input.Content), result.tags))
+	return tw.Flush()
+}
@@ -0,0 +1,69 @@
+package memogram
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/usememos/memogram/store"
+)
+
+func TestListAndRevokeUsers(t *testing.T) {
+	dir := t.TempDir()
+	config := &Config{
+		Data:     filepath.Join(dir, "data.txt"),
+		AuditLog: filepath.Join(dir, "audit.log"),
+	}
+	s := store.NewStore(config.Data)
+	if err := s.Init(); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	s.SetUserCredential(42, store.Credential{AccessToken: "secret-token"})
+	s.SetUserCredential(42, store.Credential{Account: "work", Instance: "https://memos.example.com", AccessToken: "work-token"})
+
+	var out strings.Builder
+	if err := ListUsers(config, &out); err != nil {
+		t.Fatalf("list users: %v", err)
+	}
+	if !strings.Contains(out.String(), "https://memos.example.com") || strings.Contains(out.String(), "token") {
+		t.Fatalf("unexpected user list:\n%s", out.String())
+	}
+
+	if err := RevokeUser(config, 42, "work"); err != nil {
+		t.Fatalf("revoke user: %v", err)
+	}
+	if err := RevokeUser(config, 42, "work"); err == nil {
+		t.Fatal("expected revoking a missing account to fail")
+	}
+	if err := s.Reload(); err != nil {
+		t.Fatalf("reload store: %v", err)
+	}
+	if _, ok := s.GetUserAccountCredential(42, "work"); ok {
+		t.Fatal("expected the work account to be revoked")
+	}
+
+	audit, err := os.ReadFile(config.AuditLog)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	if !strings.Contains(string(audit), `"action":"token.remove","user_id":0,"target_user_id":42`) {
+		t.Fatalf("expected a token.remove event, got %s", audit)
+	}
+}
+
+func TestCheckReportsMemosClientErrors(t *testing.T) {
+	config := &Config{
+		ServerAddr:  "http://localhost:5230",
+		MemosClient: &MemosClientConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+	}
+	var out strings.Builder
+	err := Check(context.Background(), config, &out)
+	if err == nil || !strings.Contains(err.Error(), "memos, telegram") {
+		t.Fatalf("expected both checks to fail, got %v", err)
+	}
+	if !strings.Contains(out.String(), "FAIL memos: ") || !strings.Contains(out.String(), "FAIL telegram: ") {
+		t.Fatalf("expected a report line per check, got:\n%s", out.String())
+	}
+}
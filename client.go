@@ -1,52 +1,197 @@
 package memogram
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
 
+	"connectrpc.com/connect"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
 	"github.com/usememos/memos/proto/gen/api/v1/apiv1connect"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Protocols the Memos client can talk.
+const (
+	ProtocolConnect = "connect"
+	ProtocolGRPC    = "grpc"
+	ProtocolGRPCWeb = "grpcweb"
+)
+
+const (
+	defaultMemosTimeout     = time.Minute
+	defaultMemosDialTimeout = 10 * time.Second
+
+	defaultMemosKeepaliveTimeout = 20 * time.Second
+)
+
+// The Memos API calls Memogram makes. The generated apiv1connect clients
+// implement them, and tests may substitute their own implementations.
+type (
+	MemosInstanceService interface {
+		GetInstanceProfile(context.Context, *connect.Request[v1pb.GetInstanceProfileRequest]) (*connect.Response[v1pb.InstanceProfile], error)
+	}
+	MemosAuthService interface {
+		GetCurrentUser(context.Context, *connect.Request[v1pb.GetCurrentUserRequest]) (*connect.Response[v1pb.GetCurrentUserResponse], error)
+		SignIn(context.Context, *connect.Request[v1pb.SignInRequest]) (*connect.Response[v1pb.SignInResponse], error)
+	}
+	MemosUserService interface {
+		GetUserStats(context.Context, *connect.Request[v1pb.GetUserStatsRequest]) (*connect.Response[v1pb.UserStats], error)
+		CreatePersonalAccessToken(context.Context, *connect.Request[v1pb.CreatePersonalAccessTokenRequest]) (*connect.Response[v1pb.CreatePersonalAccessTokenResponse], error)
+	}
+	MemosMemoService interface {
+		CreateMemo(context.Context, *connect.Request[v1pb.CreateMemoRequest]) (*connect.Response[v1pb.Memo], error)
+		GetMemo(context.Context, *connect.Request[v1pb.GetMemoRequest]) (*connect.Response[v1pb.Memo], error)
+		ListMemos(context.Context, *connect.Request[v1pb.ListMemosRequest]) (*connect.Response[v1pb.ListMemosResponse], error)
+		UpdateMemo(context.Context, *connect.Request[v1pb.UpdateMemoRequest]) (*connect.Response[v1pb.Memo], error)
+		SetMemoRelations(context.Context, *connect.Request[v1pb.SetMemoRelationsRequest]) (*connect.Response[emptypb.Empty], error)
+		ListMemoRelations(context.Context, *connect.Request[v1pb.ListMemoRelationsRequest]) (*connect.Response[v1pb.ListMemoRelationsResponse], error)
+		ListMemoReactions(context.Context, *connect.Request[v1pb.ListMemoReactionsRequest]) (*connect.Response[v1pb.ListMemoReactionsResponse], error)
+		UpsertMemoReaction(context.Context, *connect.Request[v1pb.UpsertMemoReactionRequest]) (*connect.Response[v1pb.Reaction], error)
+		DeleteMemoReaction(context.Context, *connect.Request[v1pb.DeleteMemoReactionRequest]) (*connect.Response[emptypb.Empty], error)
+	}
+	MemosAttachmentService interface {
+		CreateAttachment(context.Context, *connect.Request[v1pb.CreateAttachmentRequest]) (*connect.Response[v1pb.Attachment], error)
+	}
+	MemosShortcutService interface {
+		ListShortcuts(context.Context, *connect.Request[v1pb.ListShortcutsRequest]) (*connect.Response[v1pb.ListShortcutsResponse], error)
+		GetShortcut(context.Context, *connect.Request[v1pb.GetShortcutRequest]) (*connect.Response[v1pb.Shortcut], error)
+	}
 )
 
 type MemosClient struct {
-	baseURL string
+	baseURL    string
+	httpClient *http.Client
+	options    []connect.ClientOption
 
-	InstanceService   apiv1connect.InstanceServiceClient
-	AuthService       apiv1connect.AuthServiceClient
-	UserService       apiv1connect.UserServiceClient
-	MemoService       apiv1connect.MemoServiceClient
-	AttachmentService apiv1connect.AttachmentServiceClient
+	InstanceService   MemosInstanceService
+	AuthService       MemosAuthService
+	UserService       MemosUserService
+	MemoService       MemosMemoService
+	AttachmentService MemosAttachmentService
+	ShortcutService   MemosShortcutService
 }
 
 // NewMemosClient creates a new client using Connect protocol
 // baseURL should be the full HTTP URL (e.g., "http://localhost:8081")
 func NewMemosClient(baseURL string) *MemosClient {
-	httpClient := http.DefaultClient
+	return NewMemosClientWithHTTPClient(baseURL, http.DefaultClient)
+}
 
+// NewMemosClientWithHTTPClient creates a client that sends its requests, and
+// those of the authenticated clients derived from it, with httpClient. The
+// options, such as connect.WithGRPC, apply to all of them.
+func NewMemosClientWithHTTPClient(baseURL string, httpClient *http.Client, options ...connect.ClientOption) *MemosClient {
 	return &MemosClient{
 		baseURL:           baseURL,
-		InstanceService:   apiv1connect.NewInstanceServiceClient(httpClient, baseURL),
-		AuthService:       apiv1connect.NewAuthServiceClient(httpClient, baseURL),
-		UserService:       apiv1connect.NewUserServiceClient(httpClient, baseURL),
-		MemoService:       apiv1connect.NewMemoServiceClient(httpClient, baseURL),
-		AttachmentService: apiv1connect.NewAttachmentServiceClient(httpClient, baseURL),
+		httpClient:        httpClient,
+		options:           options,
+		InstanceService:   apiv1connect.NewInstanceServiceClient(httpClient, baseURL, options...),
+		AuthService:       apiv1connect.NewAuthServiceClient(httpClient, baseURL, options...),
+		UserService:       apiv1connect.NewUserServiceClient(httpClient, baseURL, options...),
+		MemoService:       apiv1connect.NewMemoServiceClient(httpClient, baseURL, options...),
+		AttachmentService: apiv1connect.NewAttachmentServiceClient(httpClient, baseURL, options...),
+		ShortcutService:   apiv1connect.NewShortcutServiceClient(httpClient, baseURL, options...),
+	}
+}
+
+// newConfiguredMemosClient creates the client of the server at addr with the
+// transport and protocol of config. The host of a dns target is resolved as
+// described by newTargetDialer.
+func newConfiguredMemosClient(addr string, config *MemosClientConfig) (*MemosClient, error) {
+	target, isTarget, err := parseGRPCTarget(addr)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := newMemosHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+	options, err := memosClientOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	if isTarget {
+		transport := httpClient.Transport.(*http.Transport)
+		if dialer := newTargetDialer(target, transport.DialContext); dialer != nil {
+			transport.DialContext = dialer.DialContext
+		}
+	}
+	return NewMemosClientWithHTTPClient(normalizeInstanceURL(addr), httpClient, options...), nil
+}
+
+// memosClientOptions returns the client options of the protocol of config.
+func memosClientOptions(config *MemosClientConfig) ([]connect.ClientOption, error) {
+	if config == nil {
+		return nil, nil
+	}
+	switch config.Protocol {
+	case "", ProtocolConnect:
+		return nil, nil
+	case ProtocolGRPC:
+		return []connect.ClientOption{connect.WithGRPC()}, nil
+	case ProtocolGRPCWeb:
+		return []connect.ClientOption{connect.WithGRPCWeb()}, nil
 	}
+	return nil, fmt.Errorf("unknown protocol %q", config.Protocol)
 }
 
-// NewAuthenticatedClient creates a new client with authentication
-func (c *MemosClient) NewAuthenticatedClient(accessToken string) *MemosClient {
+// normalizeInstanceURL turns a server address into the base URL of a Memos
+// instance. The address can be "localhost:8081", a gRPC target such as
+// "dns:localhost:8081" or "dns://8.8.8.8/localhost:8081", or
+// "http://localhost:8081".
+func normalizeInstanceURL(addr string) string {
+	baseURL := strings.TrimSpace(addr)
+	if target, ok, _ := parseGRPCTarget(baseURL); ok {
+		baseURL = target.endpoint
+	}
+	// Add http:// if no scheme present
+	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
+		baseURL = "http://" + baseURL
+	}
+	return strings.TrimSuffix(baseURL, "/")
+}
+
+// withBaseURL returns a client of another instance that shares the HTTP
+// client of c.
+func (c *MemosClient) withBaseURL(baseURL string) *MemosClient {
+	return NewMemosClientWithHTTPClient(baseURL, c.httpClient, c.options...)
+}
+
+// NewAuthenticatedClient creates a new client with authentication. It shares
+// the transport, and so the connection pool, of c. The options are added to
+// those of c.
+func (c *MemosClient) NewAuthenticatedClient(accessToken string, options ...connect.ClientOption) *MemosClient {
+	transport := c.httpClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
 	httpClient := &http.Client{
 		Transport: &authTransport{
 			token:     accessToken,
-			transport: http.DefaultTransport,
+			transport: transport,
 		},
+		Timeout: c.httpClient.Timeout,
 	}
+	options = append(append([]connect.ClientOption{}, c.options...), options...)
 
 	return &MemosClient{
 		baseURL:           c.baseURL,
-		InstanceService:   apiv1connect.NewInstanceServiceClient(httpClient, c.baseURL),
-		AuthService:       apiv1connect.NewAuthServiceClient(httpClient, c.baseURL),
-		UserService:       apiv1connect.NewUserServiceClient(httpClient, c.baseURL),
-		MemoService:       apiv1connect.NewMemoServiceClient(httpClient, c.baseURL),
-		AttachmentService: apiv1connect.NewAttachmentServiceClient(httpClient, c.baseURL),
+		httpClient:        c.httpClient,
+		options:           c.options,
+		InstanceService:   apiv1connect.NewInstanceServiceClient(httpClient, c.baseURL, options...),
+		AuthService:       apiv1connect.NewAuthServiceClient(httpClient, c.baseURL, options...),
+		UserService:       apiv1connect.NewUserServiceClient(httpClient, c.baseURL, options...),
+		MemoService:       apiv1connect.NewMemoServiceClient(httpClient, c.baseURL, options...),
+		AttachmentService: apiv1connect.NewAttachmentServiceClient(httpClient, c.baseURL, options...),
+		ShortcutService:   apiv1connect.NewShortcutServiceClient(httpClient, c.baseURL, options...),
 	}
 }
 
@@ -62,3 +207,80 @@ func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	}
 	return t.transport.RoundTrip(req)
 }
+
+// newMemosHTTPClient returns the HTTP client for all requests to Memos, with
+// one transport tuned by config.
+func newMemosHTTPClient(config *MemosClientConfig) (*http.Client, error) {
+	if config == nil {
+		config = &MemosClientConfig{}
+	}
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = defaultMemosTimeout
+	}
+	dialTimeout := config.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = defaultMemosDialTimeout
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = dialTimeout
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.CertFile != "" || config.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	if config.Proxy != "" {
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", config.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if config.Protocol == ProtocolGRPC {
+		// gRPC needs HTTP/2, also without TLS (h2c with prior knowledge).
+		protocols := new(http.Protocols)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		transport.Protocols = protocols
+	}
+	if config.KeepaliveTime > 0 {
+		// Ping idle HTTP/2 connections so that dead ones are noticed.
+		keepaliveTimeout := config.KeepaliveTimeout
+		if keepaliveTimeout == 0 {
+			keepaliveTimeout = defaultMemosKeepaliveTimeout
+		}
+		transport.HTTP2 = &http.HTTP2Config{
+			SendPingTimeout: config.KeepaliveTime,
+			PingTimeout:     keepaliveTimeout,
+		}
+	}
+
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
@@ -0,0 +1,122 @@
+package memogram
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+	"github.com/usememos/memos/proto/gen/api/v1/apiv1connect"
+)
+
+func TestMemosHTTPClientTrustsCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, certificate, 0600); err != nil {
+		t.Fatalf("write CA file: %v", err)
+	}
+
+	untrusted, err := newMemosHTTPClient(nil)
+	if err != nil {
+		t.Fatalf("newMemosHTTPClient: %v", err)
+	}
+	if _, err := untrusted.Get(server.URL); err == nil {
+		t.Fatalf("expected an unknown authority error without the CA file")
+	}
+
+	httpClient, err := newMemosHTTPClient(&MemosClientConfig{CAFile: caFile, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("newMemosHTTPClient: %v", err)
+	}
+	resp, err := httpClient.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request with CA file: %v", err)
+	}
+	resp.Body.Close()
+
+}
+
+func TestMemosHTTPClientRejectsInvalidConfig(t *testing.T) {
+	emptyFile := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(emptyFile, nil, 0600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	for name, config := range map[string]*MemosClientConfig{
+		"missing CA file": {CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		"empty CA file":   {CAFile: emptyFile},
+		"invalid cert":    {CertFile: emptyFile, KeyFile: emptyFile},
+		"invalid proxy":   {Proxy: "proxy:3128"},
+	} {
+		if _, err := newMemosHTTPClient(config); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestMemosClientProtocols(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle(apiv1connect.NewInstanceServiceHandler(&fakeInstanceService{
+		profile: &v1pb.InstanceProfile{Version: "0.27.1"},
+	}))
+	var contentType string
+	var protoMajor int
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType, protoMajor = r.Header.Get("Content-Type"), r.ProtoMajor
+		mux.ServeHTTP(w, r)
+	}))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	t.Cleanup(server.Close)
+
+	for _, test := range []struct {
+		protocol    string
+		contentType string
+		protoMajor  int
+	}{
+		{"", "application/proto", 1},
+		{ProtocolGRPC, "application/grpc", 2},
+		{ProtocolGRPCWeb, "application/grpc-web+proto", 1},
+	} {
+		addr := "dns:///" + strings.TrimPrefix(server.URL, "http://")
+		client, err := newConfiguredMemosClient(addr, &MemosClientConfig{Protocol: test.protocol})
+		if err != nil {
+			t.Fatalf("%q: new client: %v", test.protocol, err)
+		}
+		resp, err := client.InstanceService.GetInstanceProfile(context.Background(), connect.NewRequest(&v1pb.GetInstanceProfileRequest{}))
+		if err != nil {
+			t.Fatalf("%q: get instance profile: %v", test.protocol, err)
+		}
+		if resp.Msg.GetVersion() != "0.27.1" {
+			t.Fatalf("%q: unexpected profile %v", test.protocol, resp.Msg)
+		}
+		if contentType != test.contentType || protoMajor != test.protoMajor {
+			t.Fatalf("%q: expected %s over HTTP/%d, got %s over HTTP/%d", test.protocol, test.contentType, test.protoMajor, contentType, protoMajor)
+		}
+	}
+}
+
+func TestNormalizeInstanceURL(t *testing.T) {
+	for addr, want := range map[string]string{
+		"localhost:5230":              "http://localhost:5230",
+		"dns:localhost:5230":          "http://localhost:5230",
+		"dns:///localhost:5230":       "http://localhost:5230",
+		"dns://8.8.8.8/memos.io:5230": "http://memos.io:5230",
+		"https://memos.io/":           "https://memos.io",
+	} {
+		if got := normalizeInstanceURL(addr); got != want {
+			t.Fatalf("normalizeInstanceURL(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package memogram
+
+import (
+	"context"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/usememos/memogram/store"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+)
+
+// currentUserTTL is how long the user an access token belongs to is cached.
+const currentUserTTL = 10 * time.Minute
+
+// tokenKey identifies an access token of an instance in the client caches.
+type tokenKey struct {
+	instance    string
+	accessToken string
+}
+
+type cachedUser struct {
+	user    *v1pb.User
+	expires time.Time
+}
+
+// authClient returns the authenticated client for accessToken on instance,
+// creating it on first use. A client is dropped from the cache as soon as
+// Memos rejects its token.
+func (s *Service) authClient(instance, accessToken string) *MemosClient {
+	key := tokenKey{instance: instance, accessToken: accessToken}
+	if client, ok := s.authClients.Load(key); ok {
+		return client.(*MemosClient)
+	}
+	client := s.memosClient(instance).NewAuthenticatedClient(accessToken,
+		connect.WithInterceptors(invalidateOnUnauthenticated(func() {
+			s.invalidateToken(instance, accessToken)
+		})))
+	actual, _ := s.authClients.LoadOrStore(key, client)
+	return actual.(*MemosClient)
+}
+
+// currentUser returns the user accessToken belongs to, asking Memos at most
+// once per currentUserTTL.
+func (s *Service) currentUser(ctx context.Context, instance, accessToken string) (*v1pb.User, error) {
+	key := tokenKey{instance: instance, accessToken: accessToken}
+	if cached, ok := s.currentUsers.Load(key); ok && time.Now().Before(cached.(cachedUser).expires) {
+		return cached.(cachedUser).user, nil
+	}
+	resp, err := s.authClient(instance, accessToken).AuthService.GetCurrentUser(ctx, connect.NewRequest(&v1pb.GetCurrentUserRequest{}))
+	if err != nil {
+		return nil, err
+	}
+	s.currentUsers.Store(key, cachedUser{user: resp.Msg.User, expires: time.Now().Add(currentUserTTL)})
+	return resp.Msg.User, nil
+}
+
+// invalidateToken drops the cached client, user and tag counts of
+// accessToken.
+func (s *Service) invalidateToken(instance, accessToken string) {
+	key := tokenKey{instance: instance, accessToken: accessToken}
+	s.authClients.Delete(key)
+	s.currentUsers.Delete(key)
+	s.tagCounts.Delete(key)
+}
+
+// setUserCredential stores credential and drops the caches of the token it
+// replaces, if any.
+func (s *Service) setUserCredential(userID int64, credential store.Credential) {
+	account := credential.Account
+	if account == "" {
+		account = store.DefaultAccount
+	}
+	if previous, ok := s.store.GetUserAccountCredential(userID, account); ok && previous != credential {
+		s.invalidateToken(previous.Instance, previous.AccessToken)
+	}
+	s.store.SetUserCredential(userID, credential)
+}
+
+// invalidateOnUnauthenticated calls invalidate whenever Memos answers a
+// request with CodeUnauthenticated.
+func invalidateOnUnauthenticated(invalidate func()) connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			resp, err := next(ctx, req)
+			if connect.CodeOf(err) == connect.CodeUnauthenticated {
+				invalidate()
+			}
+			return resp, err
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package memogram
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/usememos/memogram/store"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+	"github.com/usememos/memos/proto/gen/api/v1/apiv1connect"
+)
+
+// countingAuthService counts the GetCurrentUser calls of fakeAuthService.
+type countingAuthService struct {
+	fakeAuthService
+	calls atomic.Int32
+}
+
+func (c *countingAuthService) GetCurrentUser(ctx context.Context, req *connect.Request[v1pb.GetCurrentUserRequest]) (*connect.Response[v1pb.GetCurrentUserResponse], error) {
+	c.calls.Add(1)
+	return c.fakeAuthService.GetCurrentUser(ctx, req)
+}
+
+func TestClientCache(t *testing.T) {
+	ctx := context.Background()
+	auth := &countingAuthService{fakeAuthService: fakeAuthService{validToken: "valid"}}
+	mux := http.NewServeMux()
+	mux.Handle(apiv1connect.NewAuthServiceHandler(auth))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	s := newTestAccessService(t)
+	s.client = NewMemosClient(server.URL)
+
+	if s.authClient("", "valid") != s.authClient("", "valid") {
+		t.Fatalf("expected the authenticated client to be cached")
+	}
+	for i := 0; i < 3; i++ {
+		user, err := s.currentUser(ctx, "", "valid")
+		if err != nil || user.GetName() != "users/1" {
+			t.Fatalf("unexpected current user %v, %v", user, err)
+		}
+	}
+	if got := auth.calls.Load(); got != 1 {
+		t.Fatalf("expected one GetCurrentUser call, got %d", got)
+	}
+
+	// A rejected token is dropped from the caches.
+	client := s.authClient("", "expired")
+	if _, err := s.currentUser(ctx, "", "expired"); connect.CodeOf(err) != connect.CodeUnauthenticated {
+		t.Fatalf("expected an unauthenticated error, got %v", err)
+	}
+	if _, ok := s.authClients.Load(tokenKey{accessToken: "expired"}); ok {
+		t.Fatalf("expected the client of the rejected token to be dropped")
+	}
+	if s.authClient("", "expired") == client {
+		t.Fatalf("expected a new client after the token was rejected")
+	}
+
+	// Replacing a token drops the caches of the old one.
+	s.setUserCredential(1, store.Credential{AccessToken: "valid"})
+	s.setUserCredential(1, store.Credential{AccessToken: "other"})
+	if _, ok := s.currentUsers.Load(tokenKey{accessToken: "valid"}); ok {
+		t.Fatalf("expected the user of the replaced token to be dropped")
+	}
+}
+
+func TestLogoutHandler(t *testing.T) {
+	ctx := context.Background()
+	api, b := newFakeBotAPI(t)
+	api.enqueue("sendMessage", fakeSentMessage, fakeSentMessage)
+	s := newTestAccessService(t)
+	s.client = NewMemosClient("http://localhost:5230")
+	s.sender = newTestSender(b)
+	s.store.SetUserCredential(2, store.Credential{AccessToken: "token"})
+	s.store.SetUserCredential(2, store.Credential{Account: "work", AccessToken: "work-token"})
+	s.authClient("", "work-token")
+
+	s.logoutHandler(ctx, b, messageUpdate(2, "/logout"))
+	if _, ok := s.store.GetUserAccountCredential(2, "work"); ok {
+		t.Fatalf("expected the active account to be removed")
+	}
+	if credential, ok := s.store.GetUserCredential(2); !ok || credential.Account != store.DefaultAccount {
+		t.Fatalf("expected the default account to become active, got %+v", credential)
+	}
+	if _, ok := s.authClients.Load(tokenKey{accessToken: "work-token"}); ok {
+		t.Fatalf("expected the client of the removed account to be dropped")
+	}
+
+	s.logoutHandler(ctx, b, messageUpdate(2, "/logout missing"))
+	if _, ok := s.store.GetUserCredential(2); !ok {
+		t.Fatalf("expected an unknown account to leave the others alone")
+	}
+	if got := api.callCount("sendMessage"); got != 2 {
+		t.Fatalf("expected two replies, got %d", got)
+	}
+}
@@ -1,23 +1,230 @@
 package memogram
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/caarlos0/env"
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
+// Config is the configuration of Memogram. It is read from an optional YAML
+// file named by CONFIG_FILE, and environment variables override the values
+// from the file.
 type Config struct {
-	ServerAddr       string `env:"SERVER_ADDR,required"`
-	BotToken         string `env:"BOT_TOKEN,required"`
-	BotProxyAddr     string `env:"BOT_PROXY_ADDR"`
-	Data             string `env:"DATA"`
-	AllowedUsernames string `env:"ALLOWED_USERNAMES"`
+	ServerAddr       string     `yaml:"server_addr" env:"SERVER_ADDR"`
+	BotToken         string     `yaml:"bot_token" env:"BOT_TOKEN"`
+	BotProxyAddr     string     `yaml:"bot_proxy_addr" env:"BOT_PROXY_ADDR"`
+	Data             string     `yaml:"data" env:"DATA"`
+	AllowedUsernames stringList `yaml:"allowed_usernames" env:"ALLOWED_USERNAMES"`
+	AllowedUserIDs   stringList `yaml:"allowed_user_ids" env:"ALLOWED_USER_IDS"`
+	AllowedChatIDs   stringList `yaml:"allowed_chat_ids" env:"ALLOWED_CHAT_IDS"`
+	AdminUserIDs     stringList `yaml:"admin_user_ids" env:"ADMIN_USER_IDS"`
+	AllowedInstances stringList `yaml:"allowed_instances" env:"ALLOWED_INSTANCES"`
+	Concurrency      int        `yaml:"concurrency" env:"CONCURRENCY"`
+	AuditLog         string     `yaml:"audit_log" env:"AUDIT_LOG"`
+
+	// MemosClient is a pointer so that the environment variables of its
+	// fields are parsed as well.
+	MemosClient *MemosClientConfig `yaml:"memos_client"`
+	Limits      LimitsConfig       `yaml:"limits"`
+	Templates   TemplatesConfig    `yaml:"templates"`
+	Routes      []RouteConfig      `yaml:"routes"`
+	Rules       []RuleConfig       `yaml:"rules"`
+	Schedules   []ScheduleConfig   `yaml:"schedules"`
+
+	// File is the config file the configuration was read from, if any.
+	File string `yaml:"-"`
+}
+
+// MemosClientConfig configures the connection to Memos.
+type MemosClientConfig struct {
+	// CAFile is a PEM bundle of certificate authorities to trust in addition
+	// to the system ones.
+	CAFile string `yaml:"ca_file" env:"MEMOS_CA_FILE"`
+	// CertFile and KeyFile are a PEM client certificate and its key for
+	// mutual TLS.
+	CertFile string `yaml:"cert_file" env:"MEMOS_CERT_FILE"`
+	KeyFile  string `yaml:"key_file" env:"MEMOS_KEY_FILE"`
+	// InsecureSkipVerify disables certificate verification. Only use it in
+	// test setups.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify" env:"MEMOS_INSECURE_SKIP_VERIFY"`
+	// Proxy is the URL of an HTTP proxy. Without it, the HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY environment variables apply.
+	Proxy string `yaml:"proxy" env:"MEMOS_PROXY"`
+	// Timeout bounds each request, including reading the response (default
+	// one minute).
+	Timeout time.Duration `yaml:"timeout" env:"MEMOS_TIMEOUT"`
+	// DialTimeout bounds connecting and the TLS handshake (default ten
+	// seconds).
+	DialTimeout time.Duration `yaml:"dial_timeout" env:"MEMOS_DIAL_TIMEOUT"`
+	// Protocol is connect (the default), grpc for native gRPC over HTTP/2, or
+	// grpcweb.
+	Protocol string `yaml:"protocol" env:"MEMOS_PROTOCOL"`
+	// KeepaliveTime is how long an HTTP/2 connection may be idle before it is
+	// pinged, or 0 to never ping. The server must permit pings this often.
+	KeepaliveTime time.Duration `yaml:"keepalive_time" env:"MEMOS_KEEPALIVE_TIME"`
+	// KeepaliveTimeout is how long to wait for the answer to a ping before
+	// the connection is closed (default twenty seconds).
+	KeepaliveTimeout time.Duration `yaml:"keepalive_timeout" env:"MEMOS_KEEPALIVE_TIMEOUT"`
+}
+
+// LimitsConfig limits what users can save.
+type LimitsConfig struct {
+	// MaxContentLength is the maximum number of characters of a memo, or 0
+	// for no limit.
+	MaxContentLength int `yaml:"max_content_length"`
+	// MaxAttachmentSize is the maximum size of an attachment in bytes, or 0
+	// for no limit.
+	MaxAttachmentSize int64 `yaml:"max_attachment_size"`
+	// QueueLength is the number of updates each worker can queue.
+	QueueLength int `yaml:"queue_length"`
+}
+
+// TemplatesConfig holds text/template templates of the bot's replies.
+type TemplatesConfig struct {
+	// Welcome is sent after an access token was stored.
+	Welcome string `yaml:"welcome"`
+	// Saved replies to a message that was saved as a memo, in Telegram
+	// Markdown.
+	Saved string `yaml:"saved"`
+}
+
+// RouteConfig changes how messages of one chat are saved.
+type RouteConfig struct {
+	ChatID int64 `yaml:"chat_id"`
+	// Account saves the messages with this account instead of the active
+	// one, if the user has it.
+	Account string `yaml:"account"`
+	// Visibility of the new memos: PUBLIC, PROTECTED or PRIVATE.
+	Visibility string `yaml:"visibility"`
+	// Tags are appended to the new memos.
+	Tags []string `yaml:"tags"`
+}
+
+// RuleConfig changes how the messages it matches are saved. The rules run in
+// order before a memo is created, and all matching rules apply.
+type RuleConfig struct {
+	// Name identifies the rule in replies and logs.
+	Name    string      `yaml:"name"`
+	Match   RuleMatch   `yaml:"match"`
+	Actions RuleActions `yaml:"actions"`
+}
+
+// RuleMatch is the condition of a rule. A message matches when all set
+// fields match, so an empty condition matches every message.
+type RuleMatch struct {
+	// Content is a regular expression matched against the text or caption.
+	Content string `yaml:"content"`
+	// ForwardFrom is the username or name of the user, chat or channel the
+	// message was forwarded from, case insensitive.
+	ForwardFrom string `yaml:"forward_from"`
+	// ForwardChatID is the ID of the chat or channel the message was
+	// forwarded from.
+	ForwardChatID int64 `yaml:"forward_chat_id"`
+	// Media is text, photo, document, voice or video.
+	Media string `yaml:"media"`
+	// ChatID is the ID of the chat the message was sent in.
+	ChatID int64 `yaml:"chat_id"`
+	// Hashtag must be one of the message's hashtags, with or without its #.
+	Hashtag string `yaml:"hashtag"`
+}
+
+// RuleActions are applied to the messages a rule matches.
+type RuleActions struct {
+	// Tags are appended to the new memo.
+	Tags []string `yaml:"tags"`
+	// Visibility of the new memo: PUBLIC, PROTECTED or PRIVATE. It takes
+	// precedence over the visibility of routes.
+	Visibility string `yaml:"visibility"`
+	// Pin pins the new memo.
+	Pin bool `yaml:"pin"`
+	// Strip is a regular expression whose matches are removed from the
+	// content.
+	Strip string `yaml:"strip"`
+	// Account saves the memo with this account, if the user has it. An
+	// explicit !account prefix takes precedence.
+	Account string `yaml:"account"`
+	// Drop doesn't save the message at all.
+	Drop bool `yaml:"drop"`
+}
+
+// ScheduleConfig sends a memo list to a user every day.
+type ScheduleConfig struct {
+	// Name identifies the schedule in logs.
+	Name string `yaml:"name"`
+	// UserID is the Telegram user who receives the list in their private
+	// chat.
+	UserID int64 `yaml:"user_id"`
+	// Account lists the memos of this account instead of the active one.
+	Account string `yaml:"account"`
+	// List is recent, pinned or archived.
+	List string `yaml:"list"`
+	// At is the local time of day the list is sent at, like 09:00.
+	At string `yaml:"at"`
+}
+
+// stringList is a comma separated list, which may also be written as a YAML
+// sequence in the config file.
+type stringList string
+
+func (l *stringList) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		*l = stringList(node.Value)
+	case yaml.SequenceNode:
+		values := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return fmt.Errorf("line %d: expected a list of values", item.Line)
+			}
+			values = append(values, item.Value)
+		}
+		*l = stringList(strings.Join(values, ","))
+	default:
+		return fmt.Errorf("line %d: expected a value or a list of values", node.Line)
+	}
+	return nil
 }
 
-func getConfigFromEnv() (*Config, error) {
+// LoadConfig reads the config file, if any, and the environment, which takes
+// precedence, and validates the result.
+func LoadConfig() (*Config, error) {
+	config, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := config.complete(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// LoadStoreConfig reads the configuration like LoadConfig, but only completes
+// the data file, so that the store can be managed without the bot settings.
+func LoadStoreConfig() (*Config, error) {
+	config, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := config.completeData(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// readConfig reads the config file, if any, and the environment.
+func readConfig() (*Config, error) {
 	envFileName := ".env"
 	if _, err := os.Stat(envFileName); err == nil {
 		if err := godotenv.Load(envFileName); err != nil {
@@ -25,43 +232,239 @@ func getConfigFromEnv() (*Config, error) {
 		}
 	}
 
-	config := Config{}
-	if err := env.Parse(&config); err != nil {
+	config := &Config{
+		File:        os.Getenv("CONFIG_FILE"),
+		MemosClient: &MemosClientConfig{},
+	}
+	if config.File != "" {
+		if err := readConfigFile(config.File, config); err != nil {
+			return nil, err
+		}
+	}
+	if err := env.Parse(config); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
-	if config.Data == "" {
+	return config, nil
+}
+
+// complete applies the defaults to unset settings and validates the result.
+func (c *Config) complete() error {
+	if c.Concurrency == 0 {
+		c.Concurrency = defaultConcurrency
+	}
+	if c.Limits.QueueLength == 0 {
+		c.Limits.QueueLength = defaultWorkerQueueLength
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	return c.completeData()
+}
+
+// completeData defaults the data file to data.txt and makes its path
+// absolute.
+func (c *Config) completeData() error {
+	if c.Data == "" {
 		// Default to `data.txt` if not specified.
-		config.Data = "data.txt"
+		c.Data = "data.txt"
+	}
+	// The store creates the data file, but it must not be a directory.
+	if fileInfo, err := os.Stat(c.Data); err == nil && fileInfo.IsDir() {
+		return fmt.Errorf("data file cannot be a directory: %s", c.Data)
 	}
+	data, err := filepath.Abs(c.Data)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path for data file %s: %w", c.Data, err)
+	}
+	c.Data = data
+	return nil
+}
 
-	fileInfo, err := os.Stat(config.Data)
+// readConfigFile decodes the YAML file at path into config, rejecting
+// unknown settings.
+func readConfigFile(path string, config *Config) error {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// Create the file with default permissions
-			file, err := os.OpenFile(config.Data, os.O_CREATE|os.O_WRONLY, 0644)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create config file %s: %w", config.Data, err)
+		return fmt.Errorf("read config file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// validate reports all invalid settings at once, naming each by its key in
+// the config file and its environment variable.
+func (c *Config) validate() error {
+	var errs []error
+	invalid := func(key string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	if c.ServerAddr == "" {
+		invalid("server_addr (SERVER_ADDR)", "required")
+	} else if _, _, err := parseGRPCTarget(c.ServerAddr); err != nil {
+		invalid("server_addr (SERVER_ADDR)", "%s", err)
+	}
+	if c.BotToken == "" {
+		invalid("bot_token (BOT_TOKEN)", "required")
+	}
+	for _, ids := range []struct {
+		key   string
+		value stringList
+	}{
+		{"allowed_user_ids (ALLOWED_USER_IDS)", c.AllowedUserIDs},
+		{"allowed_chat_ids (ALLOWED_CHAT_IDS)", c.AllowedChatIDs},
+		{"admin_user_ids (ADMIN_USER_IDS)", c.AdminUserIDs},
+	} {
+		if _, err := parseIDs(string(ids.value)); err != nil {
+			invalid(ids.key, "%s", err)
+		}
+	}
+	if c.Concurrency < 0 {
+		invalid("concurrency (CONCURRENCY)", "must not be negative")
+	}
+	if c.MemosClient != nil {
+		if c.MemosClient.Timeout < 0 {
+			invalid("memos_client.timeout (MEMOS_TIMEOUT)", "must not be negative")
+		}
+		if c.MemosClient.DialTimeout < 0 {
+			invalid("memos_client.dial_timeout (MEMOS_DIAL_TIMEOUT)", "must not be negative")
+		}
+		if c.MemosClient.KeepaliveTime < 0 {
+			invalid("memos_client.keepalive_time (MEMOS_KEEPALIVE_TIME)", "must not be negative")
+		}
+		if c.MemosClient.KeepaliveTimeout < 0 {
+			invalid("memos_client.keepalive_timeout (MEMOS_KEEPALIVE_TIMEOUT)", "must not be negative")
+		}
+		switch c.MemosClient.Protocol {
+		case "", ProtocolConnect, ProtocolGRPC, ProtocolGRPCWeb:
+		default:
+			invalid("memos_client.protocol (MEMOS_PROTOCOL)", "%q is not one of connect, grpc or grpcweb", c.MemosClient.Protocol)
+		}
+		if (c.MemosClient.CertFile == "") != (c.MemosClient.KeyFile == "") {
+			invalid("memos_client.cert_file (MEMOS_CERT_FILE)", "cert_file and key_file must be set together")
+		} else if _, err := newMemosHTTPClient(c.MemosClient); err != nil {
+			invalid("memos_client", "%s", err)
+		}
+	}
+	if c.Limits.MaxContentLength < 0 {
+		invalid("limits.max_content_length", "must not be negative")
+	}
+	if c.Limits.MaxAttachmentSize < 0 {
+		invalid("limits.max_attachment_size", "must not be negative")
+	}
+	if c.Limits.QueueLength < 0 {
+		invalid("limits.queue_length", "must not be negative")
+	}
+	if _, err := newMessageTemplates(c.Templates); err != nil {
+		invalid("templates", "%s", err)
+	}
+
+	chatIDs := make(map[int64]int)
+	for i, route := range c.Routes {
+		key := fmt.Sprintf("routes[%d]", i)
+		if route.ChatID == 0 {
+			invalid(key+".chat_id", "required")
+		} else if previous, ok := chatIDs[route.ChatID]; ok {
+			invalid(key+".chat_id", "chat %d is already routed by routes[%d]", route.ChatID, previous)
+		} else {
+			chatIDs[route.ChatID] = i
+		}
+		if route.Account != "" && !isValidAccountName(route.Account) {
+			invalid(key+".account", "%q may only contain letters, digits, - and _ (at most 16 characters)", route.Account)
+		}
+		if route.Visibility != "" {
+			if _, err := parseVisibility(route.Visibility); err != nil {
+				invalid(key+".visibility", "%s", err)
+			}
+		}
+		for _, tag := range route.Tags {
+			if !isValidTag(tag) {
+				invalid(key+".tags", "%q is not a valid tag", tag)
 			}
-			file.Close()
+		}
+	}
 
-			// Get file info after creating the file
-			fileInfo, err = os.Stat(config.Data)
-			if err != nil {
-				return nil, fmt.Errorf("failed to get file info after creating %s: %w", config.Data, err)
+	for i, rule := range c.Rules {
+		key := fmt.Sprintf("rules[%d]", i)
+		if _, err := regexp.Compile(rule.Match.Content); err != nil {
+			invalid(key+".match.content", "%s", err)
+		}
+		if rule.Match.Media != "" && !isValidRuleMedia(rule.Match.Media) {
+			invalid(key+".match.media", "%q is not one of %s", rule.Match.Media, strings.Join(ruleMedia, ", "))
+		}
+		if rule.Match.Hashtag != "" && !isValidTag(rule.Match.Hashtag) {
+			invalid(key+".match.hashtag", "%q is not a valid tag", rule.Match.Hashtag)
+		}
+		for _, tag := range rule.Actions.Tags {
+			if !isValidTag(tag) {
+				invalid(key+".actions.tags", "%q is not a valid tag", tag)
+			}
+		}
+		if rule.Actions.Visibility != "" {
+			if _, err := parseVisibility(rule.Actions.Visibility); err != nil {
+				invalid(key+".actions.visibility", "%s", err)
 			}
-		} else {
-			return nil, fmt.Errorf("failed to access config file %s: %w", config.Data, err)
+		}
+		if _, err := regexp.Compile(rule.Actions.Strip); err != nil {
+			invalid(key+".actions.strip", "%s", err)
+		}
+		if rule.Actions.Account != "" && !isValidAccountName(rule.Actions.Account) {
+			invalid(key+".actions.account", "%q may only contain letters, digits, - and _ (at most 16 characters)", rule.Actions.Account)
 		}
 	}
 
-	if fileInfo.IsDir() {
-		return nil, fmt.Errorf("config file cannot be a directory: %s", config.Data)
+	scheduleNames := make(map[string]int)
+	for i, schedule := range c.Schedules {
+		key := fmt.Sprintf("schedules[%d]", i)
+		if schedule.Name == "" {
+			invalid(key+".name", "required")
+		} else if previous, ok := scheduleNames[schedule.Name]; ok {
+			invalid(key+".name", "%q is already the name of schedules[%d]", schedule.Name, previous)
+		} else {
+			scheduleNames[schedule.Name] = i
+		}
+		if schedule.UserID == 0 {
+			invalid(key+".user_id", "required")
+		}
+		if schedule.Account != "" && !isValidAccountName(schedule.Account) {
+			invalid(key+".account", "%q may only contain letters, digits, - and _ (at most 16 characters)", schedule.Account)
+		}
+		if _, ok := scheduleLists[schedule.List]; !ok {
+			invalid(key+".list", "%q is not one of recent, pinned or archived", schedule.List)
+		}
+		if _, err := time.Parse("15:04", schedule.At); err != nil {
+			invalid(key+".at", "%q is not a time like 09:00", schedule.At)
+		}
 	}
 
-	config.Data, err = filepath.Abs(config.Data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get absolute path for config file %s: %w", config.Data, err)
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
 	}
+	return nil
+}
 
-	return &config, nil
+// restartRequiredChanges returns the keys of settings that differ between the
+// configurations but only take effect after a restart.
+func restartRequiredChanges(current, next *Config) []string {
+	var keys []string
+	for key, changed := range map[string]bool{
+		"server_addr":         current.ServerAddr != next.ServerAddr,
+		"bot_token":           current.BotToken != next.BotToken,
+		"bot_proxy_addr":      current.BotProxyAddr != next.BotProxyAddr,
+		"data":                current.Data != next.Data,
+		"audit_log":           current.AuditLog != next.AuditLog,
+		"concurrency":         current.Concurrency != next.Concurrency,
+		"memos_client":        !reflect.DeepEqual(current.MemosClient, next.MemosClient),
+		"limits.queue_length": current.Limits.QueueLength != next.Limits.QueueLength,
+	} {
+		if changed {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
 }
@@ -0,0 +1,200 @@
+package memogram
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setConfigFile writes content to a config file and points CONFIG_FILE at it.
+func setConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "memogram.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("write config file: %v", err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("DATA", filepath.Join(dir, "data.txt"))
+	return path
+}
+
+func TestLoadConfigFromFileAndEnv(t *testing.T) {
+	setConfigFile(t, `
+server_addr: http://localhost:5230
+bot_token: file-token
+allowed_user_ids: [1, 2]
+admin_user_ids: "3"
+memos_client:
+  timeout: 30s
+limits:
+  max_content_length: 100
+templates:
+  welcome: "Hi {{.Name}}"
+routes:
+  - chat_id: -100
+    account: work
+    visibility: private
+    tags: [inbox]
+`)
+	t.Setenv("BOT_TOKEN", "env-token")
+	t.Setenv("MEMOS_PROXY", "http://proxy:3128")
+
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if config.BotToken != "env-token" {
+		t.Fatalf("expected the environment to override the file, got %q", config.BotToken)
+	}
+	if config.AllowedUserIDs != "1,2" || config.AdminUserIDs != "3" {
+		t.Fatalf("unexpected ID lists %q and %q", config.AllowedUserIDs, config.AdminUserIDs)
+	}
+	if config.Limits.MaxContentLength != 100 || config.Limits.QueueLength != defaultWorkerQueueLength {
+		t.Fatalf("unexpected limits %+v", config.Limits)
+	}
+	if config.MemosClient.Timeout != 30*time.Second || config.MemosClient.Proxy != "http://proxy:3128" {
+		t.Fatalf("unexpected Memos client config %+v", config.MemosClient)
+	}
+	if len(config.Routes) != 1 || config.Routes[0].Account != "work" {
+		t.Fatalf("unexpected routes %+v", config.Routes)
+	}
+	// Loading the configuration must not create the data file.
+	if _, err := os.Stat(config.Data); !os.IsNotExist(err) {
+		t.Fatalf("expected no data file, got %v", err)
+	}
+
+	settings, err := newLiveSettings(config)
+	if err != nil {
+		t.Fatalf("new live settings: %v", err)
+	}
+	if got := render(slog.Default(), settings.templates.welcome, welcomeData{Name: "Ann"}); got != "Hi Ann" {
+		t.Fatalf("unexpected welcome message %q", got)
+	}
+}
+
+func TestLoadStoreConfigWithoutBotSettings(t *testing.T) {
+	setConfigFile(t, "audit_log: audit.log\n")
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected the bot settings to be required")
+	}
+	config, err := LoadStoreConfig()
+	if err != nil {
+		t.Fatalf("load store config: %v", err)
+	}
+	if !filepath.IsAbs(config.Data) || filepath.Base(config.Data) != "data.txt" || config.AuditLog != "audit.log" {
+		t.Fatalf("unexpected store config %+v", config)
+	}
+}
+
+func TestLoadConfigRejectsUnknownSettings(t *testing.T) {
+	setConfigFile(t, "server_addr: http://localhost:5230\nbot_token: token\nlimit:\n  max_content_length: 1\n")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "field limit not found") {
+		t.Fatalf("expected an unknown field error, got %v", err)
+	}
+}
+
+func TestConfigValidateReportsAllErrors(t *testing.T) {
+	config := &Config{
+		ServerAddr:     "http://localhost:5230",
+		AllowedUserIDs: "1,abc",
+		Templates:      TemplatesConfig{Saved: "{{.Missing}}"},
+		Routes: []RouteConfig{
+			{ChatID: 1, Visibility: "secret"},
+			{ChatID: 1, Account: "not valid", Tags: []string{"two words"}},
+		},
+		Rules: []RuleConfig{
+			{Match: RuleMatch{Content: "(", Media: "sticker"}, Actions: RuleActions{Strip: "[", Visibility: "secret"}},
+		},
+		Schedules: []ScheduleConfig{
+			{Name: "daily", UserID: 1, List: "recent", At: "09:00"},
+			{Name: "daily", List: "starred", At: "9am"},
+		},
+	}
+	err := config.validate()
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{
+		"bot_token (BOT_TOKEN): required",
+		`allowed_user_ids (ALLOWED_USER_IDS): invalid ID "abc"`,
+		"templates:",
+		"routes[0].visibility",
+		"routes[1].chat_id: chat 1 is already routed by routes[0]",
+		"routes[1].account",
+		"routes[1].tags",
+		"rules[0].match.content",
+		"rules[0].match.media",
+		"rules[0].actions.strip",
+		"rules[0].actions.visibility",
+		`schedules[1].name: "daily" is already the name of schedules[0]`,
+		"schedules[1].user_id: required",
+		"schedules[1].list",
+		"schedules[1].at",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %q in %v", want, err)
+		}
+	}
+}
+
+func TestReloadConfigUpdatesLiveSettings(t *testing.T) {
+	path := setConfigFile(t, "server_addr: http://localhost:5230\nbot_token: token\nallowed_user_ids: [2]\n")
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	s := newTestAccessService(t)
+	s.config = config
+	s.loadConfig = LoadConfig
+
+	if err := os.WriteFile(path, []byte("server_addr: http://localhost:5230\nbot_token: token\nallowed_user_ids: [2, 42]\n"), 0600); err != nil {
+		t.Fatalf("write config file: %v", err)
+	}
+	if err := s.reloadConfig(); err != nil {
+		t.Fatalf("reload config: %v", err)
+	}
+	if !s.isAllowed(42, "", 42) {
+		t.Fatal("expected user 42 to be allowed after the reload")
+	}
+
+	// An invalid configuration keeps the current settings.
+	if err := os.WriteFile(path, []byte("server_addr: http://localhost:5230\nbot_token: token\nallowed_user_ids: [x]\n"), 0600); err != nil {
+		t.Fatalf("write config file: %v", err)
+	}
+	if err := s.reloadConfig(); err == nil {
+		t.Fatal("expected the reload to fail")
+	}
+	if !s.isAllowed(42, "", 42) {
+		t.Fatal("expected the previous settings to stay in effect")
+	}
+}
+
+func TestReloadConfigWarnsOnceAboutRestart(t *testing.T) {
+	path := setConfigFile(t, "server_addr: http://localhost:5230\nbot_token: token\n")
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	var logs bytes.Buffer
+	s := newTestAccessService(t)
+	s.logger = slog.New(slog.NewTextHandler(&logs, nil))
+	s.config = config
+	s.loadConfig = LoadConfig
+
+	if err := os.WriteFile(path, []byte("server_addr: http://localhost:5231\nbot_token: token\n"), 0600); err != nil {
+		t.Fatalf("write config file: %v", err)
+	}
+	for range 2 {
+		if err := s.reloadConfig(); err != nil {
+			t.Fatalf("reload config: %v", err)
+		}
+	}
+	if warnings := strings.Count(logs.String(), "setting=server_addr"); warnings != 1 {
+		t.Fatalf("expected one restart warning, got %d:\n%s", warnings, logs.String())
+	}
+}
@@ -0,0 +1,124 @@
+package memogram
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	defaultConcurrency       = 4
+	defaultWorkerQueueLength = 64
+)
+
+// dispatchQueueLength exports the number of updates waiting for a worker.
+var dispatchQueueLength = expvar.NewInt("memogram_dispatch_queue_length")
+
+type dispatchJob struct {
+	ctx     context.Context
+	bot     *bot.Bot
+	update  *models.Update
+	handler bot.HandlerFunc
+}
+
+// dispatcher hashes updates by user into a fixed set of workers, so that
+// updates from one user are handled in order while different users are
+// handled in parallel.
+type dispatcher struct {
+	queues []chan dispatchJob
+	queued atomic.Int64
+
+	startOnce sync.Once
+}
+
+func newDispatcher(concurrency, queueLength int) *dispatcher {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if queueLength < 1 {
+		queueLength = 1
+	}
+	queues := make([]chan dispatchJob, concurrency)
+	for i := range queues {
+		queues[i] = make(chan dispatchJob, queueLength)
+	}
+	return &dispatcher{queues: queues}
+}
+
+// Start runs the workers until ctx is done.
+func (d *dispatcher) Start(ctx context.Context) {
+	d.startOnce.Do(func() {
+		for _, queue := range d.queues {
+			go d.work(ctx, queue)
+		}
+	})
+}
+
+// Middleware hands updates over to the workers instead of running the
+// handler on the caller's goroutine. It blocks while the target worker's
+// queue is full.
+func (d *dispatcher) Middleware(next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		queue := d.queues[dispatchKey(update)%uint64(len(d.queues))]
+		d.setQueued(1)
+		select {
+		case queue <- dispatchJob{ctx: ctx, bot: b, update: update, handler: next}:
+		case <-ctx.Done():
+			d.setQueued(-1)
+		}
+	}
+}
+
+// QueueLength returns the number of updates waiting for a worker.
+func (d *dispatcher) QueueLength() int64 {
+	return d.queued.Load()
+}
+
+func (d *dispatcher) work(ctx context.Context, queue chan dispatchJob) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-queue:
+			d.setQueued(-1)
+			job.handler(job.ctx, job.bot, job.update)
+		}
+	}
+}
+
+func (d *dispatcher) setQueued(delta int64) {
+	d.queued.Add(delta)
+	dispatchQueueLength.Add(delta)
+}
+
+// dispatchKey returns the ID that orders an update, preferring the sender
+// over the chat so that callbacks and messages from one user stay in order.
+func dispatchKey(update *models.Update) uint64 {
+	switch {
+	case update == nil:
+		return 0
+	case update.Message != nil:
+		if update.Message.From != nil {
+			return uint64(update.Message.From.ID)
+		}
+		return uint64(update.Message.Chat.ID)
+	case update.EditedMessage != nil:
+		if update.EditedMessage.From != nil {
+			return uint64(update.EditedMessage.From.ID)
+		}
+		return uint64(update.EditedMessage.Chat.ID)
+	case update.CallbackQuery != nil:
+		return uint64(update.CallbackQuery.From.ID)
+	case update.MessageReaction != nil:
+		if update.MessageReaction.User != nil {
+			return uint64(update.MessageReaction.User.ID)
+		}
+		return uint64(update.MessageReaction.Chat.ID)
+	default:
+		return uint64(update.ID)
+	}
+}
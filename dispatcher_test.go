@@ -0,0 +1,94 @@
+package memogram
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+func messageUpdate(userID int64, text string) *models.Update {
+	return &models.Update{
+		Message: &models.Message{
+			From: &models.User{ID: userID},
+			Chat: models.Chat{ID: userID},
+			Text: text,
+		},
+	}
+}
+
+func TestDispatcherKeepsPerUserOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := newDispatcher(4, 16)
+	d.Start(ctx)
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	got := make(map[int64][]string)
+	handler := d.Middleware(func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		defer wg.Done()
+		// Make earlier updates slower so reordering would show up.
+		if update.Message.Text == "1" {
+			time.Sleep(10 * time.Millisecond)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		userID := update.Message.From.ID
+		got[userID] = append(got[userID], update.Message.Text)
+	})
+
+	for _, userID := range []int64{1, 2, 3} {
+		for _, text := range []string{"1", "2", "3"} {
+			wg.Add(1)
+			handler(ctx, nil, messageUpdate(userID, text))
+		}
+	}
+	wg.Wait()
+
+	for _, userID := range []int64{1, 2, 3} {
+		if order := got[userID]; len(order) != 3 || order[0] != "1" || order[1] != "2" || order[2] != "3" {
+			t.Fatalf("user %d: unexpected order %v", userID, order)
+		}
+	}
+	if length := d.QueueLength(); length != 0 {
+		t.Fatalf("expected empty queue, got %d", length)
+	}
+}
+
+func TestDispatcherRunsUsersInParallel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := newDispatcher(2, 1)
+	d.Start(ctx)
+
+	release := make(chan struct{})
+	done := make(chan int64, 2)
+	handler := d.Middleware(func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		if update.Message.From.ID == 0 {
+			<-release
+		}
+		done <- update.Message.From.ID
+	})
+
+	// Users 0 and 1 hash to different workers, so user 1 must not wait for
+	// the blocked update of user 0.
+	handler(ctx, nil, messageUpdate(0, "blocked"))
+	handler(ctx, nil, messageUpdate(1, "free"))
+
+	select {
+	case userID := <-done:
+		if userID != 1 {
+			t.Fatalf("expected user 1 to finish first, got %d", userID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("user 1 was blocked by user 0")
+	}
+	close(release)
+	<-done
+}
@@ -0,0 +1,403 @@
+package memogram
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/usememos/memogram/store"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+)
+
+// e2e runs a Service against a fake Memos server and a fake Bot API. User 1
+// is signed in as users/1 and user 2 as users/2.
+type e2e struct {
+	service *Service
+	bot     *bot.Bot
+	api     *fakeBotAPI
+	memos   *fakeMemos
+}
+
+func newE2E(t *testing.T) *e2e {
+	t.Helper()
+	memos, memosURL := newFakeMemos(t)
+	memos.addUser("token-1", 1, "ann")
+	memos.addUser("token-2", 2, "bob")
+	api, b := newFakeBotAPI(t)
+
+	s := newTestAccessService(t)
+	s.config = &Config{ServerAddr: memosURL}
+	s.client = NewMemosClient(memosURL)
+	s.sender = newTestSender(b)
+	s.sender.perChatInterval = time.Millisecond
+	s.httpClient = http.DefaultClient
+	live, err := newLiveSettings(&Config{})
+	if err != nil {
+		t.Fatalf("new live settings: %v", err)
+	}
+	s.live.Store(live)
+	s.store.SetUserCredential(1, store.Credential{AccessToken: "token-1"})
+	s.store.SetUserCredential(2, store.Credential{AccessToken: "token-2"})
+	return &e2e{service: s, bot: b, api: api, memos: memos}
+}
+
+// send handles a message update of userID.
+func (e *e2e) send(message *models.Message) {
+	e.service.handler(context.Background(), e.bot, &models.Update{Message: message})
+}
+
+// press handles a press of the inline button with data on message 10,
+// choosing the handler by the prefix of data like the bot does.
+func (e *e2e) press(userID int64, data string) {
+	handler := e.service.callbackQueryHandler
+	switch {
+	case strings.HasPrefix(data, callbackListPrefix):
+		handler = e.service.listCallbackHandler
+	case strings.HasPrefix(data, callbackTaskPrefix):
+		handler = e.service.taskCallbackHandler
+	case strings.HasPrefix(data, callbackRelatedPrefix):
+		handler = e.service.relatedCallbackHandler
+	}
+	handler(context.Background(), e.bot, &models.Update{
+		CallbackQuery: &models.CallbackQuery{
+			ID:   "query",
+			From: models.User{ID: userID},
+			Message: models.MaybeInaccessibleMessage{
+				Message: &models.Message{ID: 10, Chat: models.Chat{ID: userID}},
+			},
+			Data: data,
+		},
+	})
+}
+
+// lastText returns the text of the last call of method.
+func (e *e2e) lastText(t *testing.T, method string) string {
+	t.Helper()
+	sent := e.api.sent(method)
+	if len(sent) == 0 {
+		t.Fatalf("expected a %s call", method)
+	}
+	return sent[len(sent)-1].Get("text")
+}
+
+func textMessage(userID int64, text string) *models.Message {
+	return &models.Message{
+		ID:   5,
+		From: &models.User{ID: userID},
+		Chat: models.Chat{ID: userID},
+		Text: text,
+	}
+}
+
+func TestE2ECreateMemo(t *testing.T) {
+	e := newE2E(t)
+	message := textMessage(1, "Hello world")
+	message.Entities = []models.MessageEntity{{Type: models.MessageEntityTypeBold, Offset: 6, Length: 5}}
+	e.send(message)
+
+	memos := e.memos.allMemos()
+	if len(memos) != 1 {
+		t.Fatalf("expected one memo, got %d", len(memos))
+	}
+	if memos[0].Content != "Hello **world**" || memos[0].Creator != "users/1" {
+		t.Fatalf("unexpected memo %+v", memos[0])
+	}
+	reply := e.api.sent("sendMessage")[0]
+	if !strings.HasPrefix(reply.Get("text"), "Content saved as PRIVATE with [memos/1]") {
+		t.Fatalf("unexpected reply %q", reply.Get("text"))
+	}
+	if !strings.Contains(reply.Get("reply_markup"), "public memos/1 default") {
+		t.Fatalf("expected the memo buttons, got %q", reply.Get("reply_markup"))
+	}
+}
+
+func TestE2EUnknownUserIsAskedToStart(t *testing.T) {
+	e := newE2E(t)
+	e.send(textMessage(3, "Hello"))
+
+	if len(e.memos.allMemos()) != 0 {
+		t.Fatalf("expected no memo")
+	}
+	if text := e.lastText(t, "sendMessage"); !strings.Contains(text, "/start") {
+		t.Fatalf("unexpected reply %q", text)
+	}
+}
+
+func TestE2EAlbum(t *testing.T) {
+	e := newE2E(t)
+	for i, caption := range []string{"Holiday", ""} {
+		e.send(&models.Message{
+			ID:           5 + i,
+			From:         &models.User{ID: 1},
+			Chat:         models.Chat{ID: 1},
+			MediaGroupID: "album",
+			Caption:      caption,
+			Photo:        []models.PhotoSize{{FileID: "small"}, {FileID: "large"}},
+		})
+	}
+
+	memos := e.memos.allMemos()
+	if len(memos) != 1 {
+		t.Fatalf("expected one memo for the album, got %d", len(memos))
+	}
+	if memos[0].Content != "Holiday" {
+		t.Fatalf("unexpected content %q", memos[0].Content)
+	}
+	if len(memos[0].Attachments) != 2 {
+		t.Fatalf("expected two attachments, got %d", len(memos[0].Attachments))
+	}
+	attachment := memos[0].Attachments[0]
+	if string(attachment.Content) != fakeFileContent || attachment.Filename != "file.jpg" {
+		t.Fatalf("unexpected attachment %+v", attachment)
+	}
+	for _, params := range e.api.sent("getFile") {
+		if params.Get("file_id") != "large" {
+			t.Fatalf("expected the largest photo to be saved, got %q", params.Get("file_id"))
+		}
+	}
+}
+
+func TestE2EAlbumAppliesRulesOnce(t *testing.T) {
+	e := newE2E(t)
+	live, err := newLiveSettings(&Config{Rules: []RuleConfig{{
+		Name:    "no empty captions",
+		Match:   RuleMatch{Content: "^$"},
+		Actions: RuleActions{Drop: true},
+	}}})
+	if err != nil {
+		t.Fatalf("new live settings: %v", err)
+	}
+	e.service.live.Store(live)
+	for i, caption := range []string{"Holiday", ""} {
+		e.send(&models.Message{
+			ID:           5 + i,
+			From:         &models.User{ID: 1},
+			Chat:         models.Chat{ID: 1},
+			MediaGroupID: "album",
+			Caption:      caption,
+			Photo:        []models.PhotoSize{{FileID: "small"}, {FileID: "large"}},
+		})
+	}
+
+	memos := e.memos.allMemos()
+	if len(memos) != 1 || len(memos[0].Attachments) != 2 {
+		t.Fatalf("expected one memo with both photos, got %+v", memos)
+	}
+	for _, params := range e.api.sent("sendMessage") {
+		if text := params.Get("text"); strings.Contains(text, "dropped by rule") {
+			t.Fatalf("unexpected reply %q", text)
+		}
+	}
+}
+
+func TestE2EForward(t *testing.T) {
+	e := newE2E(t)
+	message := textMessage(1, "Interesting")
+	message.ForwardOrigin = &models.MessageOrigin{
+		MessageOriginUser: &models.MessageOriginUser{
+			SenderUser: models.User{FirstName: "Carol", LastName: "King", Username: "carol"},
+		},
+	}
+	e.send(message)
+
+	message = textMessage(1, "News")
+	message.ForwardOrigin = &models.MessageOrigin{
+		MessageOriginHiddenUser: &models.MessageOriginHiddenUser{SenderUserName: "Dave"},
+	}
+	e.send(message)
+
+	memos := e.memos.allMemos()
+	if len(memos) != 2 {
+		t.Fatalf("expected two memos, got %d", len(memos))
+	}
+	if want := "Forwarded from [Carol King](https://t.me/carol)\nInteresting"; memos[0].Content != want {
+		t.Fatalf("expected %q, got %q", want, memos[0].Content)
+	}
+	if want := "Forwarded from Dave\nNews"; memos[1].Content != want {
+		t.Fatalf("expected %q, got %q", want, memos[1].Content)
+	}
+}
+
+func TestE2ECallbacks(t *testing.T) {
+	e := newE2E(t)
+	e.send(textMessage(1, "Hello"))
+
+	e.press(1, "public memos/1 default")
+	memo, _ := e.memos.memo("memos/1")
+	if memo.Visibility != v1pb.Visibility_PUBLIC {
+		t.Fatalf("expected a public memo, got %v", memo.Visibility)
+	}
+	if text := e.lastText(t, "editMessageText"); !strings.HasPrefix(text, "Memo updated as PUBLIC") {
+		t.Fatalf("unexpected message %q", text)
+	}
+
+	e.press(1, "pin memos/1")
+	memo, _ = e.memos.memo("memos/1")
+	if !memo.Pinned || memo.Visibility != v1pb.Visibility_PUBLIC {
+		t.Fatalf("expected a pinned public memo, got %+v", memo)
+	}
+
+	// Other users can't change the memo.
+	e.press(2, "private memos/1")
+	memo, _ = e.memos.memo("memos/1")
+	if memo.Visibility != v1pb.Visibility_PUBLIC {
+		t.Fatalf("expected the memo to stay public, got %v", memo.Visibility)
+	}
+	if text := e.lastText(t, "answerCallbackQuery"); !strings.Contains(text, "not found") {
+		t.Fatalf("unexpected answer %q", text)
+	}
+}
+
+func TestE2ESearch(t *testing.T) {
+	e := newE2E(t)
+	e.send(textMessage(1, "Buy milk"))
+	e.send(textMessage(1, "Call mom"))
+	e.send(textMessage(2, "Buy bread"))
+	calls := len(e.api.sent("sendMessage"))
+
+	e.send(textMessage(1, "/search Buy"))
+	results := e.api.sent("sendMessage")[calls:]
+	if len(results) != 1 || results[0].Get("text") != "memos/1\nBuy milk" {
+		t.Fatalf("expected only the matching memo of the user, got %v", results)
+	}
+
+	e.send(textMessage(1, "/search nothing"))
+	if text := e.lastText(t, "sendMessage"); !strings.HasPrefix(text, "No memos found") {
+		t.Fatalf("unexpected reply %q", text)
+	}
+}
+
+func TestE2ETagSuggestions(t *testing.T) {
+	e := newE2E(t)
+	e.send(textMessage(1, "First #work #reading"))
+	e.send(textMessage(1, "Second #work"))
+	e.send(textMessage(1, "Third"))
+
+	markup := e.api.sent("sendMessage")[2].Get("reply_markup")
+	if !strings.Contains(markup, `"text":"#work","callback_data":"#work memos/3 default"`) ||
+		!strings.Contains(markup, "#reading memos/3 default") {
+		t.Fatalf("expected tag suggestions, got %s", markup)
+	}
+
+	e.press(1, "#work memos/3 default")
+	memo, _ := e.memos.memo("memos/3")
+	if memo.Content != "Third\n\n#work" {
+		t.Fatalf("expected the tag to be appended, got %q", memo.Content)
+	}
+	// The appended tag is no longer suggested.
+	if markup := e.api.sent("editMessageText")[0].Get("reply_markup"); strings.Contains(markup, "#work memos/3") {
+		t.Fatalf("expected #work to no longer be suggested, got %s", markup)
+	}
+
+	// Tapping twice doesn't append the tag twice.
+	e.press(1, "#work memos/3 default")
+	if memo, _ := e.memos.memo("memos/3"); memo.Content != "Third\n\n#work" {
+		t.Fatalf("expected the tag to be appended once, got %q", memo.Content)
+	}
+
+	// The tag counts are asked for once and then cached.
+	e.memos.mutex.Lock()
+	defer e.memos.mutex.Unlock()
+	if e.memos.statsRequests != 1 {
+		t.Fatalf("expected the user stats to be requested once, got %d requests", e.memos.statsRequests)
+	}
+}
+
+func TestE2ERules(t *testing.T) {
+	e := newE2E(t)
+	live, err := newLiveSettings(&Config{Rules: []RuleConfig{
+		{
+			Name:    "release notes",
+			Match:   RuleMatch{ForwardFrom: "Release notes"},
+			Actions: RuleActions{Tags: []string{"releases"}, Visibility: "PROTECTED", Pin: true},
+		},
+		{
+			Name:    "no ads",
+			Match:   RuleMatch{Content: "^Ad:"},
+			Actions: RuleActions{Drop: true},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("new live settings: %v", err)
+	}
+	e.service.live.Store(live)
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	if e.service.audit, err = newAuditLog(auditPath, e.service.logger); err != nil {
+		t.Fatalf("open audit log: %v", err)
+	}
+
+	message := textMessage(1, "Version 1.2")
+	message.ForwardOrigin = &models.MessageOrigin{
+		MessageOriginChannel: &models.MessageOriginChannel{Chat: models.Chat{ID: -100, Title: "Release notes"}},
+	}
+	e.send(message)
+	e.send(textMessage(1, "Ad: buy now"))
+
+	memos := e.memos.allMemos()
+	if len(memos) != 1 {
+		t.Fatalf("expected one memo, got %d", len(memos))
+	}
+	if want := "Forwarded from Release notes\nVersion 1.2\n\n#releases"; memos[0].Content != want {
+		t.Fatalf("expected %q, got %q", want, memos[0].Content)
+	}
+	if memos[0].Visibility != v1pb.Visibility_PROTECTED || !memos[0].Pinned {
+		t.Fatalf("expected a pinned protected memo, got %+v", memos[0])
+	}
+	if text := e.lastText(t, "sendMessage"); !strings.Contains(text, `dropped by rule "no ads"`) {
+		t.Fatalf("unexpected reply %q", text)
+	}
+	audit, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	if !strings.Contains(string(audit), `"action":"memo.update","user_id":1,"chat_id":1,"account":"default","memo":"memos/1","result":"success","details":"pin (rule release notes)"`) {
+		t.Fatalf("expected the pin to be audited, got:\n%s", audit)
+	}
+}
+
+func TestE2ESearchQuery(t *testing.T) {
+	e := newE2E(t)
+	e.send(textMessage(1, "Report #work"))
+	e.send(textMessage(1, "Groceries #home"))
+	e.send(textMessage(1, "Done report #work #done"))
+	e.send(&models.Message{
+		ID:      6,
+		From:    &models.User{ID: 1},
+		Chat:    models.Chat{ID: 1},
+		Caption: "Receipt",
+		Photo:   []models.PhotoSize{{FileID: "photo"}},
+	})
+
+	search := func(query string) []string {
+		calls := len(e.api.sent("sendMessage"))
+		e.send(textMessage(1, "/search "+query))
+		var names []string
+		for _, params := range e.api.sent("sendMessage")[calls:] {
+			name, _, _ := strings.Cut(params.Get("text"), "\n")
+			names = append(names, name)
+		}
+		return names
+	}
+
+	if got := strings.Join(search("tag:work -tag:done"), ","); got != "memos/1" {
+		t.Fatalf("unexpected results %s", got)
+	}
+	if got := strings.Join(search(`tag:home OR "Done report"`), ","); got != "memos/3,memos/2" {
+		t.Fatalf("unexpected results %s", got)
+	}
+	if got := strings.Join(search("has:attachment"), ","); got != "memos/4" {
+		t.Fatalf("unexpected results %s", got)
+	}
+
+	e.send(textMessage(1, "/search pinned:maybe"))
+	if text := e.lastText(t, "sendMessage"); !strings.HasPrefix(text, `Invalid search: pinned: "maybe" is not true or false`) ||
+		!strings.Contains(text, "Usage: /search <query>") {
+		t.Fatalf("unexpected reply %q", text)
+	}
+}
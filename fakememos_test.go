@@ -0,0 +1,725 @@
+package memogram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"connectrpc.com/connect"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+	"github.com/usememos/memos/proto/gen/api/v1/apiv1connect"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeMemos is an in-memory Memos server. Requests are authenticated with the
+// access tokens of users, and memos are only visible to their creator.
+type fakeMemos struct {
+	apiv1connect.UnimplementedInstanceServiceHandler
+	apiv1connect.UnimplementedAuthServiceHandler
+	apiv1connect.UnimplementedUserServiceHandler
+	apiv1connect.UnimplementedMemoServiceHandler
+	apiv1connect.UnimplementedAttachmentServiceHandler
+	apiv1connect.UnimplementedShortcutServiceHandler
+
+	mutex       sync.Mutex
+	users       map[string]*v1pb.User // by access token
+	passwords   map[string]string     // by username
+	memos       []*v1pb.Memo          // in creation order
+	attachments []*v1pb.Attachment
+	shortcuts   []*v1pb.Shortcut
+	reactions   []*v1pb.Reaction
+	relations   []*v1pb.MemoRelation
+	nextID      int
+	tokens      int
+	// statsRequests counts the GetUserStats requests.
+	statsRequests int
+}
+
+// newFakeMemos starts a fake Memos server and returns it with its URL.
+func newFakeMemos(t *testing.T) (*fakeMemos, string) {
+	t.Helper()
+	f := &fakeMemos{users: make(map[string]*v1pb.User), passwords: make(map[string]string)}
+	mux := http.NewServeMux()
+	mux.Handle(apiv1connect.NewInstanceServiceHandler(f))
+	mux.Handle(apiv1connect.NewAuthServiceHandler(f))
+	mux.Handle(apiv1connect.NewUserServiceHandler(f))
+	mux.Handle(apiv1connect.NewMemoServiceHandler(f))
+	mux.Handle(apiv1connect.NewAttachmentServiceHandler(f))
+	mux.Handle(apiv1connect.NewShortcutServiceHandler(f))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return f, server.URL
+}
+
+// addUser lets accessToken authenticate as users/<id>.
+func (f *fakeMemos) addUser(accessToken string, id int, username string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.users[accessToken] = &v1pb.User{
+		Name:        fmt.Sprintf("users/%d", id),
+		Username:    username,
+		DisplayName: username,
+	}
+}
+
+// setPassword lets the user with username sign in with password.
+func (f *fakeMemos) setPassword(username, password string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.passwords[username] = password
+}
+
+// memo returns a copy of the named memo.
+func (f *fakeMemos) memo(name string) (*v1pb.Memo, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, memo := range f.memos {
+		if memo.Name == name {
+			return f.copyMemo(memo), true
+		}
+	}
+	return nil, false
+}
+
+// allMemos returns copies of all memos in creation order.
+func (f *fakeMemos) allMemos() []*v1pb.Memo {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	memos := make([]*v1pb.Memo, 0, len(f.memos))
+	for _, memo := range f.memos {
+		memos = append(memos, f.copyMemo(memo))
+	}
+	return memos
+}
+
+// addShortcut adds a shortcut named <user>/shortcuts/<id>.
+func (f *fakeMemos) addShortcut(user, id, title, filter string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.shortcuts = append(f.shortcuts, &v1pb.Shortcut{Name: user + "/shortcuts/" + id, Title: title, Filter: filter})
+}
+
+// archive archives the named memo.
+func (f *fakeMemos) archive(name string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, memo := range f.memos {
+		if memo.Name == name {
+			memo.State = v1pb.State_ARCHIVED
+		}
+	}
+}
+
+// copyMemo copies memo together with its attachments and reactions. The mutex
+// must be held.
+func (f *fakeMemos) copyMemo(memo *v1pb.Memo) *v1pb.Memo {
+	copied := &v1pb.Memo{
+		Name:       memo.Name,
+		State:      memo.State,
+		Creator:    memo.Creator,
+		Content:    memo.Content,
+		Visibility: memo.Visibility,
+		Pinned:     memo.Pinned,
+		CreateTime: memo.CreateTime,
+		Tags:       append([]string(nil), memo.Tags...),
+	}
+	for _, attachment := range f.attachments {
+		if attachment.GetMemo() == memo.Name {
+			copied.Attachments = append(copied.Attachments, &v1pb.Attachment{
+				Name:     attachment.Name,
+				Filename: attachment.Filename,
+				Type:     attachment.Type,
+				Size:     attachment.Size,
+				Content:  attachment.Content,
+				Memo:     attachment.Memo,
+			})
+		}
+	}
+	for _, reaction := range f.reactions {
+		if reaction.ContentId == memo.Name {
+			copied.Reactions = append(copied.Reactions, f.copyReaction(reaction))
+		}
+	}
+	return copied
+}
+
+func (f *fakeMemos) copyReaction(reaction *v1pb.Reaction) *v1pb.Reaction {
+	return &v1pb.Reaction{
+		Name:         reaction.Name,
+		Creator:      reaction.Creator,
+		ContentId:    reaction.ContentId,
+		ReactionType: reaction.ReactionType,
+	}
+}
+
+// authenticate returns the user of the request's access token. The mutex
+// must be held.
+func (f *fakeMemos) authenticate(header http.Header) (*v1pb.User, error) {
+	user, ok := f.users[strings.TrimPrefix(header.Get("Authorization"), "Bearer ")]
+	if !ok {
+		return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("invalid access token"))
+	}
+	return user, nil
+}
+
+// findMemo returns the named memo of user. The mutex must be held.
+func (f *fakeMemos) findMemo(user *v1pb.User, name string) (*v1pb.Memo, error) {
+	for _, memo := range f.memos {
+		if memo.Name == name && memo.Creator == user.Name {
+			return memo, nil
+		}
+	}
+	return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("memo %s not found", name))
+}
+
+func (f *fakeMemos) GetInstanceProfile(context.Context, *connect.Request[v1pb.GetInstanceProfileRequest]) (*connect.Response[v1pb.InstanceProfile], error) {
+	return connect.NewResponse(&v1pb.InstanceProfile{Version: "0.27.1"}), nil
+}
+
+func (f *fakeMemos) GetCurrentUser(_ context.Context, req *connect.Request[v1pb.GetCurrentUserRequest]) (*connect.Response[v1pb.GetCurrentUserResponse], error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	user, err := f.authenticate(req.Header())
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&v1pb.GetCurrentUserResponse{User: user}), nil
+}
+
+func (f *fakeMemos) SignIn(_ context.Context, req *connect.Request[v1pb.SignInRequest]) (*connect.Response[v1pb.SignInResponse], error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	credentials, ok := req.Msg.GetCredentials().(*v1pb.SignInRequest_PasswordCredentials_)
+	if !ok {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("password credentials required"))
+	}
+	username, password := credentials.GetPasswordCredentials().GetUsername(), credentials.GetPasswordCredentials().GetPassword()
+	if expected, ok := f.passwords[username]; !ok || expected != password {
+		return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("invalid username or password"))
+	}
+	for _, user := range f.users {
+		if user.Username == username {
+			f.tokens++
+			session := fmt.Sprintf("session-%d", f.tokens)
+			f.users[session] = user
+			return connect.NewResponse(&v1pb.SignInResponse{User: user, AccessToken: session}), nil
+		}
+	}
+	return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("user %s not found", username))
+}
+
+func (f *fakeMemos) CreatePersonalAccessToken(_ context.Context, req *connect.Request[v1pb.CreatePersonalAccessTokenRequest]) (*connect.Response[v1pb.CreatePersonalAccessTokenResponse], error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	user, err := f.authenticate(req.Header())
+	if err != nil {
+		return nil, err
+	}
+	if req.Msg.GetParent() != user.Name {
+		return nil, connect.NewError(connect.CodePermissionDenied, errors.New("permission denied"))
+	}
+	f.tokens++
+	token := fmt.Sprintf("pat-%d", f.tokens)
+	f.users[token] = user
+	return connect.NewResponse(&v1pb.CreatePersonalAccessTokenResponse{
+		PersonalAccessToken: &v1pb.PersonalAccessToken{
+			Name:        fmt.Sprintf("%s/personalAccessTokens/%d", user.Name, f.tokens),
+			Description: req.Msg.GetDescription(),
+		},
+		Token: token,
+	}), nil
+}
+
+func (f *fakeMemos) CreateMemo(_ context.Context, req *connect.Request[v1pb.CreateMemoRequest]) (*connect.Response[v1pb.Memo], error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	user, err := f.authenticate(req.Header())
+	if err != nil {
+		return nil, err
+	}
+	f.nextID++
+	memo := &v1pb.Memo{
+		Name:       fmt.Sprintf("memos/%d", f.nextID),
+		Creator:    user.Name,
+		Content:    req.Msg.GetMemo().GetContent(),
+		State:      v1pb.State_NORMAL,
+		Visibility: req.Msg.GetMemo().GetVisibility(),
+		CreateTime: timestamppb.Now(),
+	}
+	if memo.Visibility == v1pb.Visibility_VISIBILITY_UNSPECIFIED {
+		memo.Visibility = v1pb.Visibility_PRIVATE
+	}
+	memo.Tags = fakeExtractTags(memo.Content)
+	f.memos = append(f.memos, memo)
+	return connect.NewResponse(f.copyMemo(memo)), nil
+}
+
+func (f *fakeMemos) GetMemo(_ context.Context, req *connect.Request[v1pb.GetMemoRequest]) (*connect.Response[v1pb.Memo], error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	user, err := f.authenticate(req.Header())
+	if err != nil {
+		return nil, err
+	}
+	memo, err := f.findMemo(user, req.Msg.GetName())
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(f.copyMemo(memo)), nil
+}
+
+func (f *fakeMemos) UpdateMemo(_ context.Context, req *connect.Request[v1pb.UpdateMemoRequest]) (*connect.Response[v1pb.Memo], error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	user, err := f.authenticate(req.Header())
+	if err != nil {
+		return nil, err
+	}
+	memo, err := f.findMemo(user, req.Msg.GetMemo().GetName())
+	if err != nil {
+		return nil, err
+	}
+	update := req.Msg.GetMemo()
+	for _, path := range req.Msg.GetUpdateMask().GetPaths() {
+		switch path {
+		case "content":
+			memo.Content = update.GetContent()
+			memo.Tags = fakeExtractTags(memo.Content)
+		case "visibility":
+			memo.Visibility = update.GetVisibility()
+		case "pinned":
+			memo.Pinned = update.GetPinned()
+		case "state":
+			memo.State = update.GetState()
+		default:
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("unsupported update path %q", path))
+		}
+	}
+	return connect.NewResponse(f.copyMemo(memo)), nil
+}
+
+func (f *fakeMemos) GetUserStats(_ context.Context, req *connect.Request[v1pb.GetUserStatsRequest]) (*connect.Response[v1pb.UserStats], error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, err := f.authenticate(req.Header()); err != nil {
+		return nil, err
+	}
+	f.statsRequests++
+	stats := &v1pb.UserStats{Name: req.Msg.GetName() + "/stats", TagCount: map[string]int32{}}
+	for _, memo := range f.memos {
+		if memo.Creator != req.Msg.GetName() {
+			continue
+		}
+		stats.TotalMemoCount++
+		for _, tag := range memo.Tags {
+			stats.TagCount[tag]++
+		}
+	}
+	return connect.NewResponse(stats), nil
+}
+
+// fakeTag matches tags like Memos, which ends them at whitespace.
+var fakeTag = regexp.MustCompile(`(?:^|\s)#([^\s#]+)`)
+
+func fakeExtractTags(content string) []string {
+	var tags []string
+	for _, groups := range fakeTag.FindAllStringSubmatch(content, -1) {
+		tags = append(tags, groups[1])
+	}
+	return tags
+}
+
+func (f *fakeMemos) ListMemos(_ context.Context, req *connect.Request[v1pb.ListMemosRequest]) (*connect.Response[v1pb.ListMemosResponse], error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	user, err := f.authenticate(req.Header())
+	if err != nil {
+		return nil, err
+	}
+	match, err := f.compileFakeFilter(req.Msg.GetFilter())
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	state := req.Msg.GetState()
+	if state == v1pb.State_STATE_UNSPECIFIED {
+		state = v1pb.State_NORMAL
+	}
+
+	var memos []*v1pb.Memo
+	// Newest first, like Memos.
+	for i := len(f.memos) - 1; i >= 0; i-- {
+		memo := f.memos[i]
+		if memo.Creator != user.Name || memo.State != state || !match(memo) {
+			continue
+		}
+		memos = append(memos, f.copyMemo(memo))
+		if size := int(req.Msg.GetPageSize()); size > 0 && len(memos) == size {
+			break
+		}
+	}
+	return connect.NewResponse(&v1pb.ListMemosResponse{Memos: memos}), nil
+}
+
+// fakeFilterToken matches the tokens of the filter expressions the fake
+// understands: strings, numbers, operators and identifiers.
+var fakeFilterToken = regexp.MustCompile(`^\s*(?:("(?:[^"\\]|\\.)*")|(&&|\|\||==|>=|[!()\[\]<>])|([A-Za-z_][A-Za-z0-9_.]*|[0-9]+))`)
+
+// fakeFilter parses the subset of CEL that compileSearchQuery and
+// buildMemoSearchFilter emit, and rejects everything else. The mutex must be
+// held while its matchers run.
+type fakeFilter struct {
+	f      *fakeMemos
+	tokens []string
+}
+
+type fakeMatcher func(*v1pb.Memo) bool
+
+// compileFakeFilter compiles filter, where an empty filter matches all memos.
+func (f *fakeMemos) compileFakeFilter(filter string) (fakeMatcher, error) {
+	if strings.TrimSpace(filter) == "" {
+		return func(*v1pb.Memo) bool { return true }, nil
+	}
+	p := &fakeFilter{f: f}
+	for rest := filter; strings.TrimSpace(rest) != ""; {
+		groups := fakeFilterToken.FindStringSubmatch(rest)
+		if groups == nil {
+			return nil, fmt.Errorf("unsupported filter %q", rest)
+		}
+		p.tokens = append(p.tokens, strings.TrimSpace(groups[0]))
+		rest = rest[len(groups[0]):]
+	}
+	match, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if len(p.tokens) > 0 {
+		return nil, fmt.Errorf("unexpected %q in filter", p.tokens[0])
+	}
+	return match, nil
+}
+
+func (p *fakeFilter) next() string {
+	if len(p.tokens) == 0 {
+		return ""
+	}
+	token := p.tokens[0]
+	p.tokens = p.tokens[1:]
+	return token
+}
+
+func (p *fakeFilter) expect(tokens ...string) error {
+	for _, want := range tokens {
+		if got := p.next(); got != want {
+			return fmt.Errorf("expected %q in filter, got %q", want, got)
+		}
+	}
+	return nil
+}
+
+func (p *fakeFilter) str() (string, error) {
+	token := p.next()
+	value, err := strconv.Unquote(token)
+	if err != nil || !strings.HasPrefix(token, `"`) {
+		return "", fmt.Errorf("expected a string in filter, got %q", token)
+	}
+	return value, nil
+}
+
+func (p *fakeFilter) or() (fakeMatcher, error) {
+	left, err := p.and()
+	for err == nil && len(p.tokens) > 0 && p.tokens[0] == "||" {
+		p.next()
+		var right fakeMatcher
+		if right, err = p.and(); err == nil {
+			l := left
+			left = func(memo *v1pb.Memo) bool { return l(memo) || right(memo) }
+		}
+	}
+	return left, err
+}
+
+func (p *fakeFilter) and() (fakeMatcher, error) {
+	left, err := p.unary()
+	for err == nil && len(p.tokens) > 0 && p.tokens[0] == "&&" {
+		p.next()
+		var right fakeMatcher
+		if right, err = p.unary(); err == nil {
+			l := left
+			left = func(memo *v1pb.Memo) bool { return l(memo) && right(memo) }
+		}
+	}
+	return left, err
+}
+
+func (p *fakeFilter) unary() (fakeMatcher, error) {
+	switch token := p.next(); token {
+	case "!":
+		match, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(memo *v1pb.Memo) bool { return !match(memo) }, nil
+	case "(":
+		match, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return match, p.expect(")")
+	case "content.contains":
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		text, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		return func(memo *v1pb.Memo) bool { return strings.Contains(memo.Content, text) }, p.expect(")")
+	case "creator", "visibility":
+		if err := p.expect("=="); err != nil {
+			return nil, err
+		}
+		value, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		if token == "creator" {
+			return func(memo *v1pb.Memo) bool { return memo.Creator == value }, nil
+		}
+		return func(memo *v1pb.Memo) bool { return memo.Visibility.String() == value }, nil
+	case "tag":
+		if err := p.expect("in", "["); err != nil {
+			return nil, err
+		}
+		tag, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		return func(memo *v1pb.Memo) bool { return hasTag(memo, tag) }, p.expect("]")
+	case "pinned":
+		if err := p.expect("=="); err != nil {
+			return nil, err
+		}
+		pinned, err := strconv.ParseBool(p.next())
+		if err != nil {
+			return nil, err
+		}
+		return func(memo *v1pb.Memo) bool { return memo.Pinned == pinned }, nil
+	case "created_ts":
+		operator := p.next()
+		seconds, err := strconv.ParseInt(p.next(), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		switch operator {
+		case ">=":
+			return func(memo *v1pb.Memo) bool { return memo.CreateTime.AsTime().Unix() >= seconds }, nil
+		case "<":
+			return func(memo *v1pb.Memo) bool { return memo.CreateTime.AsTime().Unix() < seconds }, nil
+		}
+		return nil, fmt.Errorf("unsupported created_ts operator %q", operator)
+	case "attachments.size":
+		if err := p.expect("(", ")", ">", "0"); err != nil {
+			return nil, err
+		}
+		return func(memo *v1pb.Memo) bool { return len(p.f.copyMemo(memo).Attachments) > 0 }, nil
+	case "has_link":
+		return func(memo *v1pb.Memo) bool { return strings.Contains(memo.Content, "://") }, nil
+	case "has_task_list":
+		return func(memo *v1pb.Memo) bool { return fakeTask.MatchString(memo.Content) }, nil
+	default:
+		return nil, fmt.Errorf("unsupported filter term %q", token)
+	}
+}
+
+// fakeTask matches Markdown task list items.
+var fakeTask = regexp.MustCompile(`(?m)^\s*- \[[ xX]\] `)
+
+func (f *fakeMemos) CreateAttachment(_ context.Context, req *connect.Request[v1pb.CreateAttachmentRequest]) (*connect.Response[v1pb.Attachment], error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	user, err := f.authenticate(req.Header())
+	if err != nil {
+		return nil, err
+	}
+	attachment := req.Msg.GetAttachment()
+	if attachment.Memo != nil {
+		if _, err := f.findMemo(user, attachment.GetMemo()); err != nil {
+			return nil, err
+		}
+	}
+	f.nextID++
+	attachment.Name = fmt.Sprintf("attachments/%d", f.nextID)
+	f.attachments = append(f.attachments, attachment)
+	return connect.NewResponse(&v1pb.Attachment{Name: attachment.Name, Filename: attachment.Filename, Type: attachment.Type, Size: attachment.Size}), nil
+}
+
+func (f *fakeMemos) ListShortcuts(_ context.Context, req *connect.Request[v1pb.ListShortcutsRequest]) (*connect.Response[v1pb.ListShortcutsResponse], error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	user, err := f.authenticate(req.Header())
+	if err != nil {
+		return nil, err
+	}
+	if req.Msg.GetParent() != user.Name {
+		return nil, connect.NewError(connect.CodePermissionDenied, errors.New("permission denied"))
+	}
+	var shortcuts []*v1pb.Shortcut
+	for _, shortcut := range f.shortcuts {
+		if strings.HasPrefix(shortcut.Name, user.Name+"/shortcuts/") {
+			shortcuts = append(shortcuts, shortcut)
+		}
+	}
+	return connect.NewResponse(&v1pb.ListShortcutsResponse{Shortcuts: shortcuts}), nil
+}
+
+func (f *fakeMemos) GetShortcut(_ context.Context, req *connect.Request[v1pb.GetShortcutRequest]) (*connect.Response[v1pb.Shortcut], error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	user, err := f.authenticate(req.Header())
+	if err != nil {
+		return nil, err
+	}
+	for _, shortcut := range f.shortcuts {
+		if shortcut.Name == req.Msg.GetName() && strings.HasPrefix(shortcut.Name, user.Name+"/") {
+			return connect.NewResponse(shortcut), nil
+		}
+	}
+	return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("shortcut %s not found", req.Msg.GetName()))
+}
+
+func (f *fakeMemos) ListMemoReactions(_ context.Context, req *connect.Request[v1pb.ListMemoReactionsRequest]) (*connect.Response[v1pb.ListMemoReactionsResponse], error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	user, err := f.authenticate(req.Header())
+	if err != nil {
+		return nil, err
+	}
+	memo, err := f.findMemo(user, req.Msg.GetName())
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&v1pb.ListMemoReactionsResponse{Reactions: f.copyMemo(memo).Reactions}), nil
+}
+
+// UpsertMemoReaction adds a reaction unless the user already gave it.
+func (f *fakeMemos) UpsertMemoReaction(_ context.Context, req *connect.Request[v1pb.UpsertMemoReactionRequest]) (*connect.Response[v1pb.Reaction], error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	user, err := f.authenticate(req.Header())
+	if err != nil {
+		return nil, err
+	}
+	memo, err := f.findMemo(user, req.Msg.GetName())
+	if err != nil {
+		return nil, err
+	}
+	reactionType := req.Msg.GetReaction().GetReactionType()
+	for _, reaction := range f.reactions {
+		if reaction.ContentId == memo.Name && reaction.Creator == user.Name && reaction.ReactionType == reactionType {
+			return connect.NewResponse(f.copyReaction(reaction)), nil
+		}
+	}
+	f.nextID++
+	reaction := &v1pb.Reaction{
+		Name:         fmt.Sprintf("reactions/%d", f.nextID),
+		Creator:      user.Name,
+		ContentId:    memo.Name,
+		ReactionType: reactionType,
+	}
+	f.reactions = append(f.reactions, reaction)
+	return connect.NewResponse(f.copyReaction(reaction)), nil
+}
+
+func (f *fakeMemos) DeleteMemoReaction(_ context.Context, req *connect.Request[v1pb.DeleteMemoReactionRequest]) (*connect.Response[emptypb.Empty], error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	user, err := f.authenticate(req.Header())
+	if err != nil {
+		return nil, err
+	}
+	for i, reaction := range f.reactions {
+		if reaction.Name == req.Msg.GetName() && reaction.Creator == user.Name {
+			f.reactions = append(f.reactions[:i], f.reactions[i+1:]...)
+			return connect.NewResponse(&emptypb.Empty{}), nil
+		}
+	}
+	return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("reaction %s not found", req.Msg.GetName()))
+}
+
+// SetMemoRelations replaces the references of a memo, like Memos does.
+func (f *fakeMemos) SetMemoRelations(_ context.Context, req *connect.Request[v1pb.SetMemoRelationsRequest]) (*connect.Response[emptypb.Empty], error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	user, err := f.authenticate(req.Header())
+	if err != nil {
+		return nil, err
+	}
+	memo, err := f.findMemo(user, req.Msg.GetName())
+	if err != nil {
+		return nil, err
+	}
+	var relations []*v1pb.MemoRelation
+	for _, relation := range f.relations {
+		if relation.Memo.Name != memo.Name || relation.Type != v1pb.MemoRelation_REFERENCE {
+			relations = append(relations, relation)
+		}
+	}
+	for _, relation := range req.Msg.GetRelations() {
+		if relation.Type != v1pb.MemoRelation_REFERENCE {
+			continue
+		}
+		if _, err := f.findMemo(user, relation.GetRelatedMemo().GetName()); err != nil {
+			return nil, err
+		}
+		relations = append(relations, &v1pb.MemoRelation{
+			Memo:        &v1pb.MemoRelation_Memo{Name: memo.Name},
+			RelatedMemo: &v1pb.MemoRelation_Memo{Name: relation.GetRelatedMemo().GetName()},
+			Type:        relation.Type,
+		})
+	}
+	f.relations = relations
+	return connect.NewResponse(&emptypb.Empty{}), nil
+}
+
+// ListMemoRelations returns the relations from and to a memo, with snippets.
+func (f *fakeMemos) ListMemoRelations(_ context.Context, req *connect.Request[v1pb.ListMemoRelationsRequest]) (*connect.Response[v1pb.ListMemoRelationsResponse], error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	user, err := f.authenticate(req.Header())
+	if err != nil {
+		return nil, err
+	}
+	memo, err := f.findMemo(user, req.Msg.GetName())
+	if err != nil {
+		return nil, err
+	}
+	snippet := func(name string) *v1pb.MemoRelation_Memo {
+		related := &v1pb.MemoRelation_Memo{Name: name}
+		for _, memo := range f.memos {
+			if memo.Name == name {
+				related.Snippet = memo.Content
+			}
+		}
+		return related
+	}
+	var relations []*v1pb.MemoRelation
+	for _, relation := range f.relations {
+		if relation.Memo.Name == memo.Name || relation.RelatedMemo.Name == memo.Name {
+			relations = append(relations, &v1pb.MemoRelation{
+				Memo:        snippet(relation.Memo.Name),
+				RelatedMemo: snippet(relation.RelatedMemo.Name),
+				Type:        relation.Type,
+			})
+		}
+	}
+	return connect.NewResponse(&v1pb.ListMemoRelationsResponse{Relations: relations}), nil
+}
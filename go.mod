@@ -11,6 +11,7 @@ require (
 require (
 	github.com/go-telegram/bot v1.20.0
 	github.com/joho/godotenv v1.5.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require connectrpc.com/connect v1.19.1
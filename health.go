@@ -0,0 +1,85 @@
+package memogram
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/go-telegram/bot"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+)
+
+const (
+	profileRetryInitialBackoff = time.Second
+	profileRetryMaxBackoff     = 5 * time.Minute
+	profileRefreshInterval     = 30 * time.Minute
+)
+
+// maintainInstanceProfile fetches the instance profile until it succeeds,
+// backing off between attempts, and then refreshes it periodically until ctx
+// is done. The stored tokens are validated once Memos is first reachable.
+func (s *Service) maintainInstanceProfile(ctx context.Context) {
+	backoff := profileRetryInitialBackoff
+	validated := false
+	for {
+		delay := profileRefreshInterval
+		if err := s.fetchInstanceProfile(ctx); err != nil {
+			s.logger.Warn("failed to get instance profile, retrying", slog.Duration("delay", backoff), slog.Any("err", err))
+			delay = backoff
+			backoff = min(backoff*2, profileRetryMaxBackoff)
+		} else {
+			backoff = profileRetryInitialBackoff
+			if !validated {
+				validated = true
+				s.validateStoredTokens(ctx)
+			}
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Service) fetchInstanceProfile(ctx context.Context) error {
+	resp, err := s.client.InstanceService.GetInstanceProfile(ctx, connect.NewRequest(&v1pb.GetInstanceProfileRequest{}))
+	if err != nil {
+		return err
+	}
+	if s.instanceProfile.Swap(resp.Msg) == nil {
+		s.logger.Info("instance profile", slog.Any("profile", resp.Msg))
+	}
+	return nil
+}
+
+// validateStoredTokens checks every stored access token with GetCurrentUser
+// and tells users which of their accounts need a new token. Tokens that
+// can't be checked, e.g. because their instance is down, are left alone.
+func (s *Service) validateStoredTokens(ctx context.Context) {
+	for _, userID := range s.store.ListUserIDs() {
+		credentials, _ := s.store.ListUserCredentials(userID)
+		var expired []string
+		for _, credential := range credentials {
+			_, err := s.currentUser(ctx, credential.Instance, credential.AccessToken)
+			switch {
+			case err == nil:
+			case connect.CodeOf(err) == connect.CodeUnauthenticated:
+				expired = append(expired, fmt.Sprintf("%s (%s)", credential.Account, s.instanceURL(credential.Instance)))
+			default:
+				s.logger.Warn("failed to validate access token", slog.Int64("user", userID), slog.String("account", credential.Account), slog.Any("err", err))
+			}
+		}
+		if len(expired) == 0 {
+			continue
+		}
+
+		s.logger.Info("found expired access tokens", slog.Int64("user", userID), slog.Int("accounts", len(expired)))
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: userID,
+			Text: fmt.Sprintf("The access token of these accounts has expired or was revoked:\n%s\n\nPlease send /start [!account] <access_token> or /login again.",
+				strings.Join(expired, "\n")),
+		})
+	}
+}
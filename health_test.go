@@ -0,0 +1,73 @@
+package memogram
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/usememos/memogram/store"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+	"github.com/usememos/memos/proto/gen/api/v1/apiv1connect"
+)
+
+type fakeInstanceService struct {
+	apiv1connect.UnimplementedInstanceServiceHandler
+	profile *v1pb.InstanceProfile
+}
+
+func (f *fakeInstanceService) GetInstanceProfile(context.Context, *connect.Request[v1pb.GetInstanceProfileRequest]) (*connect.Response[v1pb.InstanceProfile], error) {
+	return connect.NewResponse(f.profile), nil
+}
+
+// fakeAuthService accepts a single access token.
+type fakeAuthService struct {
+	apiv1connect.UnimplementedAuthServiceHandler
+	validToken string
+}
+
+func (f *fakeAuthService) GetCurrentUser(ctx context.Context, req *connect.Request[v1pb.GetCurrentUserRequest]) (*connect.Response[v1pb.GetCurrentUserResponse], error) {
+	if req.Header().Get("Authorization") != "Bearer "+f.validToken {
+		return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("invalid access token"))
+	}
+	return connect.NewResponse(&v1pb.GetCurrentUserResponse{User: &v1pb.User{Name: "users/1"}}), nil
+}
+
+func TestHealthRoutine(t *testing.T) {
+	ctx := context.Background()
+	mux := http.NewServeMux()
+	mux.Handle(apiv1connect.NewInstanceServiceHandler(&fakeInstanceService{
+		profile: &v1pb.InstanceProfile{InstanceUrl: "https://memos.example.com"},
+	}))
+	mux.Handle(apiv1connect.NewAuthServiceHandler(&fakeAuthService{validToken: "valid"}))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	api, b := newFakeBotAPI(t)
+	api.enqueue("sendMessage", fakeSentMessage)
+	s := newTestAccessService(t)
+	s.config = &Config{ServerAddr: server.URL}
+	s.client = NewMemosClient(server.URL)
+	s.sender = newTestSender(b)
+	s.store.SetUserCredential(1, store.Credential{AccessToken: "valid"})
+	s.store.SetUserCredential(2, store.Credential{AccessToken: "expired"})
+	s.store.SetUserCredential(2, store.Credential{Account: "work", AccessToken: "valid"})
+
+	if got := s.instanceURL(""); got != server.URL {
+		t.Fatalf("expected the server address before the profile is known, got %q", got)
+	}
+	if err := s.fetchInstanceProfile(ctx); err != nil {
+		t.Fatalf("fetch instance profile: %v", err)
+	}
+	if got := s.instanceURL(""); got != "https://memos.example.com" {
+		t.Fatalf("expected the instance URL of the profile, got %q", got)
+	}
+
+	s.validateStoredTokens(ctx)
+	// Only user 2 has an expired token.
+	if calls := api.callCount("sendMessage"); calls != 1 {
+		t.Fatalf("expected 1 notification, got %d", calls)
+	}
+}
@@ -0,0 +1,408 @@
+package memogram
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"connectrpc.com/connect"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/usememos/memogram/store"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+	fieldmaskpb "google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+const (
+	callbackListPrefix = "ls "
+
+	// listPageSize is the number of memos per page of a list.
+	listPageSize = 5
+	// defaultRecentCount and maxRecentCount bound the memos of /recent.
+	defaultRecentCount = 10
+	maxRecentCount     = 100
+	// maxSnippetLength is the number of characters of a memo shown in a list.
+	maxSnippetLength = 48
+)
+
+// Actions of the buttons of a memo list.
+const (
+	listActionOpen    = "o"
+	listActionPin     = "p"
+	listActionRestore = "r"
+)
+
+// memoListKind is a kind of memo list, identified by a short code in the
+// callback data.
+type memoListKind struct {
+	title  string
+	empty  string
+	state  v1pb.State
+	filter string
+}
+
+var memoListKinds = map[string]memoListKind{
+	"r": {title: "Recent memos", empty: "No memos yet.", state: v1pb.State_NORMAL},
+	"p": {title: "Pinned memos", empty: "No pinned memos.", state: v1pb.State_NORMAL, filter: "pinned == true"},
+	"a": {title: "Archived memos", empty: "No archived memos.", state: v1pb.State_ARCHIVED},
+}
+
+// shortcutListKind is the kind code of the lists of shortcuts, which is
+// followed by the ID of the shortcut in the callback data.
+const shortcutListKind = "s:"
+
+// memoListPage is one page of a memo list of an account.
+type memoListPage struct {
+	// kind is a key of memoListKinds, or shortcutListKind followed by the ID
+	// of a shortcut.
+	kind string
+	page int
+	// limit is the total number of memos of the list, or 0 for no limit.
+	limit   int
+	account string
+}
+
+// callbackData returns the callback data of a button showing the page, or
+// acting on the memo with uid when action is set.
+func (p memoListPage) callbackData(action, uid string) string {
+	data := fmt.Sprintf("%s%s %d %d %s", callbackListPrefix, p.kind, p.page, p.limit, p.account)
+	if action != "" {
+		data += " " + action + " " + uid
+	}
+	return data
+}
+
+// parseMemoListCallback parses the callback data of a memo list button.
+func parseMemoListCallback(data string) (page memoListPage, action, uid string, ok bool) {
+	fields := strings.Fields(strings.TrimPrefix(data, callbackListPrefix))
+	if len(fields) != 4 && len(fields) != 6 {
+		return page, "", "", false
+	}
+	page.kind, page.account = fields[0], fields[3]
+	var err error
+	if page.page, err = strconv.Atoi(fields[1]); err != nil || page.page < 0 {
+		return page, "", "", false
+	}
+	if page.limit, err = strconv.Atoi(fields[2]); err != nil || page.limit < 0 {
+		return page, "", "", false
+	}
+	if _, ok := memoListKinds[page.kind]; !ok && strings.TrimPrefix(page.kind, shortcutListKind) == page.kind {
+		return page, "", "", false
+	}
+	if len(fields) == 6 {
+		action, uid = fields[4], fields[5]
+	}
+	return page, action, uid, true
+}
+
+// listHandler handles /recent [n], /pinned and /archived.
+func (s *Service) listHandler(ctx context.Context, b *bot.Bot, m *models.Update) {
+	page := memoListPage{kind: "r"}
+	switch {
+	case isCommand(m.Message.Text, commandPinned):
+		page.kind = "p"
+	case isCommand(m.Message.Text, commandArchived):
+		page.kind = "a"
+	default:
+		page.limit = defaultRecentCount
+		if arg := strings.TrimSpace(strings.TrimPrefix(m.Message.Text, commandRecent)); arg != "" {
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 1 || n > maxRecentCount {
+				s.sender.SendMessage(ctx, &bot.SendMessageParams{
+					ChatID: m.Message.Chat.ID,
+					Text:   fmt.Sprintf("Usage: /recent [n], where n is between 1 and %d", maxRecentCount),
+				})
+				return
+			}
+			page.limit = n
+		}
+	}
+
+	client, credential, ok := s.userClient(m.Message.From.ID)
+	if !ok {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   "Please start the bot with /start <access_token>",
+		})
+		return
+	}
+	page.account = credential.Account
+	text, markup, err := s.renderMemoList(ctx, client, credential, page)
+	if err != nil {
+		s.logger.Error("failed to list memos", slog.Any("err", err))
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   "Failed to list memos",
+		})
+		return
+	}
+	s.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      m.Message.Chat.ID,
+		Text:        text,
+		ReplyMarkup: markup,
+	})
+}
+
+// memoListKindOf returns the kind of the list of page, looking up the filter
+// of a shortcut list.
+func memoListKindOf(ctx context.Context, client *MemosClient, user *v1pb.User, page memoListPage) (memoListKind, error) {
+	id, ok := strings.CutPrefix(page.kind, shortcutListKind)
+	if !ok {
+		return memoListKinds[page.kind], nil
+	}
+	resp, err := client.ShortcutService.GetShortcut(ctx, connect.NewRequest(&v1pb.GetShortcutRequest{
+		Name: fmt.Sprintf("%s/shortcuts/%s", user.GetName(), id),
+	}))
+	if err != nil {
+		return memoListKind{}, fmt.Errorf("get shortcut %s: %w", id, err)
+	}
+	return memoListKind{
+		title:  resp.Msg.GetTitle(),
+		empty:  fmt.Sprintf("No memos match the shortcut %s.", resp.Msg.GetTitle()),
+		state:  v1pb.State_NORMAL,
+		filter: resp.Msg.GetFilter(),
+	}, nil
+}
+
+// listMemos returns the memos of user of one page of a list and whether
+// there are more. Memos pages by opaque tokens, so the list is fetched from
+// its start.
+func listMemos(ctx context.Context, client *MemosClient, user *v1pb.User, kind memoListKind, page memoListPage) ([]*v1pb.Memo, bool, error) {
+	var filters []string
+	if kind.filter != "" {
+		filters = append(filters, "("+kind.filter+")")
+	}
+	if creator := memoCreatorName(user); creator != "" {
+		filters = append(filters, fmt.Sprintf("creator == %q", creator))
+	}
+
+	start := page.page * listPageSize
+	// One more than needed tells whether there is a next page.
+	size := start + listPageSize + 1
+	if page.limit > 0 {
+		size = min(size, page.limit)
+	}
+	resp, err := client.MemoService.ListMemos(ctx, connect.NewRequest(&v1pb.ListMemosRequest{
+		PageSize: int32(size),
+		State:    kind.state,
+		Filter:   strings.Join(filters, " && "),
+	}))
+	if err != nil {
+		return nil, false, err
+	}
+	memos := resp.Msg.GetMemos()
+	if start >= len(memos) {
+		return nil, false, nil
+	}
+	end := min(start+listPageSize, len(memos))
+	return memos[start:end], len(memos) > end, nil
+}
+
+// renderMemoList returns the text and buttons of a page of a memo list. A
+// page that became empty, e.g. after its last memo was restored, shows the
+// previous page instead.
+func (s *Service) renderMemoList(ctx context.Context, client *MemosClient, credential store.Credential, page memoListPage) (string, *models.InlineKeyboardMarkup, error) {
+	user, err := s.currentUser(ctx, credential.Instance, credential.AccessToken)
+	if err != nil {
+		return "", nil, err
+	}
+	kind, err := memoListKindOf(ctx, client, user, page)
+	if err != nil {
+		return "", nil, err
+	}
+	memos, more, err := listMemos(ctx, client, user, kind, page)
+	for err == nil && len(memos) == 0 && page.page > 0 {
+		page.page--
+		memos, more, err = listMemos(ctx, client, user, kind, page)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if len(memos) == 0 {
+		return kind.empty, nil, nil
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s, page %d:", kind.title, page.page+1)
+	keyboard := &models.InlineKeyboardMarkup{}
+	for i, memo := range memos {
+		number := page.page*listPageSize + i + 1
+		marker := ""
+		if memo.Pinned {
+			marker = "📌 "
+		}
+		fmt.Fprintf(&sb, "\n%d. %s%s", number, marker, memoSnippet(memo.Content))
+
+		uid, err := ExtractMemoUIDFromName(memo.Name)
+		if err != nil {
+			continue
+		}
+		buttons := []models.InlineKeyboardButton{{Text: fmt.Sprintf("Open %d", number), CallbackData: page.callbackData(listActionOpen, uid)}}
+		switch {
+		case kind.state == v1pb.State_ARCHIVED:
+			buttons = append(buttons, models.InlineKeyboardButton{Text: fmt.Sprintf("Restore %d", number), CallbackData: page.callbackData(listActionRestore, uid)})
+		case memo.Pinned:
+			buttons = append(buttons, models.InlineKeyboardButton{Text: fmt.Sprintf("Unpin %d", number), CallbackData: page.callbackData(listActionPin, uid)})
+		default:
+			buttons = append(buttons, models.InlineKeyboardButton{Text: fmt.Sprintf("Pin %d", number), CallbackData: page.callbackData(listActionPin, uid)})
+		}
+		// Telegram rejects callback data longer than 64 bytes.
+		if len(buttons[len(buttons)-1].CallbackData) > 64 {
+			continue
+		}
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, buttons)
+	}
+
+	var navigation []models.InlineKeyboardButton
+	if page.page > 0 {
+		previous := page
+		previous.page--
+		navigation = append(navigation, models.InlineKeyboardButton{Text: "« Previous", CallbackData: previous.callbackData("", "")})
+	}
+	if more {
+		next := page
+		next.page++
+		navigation = append(navigation, models.InlineKeyboardButton{Text: "Next »", CallbackData: next.callbackData("", "")})
+	}
+	if len(navigation) > 0 {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, navigation)
+	}
+	return sb.String(), keyboard, nil
+}
+
+// memoSnippet returns the first line of the content of a memo, shortened to
+// maxSnippetLength characters.
+func memoSnippet(content string) string {
+	var line string
+	for _, candidate := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(candidate); line != "" {
+			break
+		}
+	}
+	if line == "" {
+		return "(no text)"
+	}
+	if utf8.RuneCountInString(line) > maxSnippetLength {
+		line = string([]rune(line)[:maxSnippetLength-1]) + "…"
+	}
+	return line
+}
+
+// listCallbackHandler handles the buttons of memo lists.
+func (s *Service) listCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	query := update.CallbackQuery
+	if !s.allowCallback(ctx, query) {
+		return
+	}
+	page, action, uid, ok := parseMemoListCallback(query.Data)
+	if !ok || query.Message.Message == nil {
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            "Invalid command",
+			ShowAlert:       true,
+		})
+		return
+	}
+	message := query.Message.Message
+	client, credential, ok := s.accountClient(query.From.ID, page.account)
+	if !ok {
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            "Please start the bot with /start <access_token>",
+			ShowAlert:       true,
+		})
+		return
+	}
+
+	answer := ""
+	if action != "" {
+		resp, err := client.MemoService.GetMemo(ctx, connect.NewRequest(&v1pb.GetMemoRequest{Name: "memos/" + uid}))
+		if err != nil {
+			s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+				CallbackQueryID: query.ID,
+				Text:            fmt.Sprintf("Memo memos/%s not found", uid),
+				ShowAlert:       true,
+			})
+			return
+		}
+		memo := resp.Msg
+
+		var details string
+		changes := &v1pb.Memo{Name: memo.Name}
+		var paths []string
+		switch action {
+		case listActionOpen:
+			s.sender.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID:      message.Chat.ID,
+				Text:        withReactions(memo.Name+"\n"+memo.Content, memo),
+				ReplyMarkup: s.keyboard(memo, credential.Account, nil),
+			})
+			s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: query.ID})
+			return
+		case listActionPin:
+			changes.Pinned = !memo.Pinned
+			paths, details, answer = []string{"pinned"}, "unpin", "Memo unpinned"
+			if changes.Pinned {
+				details, answer = "pin", "Memo pinned"
+			}
+		case listActionRestore:
+			changes.State = v1pb.State_NORMAL
+			paths, details, answer = []string{"state"}, "restore", "Memo restored"
+		default:
+			s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+				CallbackQueryID: query.ID,
+				Text:            "Unknown action",
+				ShowAlert:       true,
+			})
+			return
+		}
+
+		_, err = client.MemoService.UpdateMemo(ctx, connect.NewRequest(&v1pb.UpdateMemoRequest{
+			Memo:       changes,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: paths},
+		}))
+		result, errDetails := auditResult(err)
+		s.audit.Record(auditEvent{
+			Action:  auditActionMemoUpdate,
+			UserID:  query.From.ID,
+			ChatID:  message.Chat.ID,
+			Account: credential.Account,
+			Memo:    memo.Name,
+			Result:  result,
+			Details: joinDetails(details, errDetails),
+		})
+		if err != nil {
+			s.logger.Error("failed to update memo", slog.Any("err", err))
+			s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+				CallbackQueryID: query.ID,
+				Text:            "Failed to update memo",
+				ShowAlert:       true,
+			})
+			return
+		}
+	}
+
+	text, markup, err := s.renderMemoList(ctx, client, credential, page)
+	if err != nil {
+		s.logger.Error("failed to list memos", slog.Any("err", err))
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            "Failed to list memos",
+			ShowAlert:       true,
+		})
+		return
+	}
+	s.sender.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:      message.Chat.ID,
+		MessageID:   message.ID,
+		Text:        text,
+		ReplyMarkup: markup,
+	})
+	s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+		CallbackQueryID: query.ID,
+		Text:            answer,
+	})
+}
@@ -0,0 +1,117 @@
+package memogram
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+)
+
+func TestParseMemoListCallback(t *testing.T) {
+	page := memoListPage{kind: "a", page: 2, limit: 0, account: "work"}
+	parsed, action, uid, ok := parseMemoListCallback(page.callbackData(listActionRestore, "abc"))
+	if !ok || parsed != page || action != listActionRestore || uid != "abc" {
+		t.Fatalf("unexpected parse result %+v %q %q %t", parsed, action, uid, ok)
+	}
+	for _, data := range []string{"ls x 0 0 work", "ls r -1 0 work", "ls r 0 0", "ls r 0 0 work o"} {
+		if _, _, _, ok := parseMemoListCallback(data); ok {
+			t.Fatalf("expected %q to be invalid", data)
+		}
+	}
+}
+
+func TestMemoSnippet(t *testing.T) {
+	for content, want := range map[string]string{
+		"\n  First line \nsecond": "First line",
+		"":                        "(no text)",
+		strings.Repeat("é", 60):   strings.Repeat("é", maxSnippetLength-1) + "…",
+	} {
+		if got := memoSnippet(content); got != want {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestE2ERecentPagination(t *testing.T) {
+	e := newE2E(t)
+	for i := 1; i <= 7; i++ {
+		e.send(textMessage(1, fmt.Sprintf("Memo %d", i)))
+	}
+
+	e.send(textMessage(1, "/recent 6"))
+	list := e.api.sent("sendMessage")[7]
+	if !strings.HasPrefix(list.Get("text"), "Recent memos, page 1:\n1. Memo 7\n") || strings.Contains(list.Get("text"), "Memo 2") {
+		t.Fatalf("unexpected list %q", list.Get("text"))
+	}
+	if !strings.Contains(list.Get("reply_markup"), `"text":"Next »","callback_data":"ls r 1 6 default"`) {
+		t.Fatalf("expected a next button, got %s", list.Get("reply_markup"))
+	}
+
+	e.press(1, "ls r 1 6 default")
+	if text := e.lastText(t, "editMessageText"); text != "Recent memos, page 2:\n6. Memo 2" {
+		t.Fatalf("unexpected page %q", text)
+	}
+	if markup := e.api.sent("editMessageText")[0].Get("reply_markup"); strings.Contains(markup, "Next") || !strings.Contains(markup, "ls r 0 6 default") {
+		t.Fatalf("expected only a previous button, got %s", markup)
+	}
+
+	e.send(textMessage(1, "/recent 1000"))
+	if text := e.lastText(t, "sendMessage"); !strings.HasPrefix(text, "Usage: /recent [n]") {
+		t.Fatalf("unexpected reply %q", text)
+	}
+}
+
+func TestE2EPinnedAndArchived(t *testing.T) {
+	e := newE2E(t)
+	e.send(textMessage(1, "Keep"))
+	e.send(textMessage(1, "Old"))
+	e.send(textMessage(2, "Other"))
+
+	e.send(textMessage(1, "/pinned"))
+	if text := e.lastText(t, "sendMessage"); text != "No pinned memos." {
+		t.Fatalf("unexpected reply %q", text)
+	}
+
+	e.send(textMessage(1, "/recent"))
+	e.press(1, "ls r 0 10 default p 1")
+	if memo, _ := e.memos.memo("memos/1"); !memo.Pinned {
+		t.Fatal("expected the memo to be pinned")
+	}
+	if text := e.lastText(t, "editMessageText"); !strings.Contains(text, "2. 📌 Keep") {
+		t.Fatalf("expected the list to show the pinned memo, got %q", text)
+	}
+	e.send(textMessage(1, "/pinned"))
+	if text := e.lastText(t, "sendMessage"); text != "Pinned memos, page 1:\n1. 📌 Keep" {
+		t.Fatalf("unexpected reply %q", text)
+	}
+
+	e.memos.archive("memos/2")
+	e.send(textMessage(1, "/archived"))
+	list := e.api.sent("sendMessage")
+	if text := list[len(list)-1].Get("text"); text != "Archived memos, page 1:\n1. Old" {
+		t.Fatalf("unexpected reply %q", text)
+	}
+	if markup := list[len(list)-1].Get("reply_markup"); !strings.Contains(markup, `"text":"Restore 1","callback_data":"ls a 0 0 default r 2"`) {
+		t.Fatalf("expected a restore button, got %s", markup)
+	}
+
+	e.press(1, "ls a 0 0 default r 2")
+	if memo, _ := e.memos.memo("memos/2"); memo.State != v1pb.State_NORMAL {
+		t.Fatalf("expected the memo to be restored, got %v", memo.State)
+	}
+	if text := e.lastText(t, "editMessageText"); text != "No archived memos." {
+		t.Fatalf("unexpected list %q", text)
+	}
+
+	e.press(1, "ls r 0 10 default o 2")
+	if text := e.lastText(t, "sendMessage"); text != "memos/2\nOld" {
+		t.Fatalf("unexpected memo %q", text)
+	}
+
+	// Other users can't act on the memo.
+	e.press(2, "ls r 0 10 default p 1")
+	if text := e.lastText(t, "answerCallbackQuery"); !strings.Contains(text, "not found") {
+		t.Fatalf("unexpected answer %q", text)
+	}
+}
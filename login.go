@@ -0,0 +1,154 @@
+package memogram
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/usememos/memogram/store"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+)
+
+// personalAccessTokenDescription is the description of the access tokens the
+// bot creates on /login, so that users can find and revoke them in Memos.
+const personalAccessTokenDescription = "Memogram"
+
+// loginHandler signs in with a username and password and stores a dedicated
+// access token for the bot, so users don't have to paste a token into chat.
+func (s *Service) loginHandler(ctx context.Context, b *bot.Bot, m *models.Update) {
+	args := strings.TrimPrefix(m.Message.Text, commandLogin)
+	if hasLoginCredentials(args) {
+		// The message contains a password, so remove it from the chat first.
+		s.deleteSecretMessage(ctx, m.Message)
+	}
+
+	userID := m.Message.From.ID
+	account, instance, username, password, ok := parseLoginArgs(args)
+	if !ok {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   "Usage: /login [!account] [instance_url] <username> <password>",
+		})
+		return
+	}
+
+	if instance == s.client.baseURL {
+		instance = ""
+	}
+	if !s.isInstanceAllowed(instance) {
+		s.auditTokenSet(m, account, auditResultDenied, "instance not allowed: "+instance)
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   fmt.Sprintf("Instance %s is not allowed", instance),
+		})
+		return
+	}
+
+	client := s.memosClient(instance)
+	signIn, err := client.AuthService.SignIn(ctx, connect.NewRequest(&v1pb.SignInRequest{
+		Credentials: &v1pb.SignInRequest_PasswordCredentials_{
+			PasswordCredentials: &v1pb.SignInRequest_PasswordCredentials{
+				Username: username,
+				Password: password,
+			},
+		},
+	}))
+	if err != nil {
+		s.auditTokenSet(m, account, auditResultFailure, "sign in failed")
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   "Failed to sign in, please check your username and password",
+		})
+		return
+	}
+
+	// The session token from signing in expires, so mint a dedicated access
+	// token for the bot.
+	user := signIn.Msg.GetUser()
+	authClient := client.NewAuthenticatedClient(signIn.Msg.GetAccessToken())
+	token, err := authClient.UserService.CreatePersonalAccessToken(ctx, connect.NewRequest(&v1pb.CreatePersonalAccessTokenRequest{
+		Parent:      user.GetName(),
+		Description: personalAccessTokenDescription,
+	}))
+	if err != nil {
+		s.auditTokenSet(m, account, auditResultFailure, joinDetails("create access token", err.Error()))
+		s.sendError(m.Message.Chat.ID, fmt.Errorf("failed to create access token: %w", err))
+		return
+	}
+
+	s.setUserCredential(userID, store.Credential{
+		Account:     account,
+		Instance:    instance,
+		AccessToken: token.Msg.GetToken(),
+	})
+	s.auditTokenSet(m, account, auditResultSuccess, s.instanceURL(instance))
+	s.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: m.Message.Chat.ID,
+		Text:   render(s.logger, s.settings().templates.welcome, welcomeData{Name: user.GetDisplayName(), Account: account}),
+	})
+}
+
+// parseLoginArgs parses `[!account] [instance_url] <username> <password>`.
+// The instance is recognized by its http(s) scheme, and the password is the
+// rest of the text so that it may contain spaces.
+func parseLoginArgs(text string) (account, instance, username, password string, ok bool) {
+	account = store.DefaultAccount
+	field, rest := cutField(text)
+	if strings.HasPrefix(field, "!") {
+		account = strings.TrimPrefix(field, "!")
+		if !isValidAccountName(account) {
+			return "", "", "", "", false
+		}
+		field, rest = cutField(rest)
+	}
+	if strings.HasPrefix(field, "http://") || strings.HasPrefix(field, "https://") {
+		instance = normalizeInstanceURL(field)
+		field, rest = cutField(rest)
+	}
+	username, password = field, strings.TrimSpace(rest)
+	if username == "" || password == "" {
+		return "", "", "", "", false
+	}
+	return account, instance, username, password, true
+}
+
+// hasLoginCredentials reports whether the text after /login holds a
+// username or password, that is anything besides the account and instance,
+// even if it is not valid.
+func hasLoginCredentials(text string) bool {
+	field, rest := cutField(text)
+	if strings.HasPrefix(field, "!") {
+		field, rest = cutField(rest)
+	}
+	if strings.HasPrefix(field, "http://") || strings.HasPrefix(field, "https://") {
+		field, rest = cutField(rest)
+	}
+	return field != "" || strings.TrimSpace(rest) != ""
+}
+
+// cutField returns the first whitespace separated field of text and the text
+// after it.
+func cutField(text string) (string, string) {
+	text = strings.TrimSpace(text)
+	if i := strings.IndexAny(text, " \t\n"); i >= 0 {
+		return text[:i], text[i+1:]
+	}
+	return text, ""
+}
+
+// deleteSecretMessage removes a message that contains credentials from the
+// chat and asks the user to do it when the bot can't.
+func (s *Service) deleteSecretMessage(ctx context.Context, message *models.Message) {
+	if _, err := s.sender.DeleteMessage(ctx, &bot.DeleteMessageParams{
+		ChatID:    message.Chat.ID,
+		MessageID: message.ID,
+	}); err != nil {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: message.Chat.ID,
+			Text:   "I could not delete your message. It contains your credentials, please delete it yourself.",
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package memogram
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLoginArgs(t *testing.T) {
+	account, instance, username, password, ok := parseLoginArgs(" !work https://memos.example.com/ alice correct horse battery")
+	if !ok {
+		t.Fatal("expected login arguments to parse")
+	}
+	if account != "work" || instance != "https://memos.example.com" || username != "alice" || password != "correct horse battery" {
+		t.Fatalf("unexpected arguments: %q %q %q %q", account, instance, username, password)
+	}
+
+	account, instance, username, password, ok = parseLoginArgs(" alice secret")
+	if !ok || account != "default" || instance != "" || username != "alice" || password != "secret" {
+		t.Fatalf("unexpected arguments: %q %q %q %q", account, instance, username, password)
+	}
+
+	for _, text := range []string{"", " alice", " !work alice", " !bad/name alice secret"} {
+		if _, _, _, _, ok := parseLoginArgs(text); ok {
+			t.Fatalf("expected %q to be rejected", text)
+		}
+	}
+}
+
+func TestHasLoginCredentials(t *testing.T) {
+	for text, want := range map[string]bool{
+		"":                                 false,
+		" !work":                           false,
+		" !work https://memos.example.com": false,
+		" alice":                           true,
+		" !bad/name alice secret":          true,
+		" https://memos.example.com alice": true,
+	} {
+		if got := hasLoginCredentials(text); got != want {
+			t.Fatalf("hasLoginCredentials(%q) = %t, want %t", text, got, want)
+		}
+	}
+}
+
+func TestE2ELogin(t *testing.T) {
+	e := newE2E(t)
+	e.memos.setPassword("ann", "correct horse")
+
+	e.send(textMessage(3, "/login ann correct horse"))
+	credential, ok := e.service.store.GetUserCredential(3)
+	if !ok || !strings.HasPrefix(credential.AccessToken, "pat-") || credential.Account != "default" {
+		t.Fatalf("expected a personal access token to be stored, got %+v", credential)
+	}
+	if deleted := e.api.sent("deleteMessage"); len(deleted) != 1 || deleted[0].Get("message_id") != "5" {
+		t.Fatalf("expected the credentials to be deleted, got %v", deleted)
+	}
+	if text := e.lastText(t, "sendMessage"); !strings.HasPrefix(text, "Hello ann!") {
+		t.Fatalf("unexpected reply %q", text)
+	}
+
+	e.send(textMessage(4, "/login ann wrong"))
+	if _, ok := e.service.store.GetUserCredential(4); ok {
+		t.Fatal("expected nothing to be stored after a failed sign in")
+	}
+	if deleted := e.api.sent("deleteMessage"); len(deleted) != 2 {
+		t.Fatalf("expected the credentials to be deleted, got %v", deleted)
+	}
+	if text := e.lastText(t, "sendMessage"); !strings.HasPrefix(text, "Failed to sign in") {
+		t.Fatalf("unexpected reply %q", text)
+	}
+
+	// A bare /login holds no credentials, so it is left in the chat.
+	e.send(textMessage(4, "/login"))
+	if deleted := e.api.sent("deleteMessage"); len(deleted) != 2 {
+		t.Fatalf("expected the usage error not to delete the message, got %v", deleted)
+	}
+	if text := e.lastText(t, "sendMessage"); !strings.HasPrefix(text, "Usage: /login") {
+		t.Fatalf("unexpected reply %q", text)
+	}
+}
@@ -9,10 +9,13 @@ import (
 	"net/http"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"unicode/utf16"
+	"unicode/utf8"
 
 	"connectrpc.com/connect"
 	"github.com/go-telegram/bot"
@@ -24,83 +27,190 @@ import (
 
 type Service struct {
 	bot        *bot.Bot
+	sender     *sender
+	dispatcher *dispatcher
 	client     *MemosClient
 	config     *Config
-	store      *store.Store
+	store      Store
 	httpClient *http.Client
+	logger     *slog.Logger
+
+	// commands are the extra commands of WithCommandHandler.
+	commands []commandHandler
+	// loadConfig reads the configuration again when it is reloaded. Without
+	// it, the configuration is not watched.
+	loadConfig func() (*Config, error)
+	// loadedConfig is the configuration read last, which reloads compare
+	// against. config stays the one the service was started with.
+	loadedConfig *Config
+
+	audit *auditLog
 
 	mediaGroupCache sync.Map
 	mediaGroupMutex sync.Mutex
 
-	instanceProfile  *v1pb.InstanceProfile
-	allowedUsernames map[string]struct{}
+	accessRequests sync.Map // map[int64]*accessRequest
+	// accessDenials holds until when denied users can't request access again.
+	accessDenials sync.Map // map[int64]time.Time
+
+	// messageMemos remembers the memos of messages for reactionHandler.
+	messageMemos messageMemos
+
+	// instanceClients caches unauthenticated clients of non-default instances.
+	instanceClients sync.Map // map[string]*MemosClient
+	// authClients caches authenticated clients and currentUsers the users the
+	// tokens belong to, see authClient.
+	authClients  sync.Map // map[tokenKey]*MemosClient
+	currentUsers sync.Map // map[tokenKey]cachedUser
+	// tagCounts caches the tag counts of the users for tag suggestions.
+	tagCounts sync.Map // map[tokenKey]cachedTagCount
+
+	// instanceProfile is refreshed in the background, see
+	// maintainInstanceProfile.
+	instanceProfile atomic.Pointer[v1pb.InstanceProfile]
+
+	// live holds the settings that are reloaded with the config.
+	live atomic.Pointer[liveSettings]
 }
 
 const (
-	commandStart  = "/start"
-	commandSearch = "/search"
+	commandStart     = "/start"
+	commandLogin     = "/login"
+	commandSearch    = "/search"
+	commandAccounts  = "/accounts"
+	commandSwitch    = "/switch"
+	commandLogout    = "/logout"
+	commandAllow     = "/allow"
+	commandDeny      = "/deny"
+	commandUsers     = "/users"
+	commandRequest   = "/request"
+	commandRecent    = "/recent"
+	commandPinned    = "/pinned"
+	commandArchived  = "/archived"
+	commandShortcuts = "/shortcuts"
+	commandTodo      = "/todo"
+	commandLink      = "/link"
 )
 
+// NewService creates a service from the environment and the config file
+// named by CONFIG_FILE, see LoadConfig. The configuration is reloaded when the
+// file changes or the process receives SIGHUP.
 func NewService() (*Service, error) {
-	config, err := getConfigFromEnv()
+	config, err := LoadConfig()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get config from env: %w", err)
+		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
-
-	// Connect using Connect protocol (HTTP-based, not native gRPC)
-	// ServerAddr can be "localhost:8081", "dns:localhost:8081", or "http://localhost:8081"
-	baseURL := config.ServerAddr
-	// Remove gRPC scheme prefixes
-	baseURL = strings.TrimPrefix(baseURL, "dns:")
-	// Add http:// if no scheme present
-	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
-		baseURL = "http://" + baseURL
+	s, err := New(*config)
+	if err != nil {
+		return nil, err
 	}
+	s.loadConfig = LoadConfig
+	return s, nil
+}
 
-	client := NewMemosClient(baseURL)
+// New creates a service with cfg, to which the defaults are applied. Unlike
+// NewService, it reads neither the environment nor a config file, and it
+// doesn't watch the configuration or handle signals.
+func New(cfg Config, opts ...Option) (*Service, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	config := &cfg
+	if err := config.complete(); err != nil {
+		return nil, err
+	}
 
-	store := store.NewStore(config.Data)
-	if err := store.Init(); err != nil {
-		return nil, fmt.Errorf("failed to init store: %w", err)
+	logger := o.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	httpClient := o.httpClient
+	var client *MemosClient
+	if httpClient != nil {
+		options, err := memosClientOptions(config.MemosClient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Memos client: %w", err)
+		}
+		client = NewMemosClientWithHTTPClient(normalizeInstanceURL(config.ServerAddr), httpClient, options...)
+	} else {
+		// Connect using the Connect protocol unless gRPC or gRPC-Web is
+		// configured.
+		var err error
+		client, err = newConfiguredMemosClient(config.ServerAddr, config.MemosClient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Memos client: %w", err)
+		}
+		httpClient = http.DefaultClient
 	}
 
-	allowedUsernames := parseAllowedUsernames(config.AllowedUsernames)
-	s := &Service{
-		config:           config,
-		client:           client,
-		store:            store,
-		httpClient:       http.DefaultClient,
-		allowedUsernames: allowedUsernames,
+	st := o.store
+	if st == nil {
+		fileStore := store.NewStore(config.Data)
+		if err := fileStore.Init(); err != nil {
+			return nil, fmt.Errorf("failed to init store: %w", err)
+		}
+		st = fileStore
 	}
 
-	opts := []bot.Option{
+	live, err := newLiveSettings(config)
+	if err != nil {
+		return nil, err
+	}
+	audit, err := newAuditLog(config.AuditLog, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	s := &Service{
+		config:     config,
+		client:     client,
+		store:      st,
+		httpClient: httpClient,
+		logger:     logger,
+		commands:   o.commands,
+		audit:      audit,
+		dispatcher: newDispatcher(config.Concurrency, config.Limits.QueueLength),
+	}
+	s.live.Store(live)
+
+	botOpts := []bot.Option{
 		bot.WithDefaultHandler(s.handler),
+		// Handlers are matched in order, so the catch-all memo handler is last.
+		bot.WithCallbackQueryDataHandler(callbackAccessPrefix, bot.MatchTypePrefix, s.accessCallbackHandler),
+		bot.WithCallbackQueryDataHandler(callbackListPrefix, bot.MatchTypePrefix, s.listCallbackHandler),
+		bot.WithCallbackQueryDataHandler(callbackTaskPrefix, bot.MatchTypePrefix, s.taskCallbackHandler),
+		bot.WithCallbackQueryDataHandler(callbackRelatedPrefix, bot.MatchTypePrefix, s.relatedCallbackHandler),
 		bot.WithCallbackQueryDataHandler("", bot.MatchTypePrefix, s.callbackQueryHandler),
+		// Updates are handed to the dispatcher in arrival order, which then
+		// runs them on per-user workers.
+		bot.WithNotAsyncHandlers(),
+		bot.WithMiddlewares(s.dispatcher.Middleware),
+		// Reactions are only delivered when asked for explicitly.
+		bot.WithAllowedUpdates(bot.AllowedUpdates{
+			models.AllowedUpdateMessage,
+			models.AllowedUpdateCallbackQuery,
+			models.AllowedUpdateMessageReaction,
+		}),
 	}
 	if config.BotProxyAddr != "" {
-		opts = append(opts, bot.WithServerURL(config.BotProxyAddr))
+		botOpts = append(botOpts, bot.WithServerURL(config.BotProxyAddr))
 	}
+	botOpts = append(botOpts, o.botOptions...)
 
-	b, err := bot.New(config.BotToken, opts...)
+	b, err := bot.New(config.BotToken, botOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create bot: %w", err)
 	}
 	s.bot = b
+	s.sender = newSender(b)
+	s.sender.logger = logger
 
 	return s, nil
 }
 
 func (s *Service) Start(ctx context.Context) {
-	slog.Info("Memogram started")
-	// Try to get instance profile.
-	resp, err := s.client.InstanceService.GetInstanceProfile(ctx, connect.NewRequest(&v1pb.GetInstanceProfileRequest{}))
-	if err != nil {
-		slog.Warn("failed to get instance profile", slog.Any("err", err))
-	} else {
-		instanceProfile := resp.Msg
-		slog.Info("instance profile", slog.Any("profile", instanceProfile))
-		s.instanceProfile = instanceProfile
-	}
+	s.logger.Info("Memogram started")
+	go s.maintainInstanceProfile(ctx)
 
 	// set bot commands
 	commands := []models.BotCommand{
@@ -108,33 +218,122 @@ func (s *Service) Start(ctx context.Context) {
 			Command:     "start",
 			Description: "Start the bot with access token",
 		},
+		{
+			Command:     "login",
+			Description: "Sign in with your Memos username and password",
+		},
 		{
 			Command:     "search",
 			Description: "Search for the memos",
 		},
+		{
+			Command:     "recent",
+			Description: "List your recent memos",
+		},
+		{
+			Command:     "pinned",
+			Description: "List your pinned memos",
+		},
+		{
+			Command:     "archived",
+			Description: "List your archived memos",
+		},
+		{
+			Command:     "todo",
+			Description: "Create a checklist, one task per line",
+		},
+		{
+			Command:     "link",
+			Description: "Reply to a saved memo to link it to another memo",
+		},
+		{
+			Command:     "shortcuts",
+			Description: "Run your Memos shortcuts",
+		},
+		{
+			Command:     "accounts",
+			Description: "List your Memos accounts",
+		},
+		{
+			Command:     "switch",
+			Description: "Switch the active Memos account",
+		},
+		{
+			Command:     "logout",
+			Description: "Remove a Memos account from the bot",
+		},
+	}
+	for _, command := range s.commands {
+		commands = append(commands, models.BotCommand{
+			Command:     strings.TrimPrefix(command.command, "/"),
+			Description: command.description,
+		})
 	}
-	_, err = s.bot.SetMyCommands(ctx, &bot.SetMyCommandsParams{Commands: commands})
+	_, err := s.sender.SetMyCommands(ctx, &bot.SetMyCommandsParams{Commands: commands})
 	if err != nil {
-		slog.Error("failed to set bot commands", slog.Any("err", err))
+		s.logger.Error("failed to set bot commands", slog.Any("err", err))
 	}
 
+	if s.loadConfig != nil {
+		go s.watchConfig(ctx)
+	}
+	go s.runSchedules(ctx)
+	s.dispatcher.Start(ctx)
 	s.bot.Start(ctx)
 }
 
-func (s *Service) createMemo(ctx context.Context, client *MemosClient, content string) (*v1pb.Memo, error) {
+// QueueLength returns the number of updates waiting to be processed.
+func (s *Service) QueueLength() int64 {
+	return s.dispatcher.QueueLength()
+}
+
+func (s *Service) createMemo(ctx context.Context, client *MemosClient, content string, visibility v1pb.Visibility) (*v1pb.Memo, error) {
 	resp, err := client.MemoService.CreateMemo(ctx, connect.NewRequest(&v1pb.CreateMemoRequest{
 		Memo: &v1pb.Memo{
-			Content: content,
+			Content:    content,
+			Visibility: visibility,
 		},
 	}))
 	if err != nil {
-		slog.Error("failed to create memo", slog.Any("err", err))
+		s.logger.Error("failed to create memo", slog.Any("err", err))
 		return nil, fmt.Errorf("create memo: %w", err)
 	}
 	return resp.Msg, nil
 }
 
-func (s *Service) handleMemoCreation(ctx context.Context, client *MemosClient, m *models.Update, content string) (*v1pb.Memo, error) {
+// pinMemo pins a new memo for the named rule. The memo was saved either way,
+// so errors are only logged.
+func (s *Service) pinMemo(ctx context.Context, client *MemosClient, m *models.Update, account string, memo *v1pb.Memo, rule string) {
+	_, err := client.MemoService.UpdateMemo(ctx, connect.NewRequest(&v1pb.UpdateMemoRequest{
+		Memo:       &v1pb.Memo{Name: memo.Name, Pinned: true},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"pinned"}},
+	}))
+	result, details := auditResult(err)
+	s.audit.Record(auditEvent{
+		Action:  auditActionMemoUpdate,
+		UserID:  m.Message.From.ID,
+		ChatID:  m.Message.Chat.ID,
+		Account: account,
+		Memo:    memo.Name,
+		Result:  result,
+		Details: joinDetails(fmt.Sprintf("pin (rule %s)", rule), details),
+	})
+	if err != nil {
+		s.logger.Error("failed to pin memo", slog.String("memo", memo.Name), slog.Any("err", err))
+		return
+	}
+	// Later parts of an album share the memo, which is now pinned.
+	memo.Pinned = true
+}
+
+// mediaGroupMemo is the memo created for an album and the account it was
+// created with.
+type mediaGroupMemo struct {
+	memo    *v1pb.Memo
+	account string
+}
+
+func (s *Service) handleMemoCreation(ctx context.Context, client *MemosClient, account string, m *models.Update, content string, visibility v1pb.Visibility) (*v1pb.Memo, error) {
 	var memo *v1pb.Memo
 	var err error
 
@@ -143,16 +342,18 @@ func (s *Service) handleMemoCreation(ctx context.Context, client *MemosClient, m
 		defer s.mediaGroupMutex.Unlock()
 
 		if cache, ok := s.mediaGroupCache.Load(m.Message.MediaGroupID); ok {
-			return cache.(*v1pb.Memo), nil
+			return cache.(*mediaGroupMemo).memo, nil
 		}
 
-		memo, err = s.createMemo(ctx, client, content)
+		memo, err = s.createMemo(ctx, client, content, visibility)
+		s.auditMemoCreation(m, account, memo, err)
 		if err != nil {
 			return nil, err
 		}
-		s.mediaGroupCache.Store(m.Message.MediaGroupID, memo)
+		s.mediaGroupCache.Store(m.Message.MediaGroupID, &mediaGroupMemo{memo: memo, account: account})
 	} else {
-		memo, err = s.createMemo(ctx, client, content)
+		memo, err = s.createMemo(ctx, client, content, visibility)
+		s.auditMemoCreation(m, account, memo, err)
 		if err != nil {
 			return nil, err
 		}
@@ -167,83 +368,161 @@ func (s *Service) handler(ctx context.Context, b *bot.Bot, m *models.Update) {
 		fmt.Println("Service or config is nil")
 		return
 	}
+	if m != nil && m.MessageReaction != nil {
+		s.reactionHandler(ctx, b, m)
+		return
+	}
 	if m == nil || m.Message == nil || m.Message.From == nil {
-		s.sendError(b, 0, errors.New("invalid message structure: missing required fields"))
+		s.sendError(0, errors.New("invalid message structure: missing required fields"))
 		return
 	}
 	if m.Message.Chat.ID == 0 {
-		s.sendError(b, 0, errors.New("invalid chat: missing chat ID"))
+		s.sendError(0, errors.New("invalid chat: missing chat ID"))
+		return
+	}
+
+	// Unknown users may ask the admins for access.
+	if isCommand(m.Message.Text, commandRequest) {
+		s.requestHandler(ctx, b, m)
 		return
 	}
 
 	username := m.Message.From.Username
-	if !s.isUserAllowed(username) {
+	if !s.isAllowed(m.Message.From.ID, username, m.Message.Chat.ID) {
+		s.audit.Record(auditEvent{
+			Action:  auditActionAccess,
+			UserID:  m.Message.From.ID,
+			ChatID:  m.Message.Chat.ID,
+			Result:  auditResultDenied,
+			Details: username,
+		})
 		if username == "" {
-			s.sendError(b, m.Message.Chat.ID, errors.New("your account must have a username to use this bot"))
+			s.sendError(m.Message.Chat.ID, fmt.Errorf("your account (ID %d) is not allowed to use this bot%s", m.Message.From.ID, s.requestAccessHint()))
 			return
 		}
-		s.sendError(b, m.Message.Chat.ID, fmt.Errorf("your account %s is not allowed to use this bot", username))
+		s.sendError(m.Message.Chat.ID, fmt.Errorf("your account %s (ID %d) is not allowed to use this bot%s", username, m.Message.From.ID, s.requestAccessHint()))
 		return
 	}
 
 	message := m.Message
-	if strings.HasPrefix(message.Text, commandStart+" ") || message.Text == commandStart {
+	switch {
+	case isCommand(message.Text, commandStart):
 		s.startHandler(ctx, b, m)
 		return
-	} else if strings.HasPrefix(message.Text, commandSearch+" ") || message.Text == commandSearch {
+	case isCommand(message.Text, commandLogin):
+		s.loginHandler(ctx, b, m)
+		return
+	case isCommand(message.Text, commandSearch):
 		s.searchHandler(ctx, b, m)
 		return
-	}
-
-	userID := message.From.ID
-	if _, ok := s.store.GetUserAccessToken(userID); !ok {
-		b.SendMessage(ctx, &bot.SendMessageParams{
-			ChatID: message.Chat.ID,
-			Text:   "Please start the bot with /start <access_token>",
-		})
+	case isCommand(message.Text, commandRecent), isCommand(message.Text, commandPinned), isCommand(message.Text, commandArchived):
+		s.listHandler(ctx, b, m)
+		return
+	case isCommand(message.Text, commandShortcuts):
+		s.shortcutsHandler(ctx, b, m)
+		return
+	case isCommand(message.Text, commandTodo):
+		s.todoHandler(ctx, b, m)
+		return
+	case isCommand(message.Text, commandLink):
+		s.linkHandler(ctx, b, m)
+		return
+	case isCommand(message.Text, commandAccounts):
+		s.accountsHandler(ctx, b, m)
+		return
+	case isCommand(message.Text, commandSwitch):
+		s.switchHandler(ctx, b, m)
+		return
+	case isCommand(message.Text, commandLogout):
+		s.logoutHandler(ctx, b, m)
+		return
+	case isCommand(message.Text, commandAllow):
+		s.allowHandler(ctx, b, m)
+		return
+	case isCommand(message.Text, commandDeny):
+		s.denyHandler(ctx, b, m)
+		return
+	case isCommand(message.Text, commandUsers):
+		s.usersHandler(ctx, b, m)
 		return
 	}
+	for _, command := range s.commands {
+		if isCommand(message.Text, command.command) {
+			command.handler(ctx, b, m)
+			return
+		}
+	}
 
+	userID := message.From.ID
 	content := message.Text
 	contentEntities := message.Entities
 	if message.Caption != "" {
 		content = message.Caption
 		contentEntities = message.CaptionEntities
 	}
+
+	// A leading `!name` uses another account for this message only. Later
+	// parts of an album follow the account of the first one.
+	var account string
+	if name, prefixLength := splitAccountPrefix(content); name != "" {
+		if _, ok := s.store.GetUserAccountCredential(userID, name); ok {
+			account = name
+			content = trimUTF16Prefix(content, prefixLength)
+			contentEntities = shiftEntities(contentEntities, prefixLength)
+		}
+	}
+	settings := s.settings()
+	// Later parts of an album are added to the memo of the first one, which
+	// the rules already applied to.
+	var group *mediaGroupMemo
+	if message.MediaGroupID != "" {
+		if cache, ok := s.mediaGroupCache.Load(message.MediaGroupID); ok {
+			group = cache.(*mediaGroupMemo)
+		}
+	}
+	var rules ruleResult
+	if group == nil {
+		rules = applyRules(settings.rules, ruleInputFromMessage(message, content, contentEntities))
+	}
+	if rules.droppedBy != "" {
+		s.logger.Debug("message dropped by rule", slog.String("rule", rules.droppedBy))
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: message.Chat.ID,
+			Text:   fmt.Sprintf("Not saved: the message was dropped by rule %q", rules.droppedBy),
+		})
+		return
+	}
+	route := settings.routes[message.Chat.ID]
+	for _, name := range []string{rules.account, route.Account} {
+		if account != "" || name == "" {
+			continue
+		}
+		if _, ok := s.store.GetUserAccountCredential(userID, name); ok {
+			account = name
+		}
+	}
+	if group != nil {
+		account = group.account
+	}
+
+	authClient, credential, ok := s.accountClient(userID, account)
+	if !ok {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: message.Chat.ID,
+			Text:   "Please start the bot with /start <access_token>",
+		})
+		return
+	}
+
 	if len(contentEntities) > 0 {
 		content = formatContent(content, contentEntities)
 	}
 
+	content = rules.stripContent(content)
+
 	// Add "forwarded from: originName" if message was forwarded
 	if message.ForwardOrigin != nil {
-		var originName, originUsername string
-		// Determine the type of origin
-		switch origin := message.ForwardOrigin; {
-		case origin.MessageOriginUser != nil: // User
-			user := origin.MessageOriginUser.SenderUser
-			if user.LastName != "" {
-				originName = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
-			} else {
-				originName = user.FirstName
-			}
-			originUsername = user.Username
-		case origin.MessageOriginHiddenUser != nil: // Hidden User
-			hiddenUserName := origin.MessageOriginHiddenUser.SenderUserName
-			if hiddenUserName != "" {
-				originName = hiddenUserName
-			} else {
-				originName = "Hidden User"
-			}
-		case origin.MessageOriginChat != nil: // Chat
-			chat := origin.MessageOriginChat.SenderChat
-			originName = chat.Title
-			originUsername = chat.Username
-		case origin.MessageOriginChannel != nil: // Channel
-			channel := origin.MessageOriginChannel.Chat
-			originName = channel.Title
-			originUsername = channel.Username
-		}
-
+		originName, originUsername, _ := forwardOrigin(message.ForwardOrigin)
 		if originUsername != "" {
 			content = fmt.Sprintf("Forwarded from [%s](https://t.me/%s)\n%s", originName, originUsername, content)
 		} else {
@@ -253,148 +532,327 @@ func (s *Service) handler(ctx context.Context, b *bot.Bot, m *models.Update) {
 
 	hasAttachment := message.Document != nil || len(message.Photo) > 0 || message.Voice != nil || message.Video != nil
 	if content == "" && !hasAttachment {
-		b.SendMessage(ctx, &bot.SendMessageParams{
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: message.Chat.ID,
 			Text:   "Please input memo content",
 		})
 		return
 	}
 
-	accessToken, _ := s.store.GetUserAccessToken(userID)
-	authClient := s.client.NewAuthenticatedClient(accessToken)
+	if limit := settings.limits.MaxContentLength; limit > 0 && utf8.RuneCountInString(content) > limit {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: message.Chat.ID,
+			Text:   fmt.Sprintf("Memo content is longer than %d characters", limit),
+		})
+		return
+	}
+	content = appendTags(content, mergeTags(route.Tags, rules.tags))
+	// Routes are validated when the config is loaded.
+	visibility, _ := parseVisibility(route.Visibility)
+	if rules.visibility != v1pb.Visibility_VISIBILITY_UNSPECIFIED {
+		visibility = rules.visibility
+	}
 
 	var memo *v1pb.Memo
-	memo, err := s.handleMemoCreation(ctx, authClient, m, content)
+	memo, err := s.handleMemoCreation(ctx, authClient, credential.Account, m, content, visibility)
 	if err != nil {
-		b.SendMessage(ctx, &bot.SendMessageParams{
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: message.Chat.ID,
 			Text:   "Failed to create memo",
 		})
 		return
 	}
+	if rules.pinnedBy != "" && !memo.Pinned {
+		s.pinMemo(ctx, authClient, m, credential.Account, memo, rules.pinnedBy)
+	}
+	s.linkReferencedMemos(ctx, authClient, m, credential.Account, memo, content)
 
 	if message.Document != nil {
-		s.processFileMessage(ctx, authClient, b, m, message.Document.FileID, memo)
+		s.processFileMessage(ctx, authClient, m, message.Document.FileID, memo)
 	}
 	if message.Voice != nil {
-		s.processFileMessage(ctx, authClient, b, m, message.Voice.FileID, memo)
+		s.processFileMessage(ctx, authClient, m, message.Voice.FileID, memo)
 	}
 	if message.Video != nil {
-		s.processFileMessage(ctx, authClient, b, m, message.Video.FileID, memo)
+		s.processFileMessage(ctx, authClient, m, message.Video.FileID, memo)
 	}
 	if len(message.Photo) > 0 {
 		photo := message.Photo[len(message.Photo)-1]
-		s.processFileMessage(ctx, authClient, b, m, photo.FileID, memo)
+		s.processFileMessage(ctx, authClient, m, photo.FileID, memo)
 	}
 
 	memoUID, err := ExtractMemoUIDFromName(memo.Name)
 	if err != nil {
-		slog.Error("failed to extract memo UID", slog.Any("err", err))
-		b.SendMessage(ctx, &bot.SendMessageParams{
+		s.logger.Error("failed to extract memo UID", slog.Any("err", err))
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: message.Chat.ID,
 			Text:   "Failed to save memo",
 		})
 		return
 	}
 
-	baseURL := s.config.ServerAddr
-	if s.instanceProfile != nil && s.instanceProfile.InstanceUrl != "" {
-		baseURL = s.instanceProfile.InstanceUrl
-	}
-	b.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID:              message.Chat.ID,
-		Text:                fmt.Sprintf("Content saved as %s with [%s](%s/memos/%s)", v1pb.Visibility_name[int32(memo.Visibility)], memo.Name, baseURL, memoUID),
+	target := messageMemo{userID: userID, account: credential.Account, memo: memo.Name}
+	s.messageMemos.add(message.Chat.ID, message.ID, target)
+	baseURL := s.instanceURL(credential.Instance)
+	reply, err := s.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: message.Chat.ID,
+		Text: render(s.logger, settings.templates.saved, savedData{
+			Visibility: v1pb.Visibility_name[int32(memo.Visibility)],
+			Memo:       memo.Name,
+			URL:        fmt.Sprintf("%s/memos/%s", baseURL, memoUID),
+		}),
 		ParseMode:           models.ParseModeMarkdown,
 		DisableNotification: true,
 		ReplyParameters: &models.ReplyParameters{
 			MessageID: message.ID,
 		},
-		ReplyMarkup: s.keyboard(memo),
+		ReplyMarkup: s.keyboard(memo, credential.Account, s.tagSuggestions(ctx, authClient, credential, memo)),
 	})
+	if err == nil {
+		s.messageMemos.add(reply.Chat.ID, reply.ID, target)
+	}
 }
 
 func (s *Service) startHandler(ctx context.Context, b *bot.Bot, m *models.Update) {
 	userID := m.Message.From.ID
-	accessToken := strings.TrimSpace(strings.TrimPrefix(m.Message.Text, commandStart))
-	if accessToken == "" {
-		b.SendMessage(ctx, &bot.SendMessageParams{
+	args := strings.Fields(strings.TrimPrefix(m.Message.Text, commandStart))
+	if len(args) > 0 {
+		// The message contains an access token, so don't leave it in the chat
+		// history, whether the token turns out to be valid or not.
+		defer s.deleteSecretMessage(ctx, m.Message)
+	}
+	account := store.DefaultAccount
+	if len(args) > 0 && strings.HasPrefix(args[0], "!") {
+		account, args = strings.TrimPrefix(args[0], "!"), args[1:]
+		if !isValidAccountName(account) {
+			s.sender.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: m.Message.Chat.ID,
+				Text:   "Account names may only contain letters, digits, - and _ (at most 16 characters)",
+			})
+			return
+		}
+	}
+
+	var instance, accessToken string
+	switch len(args) {
+	case 1:
+		accessToken = args[0]
+	case 2:
+		instance, accessToken = normalizeInstanceURL(args[0]), args[1]
+	default:
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   "Usage: /start [!account] [instance_url] <access_token>",
+		})
+		return
+	}
+
+	if instance == s.client.baseURL {
+		instance = ""
+	}
+	if !s.isInstanceAllowed(instance) {
+		s.auditTokenSet(m, account, auditResultDenied, "instance not allowed: "+instance)
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: m.Message.Chat.ID,
-			Text:   "Usage: /start <access_token>",
+			Text:   fmt.Sprintf("Instance %s is not allowed", instance),
 		})
 		return
 	}
 
-	authClient := s.client.NewAuthenticatedClient(accessToken)
-	resp, err := authClient.AuthService.GetCurrentUser(ctx, connect.NewRequest(&v1pb.GetCurrentUserRequest{}))
+	user, err := s.currentUser(ctx, instance, accessToken)
 	if err != nil {
-		b.SendMessage(ctx, &bot.SendMessageParams{
+		s.invalidateToken(instance, accessToken)
+		s.auditTokenSet(m, account, auditResultFailure, "invalid access token")
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: m.Message.Chat.ID,
 			Text:   "Invalid access token",
 		})
 		return
 	}
-	user := resp.Msg.User
-	s.store.SetUserAccessToken(userID, accessToken)
-	b.SendMessage(ctx, &bot.SendMessageParams{
+	s.setUserCredential(userID, store.Credential{
+		Account:     account,
+		Instance:    instance,
+		AccessToken: accessToken,
+	})
+	s.auditTokenSet(m, account, auditResultSuccess, s.instanceURL(instance))
+	s.sender.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: m.Message.Chat.ID,
-		Text:   fmt.Sprintf("Hello %s!", user.DisplayName),
+		Text:   render(s.logger, s.settings().templates.welcome, welcomeData{Name: user.DisplayName, Account: account}),
 	})
 }
 
-func (s *Service) keyboard(memo *v1pb.Memo) *models.InlineKeyboardMarkup {
-	// add inline keyboard to edit memo's visibility or pinned status.
-	return &models.InlineKeyboardMarkup{
+// memosClient returns the client for instance, or the default client when
+// instance is empty.
+func (s *Service) memosClient(instance string) *MemosClient {
+	if instance == "" {
+		return s.client
+	}
+	if client, ok := s.instanceClients.Load(instance); ok {
+		return client.(*MemosClient)
+	}
+	client, _ := s.instanceClients.LoadOrStore(instance, s.client.withBaseURL(instance))
+	return client.(*MemosClient)
+}
+
+// userClient returns an authenticated client for the active account of the
+// user.
+func (s *Service) userClient(userID int64) (*MemosClient, store.Credential, bool) {
+	return s.accountClient(userID, "")
+}
+
+// accountClient returns an authenticated client for the named account of the
+// user, or for the active account when account is empty.
+func (s *Service) accountClient(userID int64, account string) (*MemosClient, store.Credential, bool) {
+	var credential store.Credential
+	var ok bool
+	if account == "" {
+		credential, ok = s.store.GetUserCredential(userID)
+	} else {
+		credential, ok = s.store.GetUserAccountCredential(userID, account)
+	}
+	if !ok {
+		return nil, store.Credential{}, false
+	}
+	return s.authClient(credential.Instance, credential.AccessToken), credential, true
+}
+
+// instanceURL returns the public URL used in links to memos of instance.
+func (s *Service) instanceURL(instance string) string {
+	if instance != "" {
+		return instance
+	}
+	if profile := s.instanceProfile.Load(); profile != nil && profile.InstanceUrl != "" {
+		return profile.InstanceUrl
+	}
+	return s.config.ServerAddr
+}
+
+// keyboard returns the inline keyboard to edit memo's visibility or pinned
+// status, with a row of suggested tags to append if there are any. The
+// account is part of the callback data, so that the buttons keep working
+// after the user switches accounts.
+func (s *Service) keyboard(memo *v1pb.Memo, account string, suggestedTags []string) *models.InlineKeyboardMarkup {
+	callbackData := func(action string) string {
+		if account == "" {
+			return fmt.Sprintf("%s %s", action, memo.Name)
+		}
+		return fmt.Sprintf("%s %s %s", action, memo.Name, account)
+	}
+	keyboard := &models.InlineKeyboardMarkup{
 		InlineKeyboard: [][]models.InlineKeyboardButton{
 			{
 				{
 					Text:         "Public",
-					CallbackData: fmt.Sprintf("public %s", memo.Name),
+					CallbackData: callbackData("public"),
 				},
 				{
 					Text:         "Private",
-					CallbackData: fmt.Sprintf("private %s", memo.Name),
+					CallbackData: callbackData("private"),
 				},
 				{
 					Text:         "Pin",
-					CallbackData: fmt.Sprintf("pin %s", memo.Name),
+					CallbackData: callbackData("pin"),
 				},
 			},
 		},
 	}
+
+	var tagButtons []models.InlineKeyboardButton
+	for _, tag := range suggestedTags {
+		data := callbackData(callbackTagPrefix + tag)
+		// Telegram rejects callback data longer than 64 bytes.
+		if len(data) > 64 {
+			continue
+		}
+		tagButtons = append(tagButtons, models.InlineKeyboardButton{Text: "#" + tag, CallbackData: data})
+	}
+	if len(tagButtons) > 0 {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tagButtons)
+	}
+	if uid, err := ExtractMemoUIDFromName(memo.Name); err == nil {
+		var buttons []models.InlineKeyboardButton
+		if tasks := parseTasks(memo.Content); len(tasks) > 0 {
+			buttons = append(buttons, models.InlineKeyboardButton{Text: fmt.Sprintf("Tasks (%d)", len(tasks)), CallbackData: taskCallbackData(uid, account, -1, "")})
+		}
+		buttons = append(buttons, models.InlineKeyboardButton{Text: "Related", CallbackData: callbackRelatedPrefix + uid + " " + account})
+		// Telegram rejects callback data longer than 64 bytes.
+		buttons = slices.DeleteFunc(buttons, func(button models.InlineKeyboardButton) bool {
+			return len(button.CallbackData) > 64
+		})
+		if len(buttons) > 0 {
+			keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, buttons)
+		}
+	}
+	return keyboard
+}
+
+// allowCallback reports whether the user who pressed a button may use the
+// bot, and answers the query if not.
+func (s *Service) allowCallback(ctx context.Context, query *models.CallbackQuery) bool {
+	var chatID int64
+	if query.Message.Message != nil {
+		chatID = query.Message.Message.Chat.ID
+	}
+	if s.isAllowed(query.From.ID, query.From.Username, chatID) {
+		return true
+	}
+	s.audit.Record(auditEvent{
+		Action:  auditActionAccess,
+		UserID:  query.From.ID,
+		ChatID:  chatID,
+		Result:  auditResultDenied,
+		Details: query.From.Username,
+	})
+	s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+		CallbackQueryID: query.ID,
+		Text:            "You are not allowed to use this bot",
+		ShowAlert:       true,
+	})
+	return false
 }
 
 func (s *Service) callbackQueryHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 	callbackData := update.CallbackQuery.Data
 	userID := update.CallbackQuery.From.ID
-	accessToken, ok := s.store.GetUserAccessToken(userID)
-	if !ok {
-		b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+	var chatID int64
+	if update.CallbackQuery.Message.Message != nil {
+		chatID = update.CallbackQuery.Message.Message.Chat.ID
+	}
+	if !s.allowCallback(ctx, update.CallbackQuery) {
+		return
+	}
+	parts := strings.Split(callbackData, " ")
+	if len(parts) != 2 && len(parts) != 3 {
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
 			CallbackQueryID: update.CallbackQuery.ID,
-			Text:            "Please start the bot with /start <access_token>",
+			Text:            "Invalid command",
 			ShowAlert:       true,
 		})
 		return
 	}
+	s.logger.Info("parts", slog.Any("parts", parts))
+	action, memoName := parts[0], parts[1]
+	// Buttons sent before accounts existed act on the active account.
+	var account string
+	if len(parts) == 3 {
+		account = parts[2]
+	}
 
-	authClient := s.client.NewAuthenticatedClient(accessToken)
-
-	parts := strings.Split(callbackData, " ")
-	if len(parts) != 2 {
-		b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+	authClient, credential, ok := s.accountClient(userID, account)
+	if !ok {
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
 			CallbackQueryID: update.CallbackQuery.ID,
-			Text:            "Invalid command",
+			Text:            "Please start the bot with /start <access_token>",
 			ShowAlert:       true,
 		})
 		return
 	}
-	slog.Info("parts", slog.Any("parts", parts))
-	action, memoName := parts[0], parts[1]
 
 	resp, err := authClient.MemoService.GetMemo(ctx, connect.NewRequest(&v1pb.GetMemoRequest{
 		Name: memoName,
 	}))
 	if err != nil {
-		b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
 			CallbackQueryID: update.CallbackQuery.ID,
 			Text:            fmt.Sprintf("Memo %s not found", memoName),
 			ShowAlert:       true,
@@ -403,6 +861,8 @@ func (s *Service) callbackQueryHandler(ctx context.Context, b *bot.Bot, update *
 	}
 
 	memo := resp.Msg
+	auditAction := auditActionMemoVisibility
+	updatePaths := []string{"visibility", "pinned"}
 
 	switch action {
 	case "public":
@@ -413,8 +873,17 @@ func (s *Service) callbackQueryHandler(ctx context.Context, b *bot.Bot, update *
 		memo.Visibility = v1pb.Visibility_PRIVATE
 	case "pin":
 		memo.Pinned = !memo.Pinned
+		auditAction = auditActionMemoUpdate
 	default:
-		b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+		if tag := strings.TrimPrefix(action, callbackTagPrefix); tag != action && isValidTag(tag) {
+			if !hasTag(memo, tag) {
+				memo.Content = appendTags(memo.Content, []string{tag})
+			}
+			auditAction = auditActionMemoUpdate
+			updatePaths = []string{"content"}
+			break
+		}
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
 			CallbackQueryID: update.CallbackQuery.ID,
 			Text:            "Unknown action",
 			ShowAlert:       true,
@@ -422,15 +891,28 @@ func (s *Service) callbackQueryHandler(ctx context.Context, b *bot.Bot, update *
 		return
 	}
 
-	_, e := authClient.MemoService.UpdateMemo(ctx, connect.NewRequest(&v1pb.UpdateMemoRequest{
+	updated, e := authClient.MemoService.UpdateMemo(ctx, connect.NewRequest(&v1pb.UpdateMemoRequest{
 		Memo: memo,
 		UpdateMask: &fieldmaskpb.FieldMask{
-			Paths: []string{"visibility", "pinned"},
+			Paths: updatePaths,
 		},
 	}))
+	if e == nil {
+		memo = updated.Msg
+	}
+	result, details := auditResult(e)
+	s.audit.Record(auditEvent{
+		Action:  auditAction,
+		UserID:  userID,
+		ChatID:  chatID,
+		Account: credential.Account,
+		Memo:    memo.Name,
+		Result:  result,
+		Details: joinDetails(action, details),
+	})
 	if e != nil {
-		slog.Error("failed to update memo", slog.Any("err", e))
-		b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+		s.logger.Error("failed to update memo", slog.Any("err", e))
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
 			CallbackQueryID: update.CallbackQuery.ID,
 			Text:            "Failed to update memo",
 			ShowAlert:       true,
@@ -446,26 +928,23 @@ func (s *Service) callbackQueryHandler(ctx context.Context, b *bot.Bot, update *
 
 	memoUID, err := ExtractMemoUIDFromName(memo.Name)
 	if err != nil {
-		slog.Error("failed to extract memo UID", slog.Any("err", err))
-		b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+		s.logger.Error("failed to extract memo UID", slog.Any("err", err))
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
 			CallbackQueryID: update.CallbackQuery.ID,
 			Text:            "Failed to update memo",
 		})
 		return
 	}
-	baseURL := s.config.ServerAddr
-	if s.instanceProfile != nil && s.instanceProfile.InstanceUrl != "" {
-		baseURL = s.instanceProfile.InstanceUrl
-	}
-	b.EditMessageText(ctx, &bot.EditMessageTextParams{
+	baseURL := s.instanceURL(credential.Instance)
+	s.sender.EditMessageText(ctx, &bot.EditMessageTextParams{
 		ChatID:      update.CallbackQuery.Message.Message.Chat.ID,
 		MessageID:   update.CallbackQuery.Message.Message.ID,
-		Text:        fmt.Sprintf("Memo updated as %s with [%s](%s/memos/%s) %s", v1pb.Visibility_name[int32(memo.Visibility)], memo.Name, baseURL, memoUID, pinnedMarker),
+		Text:        withReactions(fmt.Sprintf("Memo updated as %s with [%s](%s/memos/%s) %s", v1pb.Visibility_name[int32(memo.Visibility)], memo.Name, baseURL, memoUID, pinnedMarker), memo),
 		ParseMode:   models.ParseModeMarkdown,
-		ReplyMarkup: s.keyboard(memo),
+		ReplyMarkup: s.keyboard(memo, credential.Account, s.tagSuggestions(ctx, authClient, credential, memo)),
 	})
 
-	b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+	s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
 		CallbackQueryID: update.CallbackQuery.ID,
 		Text:            "Memo updated",
 	})
@@ -475,51 +954,56 @@ func (s *Service) searchHandler(ctx context.Context, b *bot.Bot, m *models.Updat
 	userID := m.Message.From.ID
 	searchString := strings.TrimSpace(strings.TrimPrefix(m.Message.Text, commandSearch))
 	if searchString == "" {
-		b.SendMessage(ctx, &bot.SendMessageParams{
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: m.Message.Chat.ID,
-			Text:   "Usage: /search <words>",
+			Text:   searchUsage,
 		})
 		return
 	}
-	accessToken, ok := s.store.GetUserAccessToken(userID)
+	authClient, credential, ok := s.userClient(userID)
 	if !ok {
-		b.SendMessage(ctx, &bot.SendMessageParams{
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: m.Message.Chat.ID,
 			Text:   "Please start the bot with /start <access_token>",
 		})
 		return
 	}
-	authClient := s.client.NewAuthenticatedClient(accessToken)
-	resp, err := authClient.AuthService.GetCurrentUser(ctx, connect.NewRequest(&v1pb.GetCurrentUserRequest{}))
+	user, err := s.currentUser(ctx, credential.Instance, credential.AccessToken)
 	if err != nil {
-		b.SendMessage(ctx, &bot.SendMessageParams{
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: m.Message.Chat.ID,
 			Text:   "Invalid access token",
 		})
 		return
 	}
-	user := resp.Msg.User
-	filter := buildMemoSearchFilter(searchString, user)
+	filter, err := buildMemoSearchFilter(searchString, user)
+	if err != nil {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   fmt.Sprintf("Invalid search: %s\n\n%s", err, searchUsage),
+		})
+		return
+	}
 	results, err := authClient.MemoService.ListMemos(ctx, connect.NewRequest(&v1pb.ListMemosRequest{
 		PageSize: 10,
 		Filter:   filter,
 	}))
 	if err != nil {
-		slog.Error("failed to search memos", slog.Any("err", err))
+		s.logger.Error("failed to search memos", slog.Any("err", err))
 		return
 	}
 
 	memos := results.Msg.GetMemos()
 
 	if len(memos) == 0 {
-		b.SendMessage(ctx, &bot.SendMessageParams{
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: m.Message.Chat.ID,
 			Text:   "No memos found for the specified search criteria.",
 		})
 	} else {
 		for _, memo := range results.Msg.GetMemos() {
-			tgMessage := memo.Name + "\n" + memo.Content
-			b.SendMessage(ctx, &bot.SendMessageParams{
+			tgMessage := withReactions(memo.Name+"\n"+memo.Content, memo)
+			s.sender.SendMessage(ctx, &bot.SendMessageParams{
 				ChatID: m.Message.Chat.ID,
 				Text:   tgMessage,
 			})
@@ -527,39 +1011,53 @@ func (s *Service) searchHandler(ctx context.Context, b *bot.Bot, m *models.Updat
 	}
 }
 
-func buildMemoSearchFilter(searchString string, user *v1pb.User) string {
-	filter := fmt.Sprintf("content.contains(%q)", searchString)
-	if user == nil {
-		return filter
-	}
-
-	creator := user.Name
-	if creator == "" && user.Username != "" {
-		creator = "users/" + user.Username
+// buildMemoSearchFilter compiles a /search query to a filter for the memos
+// of user.
+func buildMemoSearchFilter(searchString string, user *v1pb.User) (string, error) {
+	filter, err := compileSearchQuery(searchString)
+	if err != nil {
+		return "", err
 	}
+	creator := memoCreatorName(user)
 	if creator == "" {
-		return filter
+		return filter, nil
 	}
 
-	return fmt.Sprintf("%s && creator == %q", filter, creator)
+	return fmt.Sprintf("%s && creator == %q", filter, creator), nil
+}
+
+// memoCreatorName returns the resource name of user as the creator of memos,
+// or "" if it is unknown.
+func memoCreatorName(user *v1pb.User) string {
+	if user == nil {
+		return ""
+	}
+	if user.Name == "" && user.Username != "" {
+		return "users/" + user.Username
+	}
+	return user.Name
 }
 
-func (s *Service) processFileMessage(ctx context.Context, client *MemosClient, b *bot.Bot, m *models.Update, fileID string, memo *v1pb.Memo) {
-	file, err := b.GetFile(ctx, &bot.GetFileParams{FileID: fileID})
+func (s *Service) processFileMessage(ctx context.Context, client *MemosClient, m *models.Update, fileID string, memo *v1pb.Memo) {
+	file, err := s.sender.GetFile(ctx, &bot.GetFileParams{FileID: fileID})
 	if err != nil {
-		s.sendError(b, m.Message.Chat.ID, fmt.Errorf("failed to get file: %w", err))
+		s.sendError(m.Message.Chat.ID, fmt.Errorf("failed to get file: %w", err))
+		return
+	}
+	if limit := s.settings().limits.MaxAttachmentSize; limit > 0 && file.FileSize > limit {
+		s.sendError(m.Message.Chat.ID, fmt.Errorf("file is larger than the limit of %d bytes", limit))
 		return
 	}
 
 	_, err = s.saveAttachmentFromFile(ctx, client, file, memo)
 	if err != nil {
-		s.sendError(b, m.Message.Chat.ID, fmt.Errorf("failed to save attachment: %w", err))
+		s.sendError(m.Message.Chat.ID, fmt.Errorf("failed to save attachment: %w", err))
 		return
 	}
 }
 
 func (s *Service) saveAttachmentFromFile(ctx context.Context, client *MemosClient, file *models.File, memo *v1pb.Memo) (*v1pb.Attachment, error) {
-	fileLink := s.bot.FileDownloadLink(file)
+	fileLink := s.sender.FileDownloadLink(file)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileLink, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create download request: %w", err)
@@ -604,9 +1102,9 @@ func (s *Service) saveAttachmentFromFile(ctx context.Context, client *MemosClien
 	return resp.Msg, nil
 }
 
-func (s *Service) sendError(b *bot.Bot, chatID int64, err error) {
-	slog.Error("error", slog.Any("err", err))
-	b.SendMessage(context.Background(), &bot.SendMessageParams{
+func (s *Service) sendError(chatID int64, err error) {
+	s.logger.Error("error", slog.Any("err", err))
+	s.sender.SendMessage(context.Background(), &bot.SendMessageParams{
 		ChatID: chatID,
 		Text:   fmt.Sprintf("Error: %s", err.Error()),
 	})
@@ -624,14 +1122,32 @@ func parseAllowedUsernames(raw string) map[string]struct{} {
 	return allowed
 }
 
-func (s *Service) isUserAllowed(username string) bool {
-	if len(s.allowedUsernames) == 0 {
-		return true
+// isCommand reports whether text is command, possibly followed by arguments
+// on the same or the next lines.
+func isCommand(text, command string) bool {
+	return text == command || strings.HasPrefix(text, command+" ") || strings.HasPrefix(text, command+"\n")
+}
+
+func parseAllowedInstances(raw string) map[string]struct{} {
+	allowed := make(map[string]struct{})
+	for _, entry := range strings.Split(raw, ",") {
+		trimmed := strings.TrimSpace(entry)
+		if trimmed == "" {
+			continue
+		}
+		allowed[normalizeInstanceURL(trimmed)] = struct{}{}
 	}
-	if username == "" {
-		return false
+	return allowed
+}
+
+// isInstanceAllowed reports whether users may connect to instance. The
+// default server is always allowed.
+func (s *Service) isInstanceAllowed(instance string) bool {
+	allowedInstances := s.settings().allowedInstances
+	if instance == "" || len(allowedInstances) == 0 {
+		return true
 	}
-	_, ok := s.allowedUsernames[strings.ToLower(strings.TrimSpace(username))]
+	_, ok := allowedInstances[instance]
 	return ok
 }
 
@@ -678,7 +1194,8 @@ func isSupportedEntity(entityType models.MessageEntityType) bool {
 	case models.MessageEntityTypeURL,
 		models.MessageEntityTypeTextLink,
 		models.MessageEntityTypeBold,
-		models.MessageEntityTypeItalic:
+		models.MessageEntityTypeItalic,
+		models.MessageEntityTypeHashtag:
 		return true
 	default:
 		return false
@@ -704,7 +1221,10 @@ func applyEntityFormatting(segment string, entity models.MessageEntity) string {
 		return fmt.Sprintf("%s**%s**%s", prefix, core, suffix)
 	case models.MessageEntityTypeItalic:
 		return fmt.Sprintf("%s*%s*%s", prefix, core, suffix)
-	default:
-		return segment
+	case models.MessageEntityTypeHashtag:
+		if tag := normalizeHashtag(core); tag != "" {
+			return fmt.Sprintf("%s#%s%s", prefix, tag, suffix)
+		}
 	}
+	return segment
 }
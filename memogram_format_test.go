@@ -77,3 +77,39 @@ func TestFormatContent_OverlappingEntities(t *testing.T) {
 		t.Fatalf("unexpected content:\nwant: %q\ngot:  %q", want, got)
 	}
 }
+
+func TestFormatContent_Hashtags(t *testing.T) {
+	content := "Ideas #пример_1 #idea@channel"
+	entities := []models.MessageEntity{
+		{
+			Type:   models.MessageEntityTypeHashtag,
+			Offset: 6,
+			Length: 9,
+		},
+		{
+			Type:   models.MessageEntityTypeHashtag,
+			Offset: 16,
+			Length: 13,
+		},
+	}
+
+	got := formatContent(content, entities)
+	want := "Ideas #пример_1 #idea"
+	if got != want {
+		t.Fatalf("unexpected content:\nwant: %q\ngot:  %q", want, got)
+	}
+}
+
+func TestNormalizeHashtag(t *testing.T) {
+	for hashtag, want := range map[string]string{
+		"#go":           "go",
+		"#hello_world":  "hello_world",
+		"#नमस्ते":       "नमस्ते",
+		"#news@channel": "news",
+		"#a.b":          "a_b",
+	} {
+		if got := normalizeHashtag(hashtag); got != want {
+			t.Fatalf("normalizeHashtag(%q) = %q, want %q", hashtag, got, want)
+		}
+	}
+}
@@ -1,44 +1,85 @@
 package memogram
 
 import (
+	"strings"
 	"testing"
 
 	v1pb "github.com/usememos/memos/proto/gen/api/v1"
 )
 
 func TestBuildMemoSearchFilterUsesUsernameResourceName(t *testing.T) {
-	got := buildMemoSearchFilter("needle", &v1pb.User{
+	got, err := buildMemoSearchFilter("needle", &v1pb.User{
 		Name:     "users/alice",
 		Username: "alice",
 	})
 	want := `content.contains("needle") && creator == "users/alice"`
-	if got != want {
-		t.Fatalf("unexpected filter:\nwant: %q\ngot:  %q", want, got)
+	if err != nil || got != want {
+		t.Fatalf("unexpected filter:\nwant: %q\ngot:  %q (%v)", want, got, err)
 	}
 }
 
 func TestBuildMemoSearchFilterFallsBackToUsername(t *testing.T) {
-	got := buildMemoSearchFilter("needle", &v1pb.User{
+	got, err := buildMemoSearchFilter("needle", &v1pb.User{
 		Username: "alice",
 	})
 	want := `content.contains("needle") && creator == "users/alice"`
-	if got != want {
-		t.Fatalf("unexpected filter:\nwant: %q\ngot:  %q", want, got)
+	if err != nil || got != want {
+		t.Fatalf("unexpected filter:\nwant: %q\ngot:  %q (%v)", want, got, err)
 	}
 }
 
 func TestBuildMemoSearchFilterEscapesSearchString(t *testing.T) {
-	got := buildMemoSearchFilter(`quote " test`, &v1pb.User{Name: "users/alice"})
+	got, err := buildMemoSearchFilter(`"quote \" test"`, &v1pb.User{Name: "users/alice"})
 	want := `content.contains("quote \" test") && creator == "users/alice"`
-	if got != want {
-		t.Fatalf("unexpected filter:\nwant: %q\ngot:  %q", want, got)
+	if err != nil || got != want {
+		t.Fatalf("unexpected filter:\nwant: %q\ngot:  %q (%v)", want, got, err)
 	}
 }
 
 func TestBuildMemoSearchFilterAllowsUnknownUser(t *testing.T) {
-	got := buildMemoSearchFilter("needle", nil)
+	got, err := buildMemoSearchFilter("needle", nil)
 	want := `content.contains("needle")`
-	if got != want {
-		t.Fatalf("unexpected filter:\nwant: %q\ngot:  %q", want, got)
+	if err != nil || got != want {
+		t.Fatalf("unexpected filter:\nwant: %q\ngot:  %q (%v)", want, got, err)
+	}
+}
+
+func TestCompileSearchQuery(t *testing.T) {
+	for query, want := range map[string]string{
+		"buy milk":                           `content.contains("buy") && content.contains("milk")`,
+		`"buy milk" -bread`:                  `content.contains("buy milk") && !(content.contains("bread"))`,
+		"tag:work -tag:#done":                `tag in ["work"] && !(tag in ["done"])`,
+		"after:2025-01-01 before:2025-02-01": "created_ts >= 1735689600 && created_ts < 1738368000",
+		"visibility:public pinned:true":      `visibility == "PUBLIC" && pinned == true`,
+		"has:attachment has:link has:task":   "attachments.size() > 0 && has_link && has_task_list",
+		"tag:work OR tag:home todo":          `(tag in ["work"] || tag in ["home"]) && content.contains("todo")`,
+		"a OR b OR -c":                       `(content.contains("a") || content.contains("b") || !(content.contains("c")))`,
+		`"tag:work" or http://example.com`:   `content.contains("tag:work") && content.contains("or") && content.contains("http://example.com")`,
+	} {
+		got, err := compileSearchQuery(query)
+		if err != nil || got != want {
+			t.Fatalf("unexpected filter for %q:\nwant: %q\ngot:  %q (%v)", query, want, got, err)
+		}
+	}
+}
+
+func TestCompileSearchQueryRejectsBadSyntax(t *testing.T) {
+	for query, want := range map[string]string{
+		`"unterminated`:   "missing closing quote",
+		"OR milk":         "OR must be between two search terms",
+		"milk OR":         "OR must be between two search terms",
+		"a OR OR b":       "OR must be between two search terms",
+		`tag:"two words"`: "not a valid tag",
+		"tag:":            "tag: needs a value",
+		"after:yesterday": `after: "yesterday" is not a date`,
+		"visibility:team": "visibility:",
+		"pinned:maybe":    `pinned: "maybe" is not true or false`,
+		"has:code":        `has: "code" is not one of`,
+		`""`:              "empty phrase",
+		"   ":             "empty search",
+	} {
+		if _, err := compileSearchQuery(query); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %q for %q, got %v", want, query, err)
+		}
 	}
 }
@@ -0,0 +1,221 @@
+package memogram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/usememos/memogram/store"
+)
+
+const (
+	callbackAccessPrefix  = "access "
+	callbackAccessApprove = "approve"
+	callbackAccessDeny    = "deny"
+
+	// accessDenialCooldown is how long a denied user can't request access
+	// again.
+	accessDenialCooldown = 24 * time.Hour
+)
+
+// accessRequest is a pending request for access and the messages that were
+// sent to admins about it.
+type accessRequest struct {
+	userID        int64
+	adminMessages []*models.Message
+}
+
+// requestHandler forwards an access request of an unknown user to the admins.
+func (s *Service) requestHandler(ctx context.Context, b *bot.Bot, m *models.Update) {
+	user := m.Message.From
+	if s.isAllowed(user.ID, user.Username, m.Message.Chat.ID) {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   "You already have access to this bot",
+		})
+		return
+	}
+	settings := s.settings()
+	if len(settings.adminUserIDs) == 0 {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   "Access requests are not enabled for this bot",
+		})
+		return
+	}
+	if _, ok := s.accessRequests.Load(user.ID); ok {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   "Your access request is waiting for approval",
+		})
+		return
+	}
+	if until, ok := s.accessDenials.Load(user.ID); ok && time.Now().Before(until.(time.Time)) {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   "Your access request was denied, please try again later",
+		})
+		return
+	}
+
+	request := &accessRequest{userID: user.ID}
+	text := fmt.Sprintf("%s requests access to this bot.", describeUser(user))
+	keyboard := &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			{
+				{
+					Text:         "Approve",
+					CallbackData: fmt.Sprintf("%s%s %d", callbackAccessPrefix, callbackAccessApprove, user.ID),
+				},
+				{
+					Text:         "Deny",
+					CallbackData: fmt.Sprintf("%s%s %d", callbackAccessPrefix, callbackAccessDeny, user.ID),
+				},
+			},
+		},
+	}
+	for adminID := range settings.adminUserIDs {
+		message, err := s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:      adminID,
+			Text:        text,
+			ReplyMarkup: keyboard,
+		})
+		if err == nil {
+			request.adminMessages = append(request.adminMessages, message)
+		}
+	}
+	if len(request.adminMessages) == 0 {
+		s.sendError(m.Message.Chat.ID, fmt.Errorf("failed to reach the admins, please try again later"))
+		return
+	}
+	s.accessRequests.Store(user.ID, request)
+
+	s.audit.Record(auditEvent{
+		Action:  auditActionAccessRequest,
+		UserID:  user.ID,
+		ChatID:  m.Message.Chat.ID,
+		Result:  auditResultSuccess,
+		Details: user.Username,
+	})
+	s.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: m.Message.Chat.ID,
+		Text:   "Your access request was sent to the admins. You'll get a message once it's decided.",
+	})
+}
+
+// accessCallbackHandler handles the Approve and Deny buttons of access
+// requests.
+func (s *Service) accessCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	query := update.CallbackQuery
+	action, rawUserID, _ := strings.Cut(strings.TrimPrefix(query.Data, callbackAccessPrefix), " ")
+	userID, err := strconv.ParseInt(rawUserID, 10, 64)
+	auditAction := auditActionAccessDeny
+	if action == callbackAccessApprove {
+		auditAction = auditActionAccessApprove
+	}
+	if err != nil || (action != callbackAccessApprove && action != callbackAccessDeny) {
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            "Invalid command",
+			ShowAlert:       true,
+		})
+		return
+	}
+	if !s.isAdmin(query.From.ID) {
+		s.audit.Record(auditEvent{
+			Action:       auditAction,
+			UserID:       query.From.ID,
+			TargetUserID: userID,
+			Result:       auditResultDenied,
+		})
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            "Only admins can decide access requests",
+			ShowAlert:       true,
+		})
+		return
+	}
+
+	// The request may be gone after a restart; the decision still applies,
+	// but only the admin message that was tapped can be updated.
+	request := &accessRequest{userID: userID}
+	if pending, ok := s.accessRequests.LoadAndDelete(userID); ok {
+		request = pending.(*accessRequest)
+	} else if query.Message.Message != nil {
+		request.adminMessages = []*models.Message{query.Message.Message}
+	}
+
+	result := auditResultSuccess
+	decision := "approved"
+	userText := "Your access request was approved. Start the bot with /start <access_token> or /login <username> <password>."
+	if action == callbackAccessApprove {
+		entry, _ := store.NewAccessEntry(store.AccessUser, strconv.FormatInt(userID, 10))
+		if err := s.store.AddAccessEntry(entry); err != nil {
+			result = auditResultFailure
+			s.audit.Record(auditEvent{
+				Action:       auditAction,
+				UserID:       query.From.ID,
+				TargetUserID: userID,
+				Result:       result,
+				Details:      err.Error(),
+			})
+			s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+				CallbackQueryID: query.ID,
+				Text:            "Failed to save the allowlist",
+				ShowAlert:       true,
+			})
+			return
+		}
+		s.accessDenials.Delete(userID)
+	} else {
+		decision = "denied"
+		userText = "Your access request was denied."
+		s.accessDenials.Store(userID, time.Now().Add(accessDenialCooldown))
+	}
+
+	s.audit.Record(auditEvent{
+		Action:       auditAction,
+		UserID:       query.From.ID,
+		TargetUserID: userID,
+		Result:       result,
+	})
+
+	decidedBy := describeUser(&query.From)
+	for _, message := range request.adminMessages {
+		s.sender.EditMessageText(ctx, &bot.EditMessageTextParams{
+			ChatID:    message.Chat.ID,
+			MessageID: message.ID,
+			Text:      fmt.Sprintf("%s\n\n%s by %s.", message.Text, strings.ToUpper(decision[:1])+decision[1:], decidedBy),
+		})
+	}
+	s.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: userID,
+		Text:   userText,
+	})
+	s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+		CallbackQueryID: query.ID,
+		Text:            fmt.Sprintf("Request %s", decision),
+	})
+}
+
+// requestAccessHint tells denied users how to ask for access, if they can.
+func (s *Service) requestAccessHint() string {
+	if len(s.settings().adminUserIDs) == 0 {
+		return ""
+	}
+	return ", send /request to ask the admins for access"
+}
+
+// describeUser returns a human readable description of a Telegram user,
+// including the numeric ID admins need for the allowlist.
+func describeUser(user *models.User) string {
+	name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	if user.Username != "" {
+		return fmt.Sprintf("@%s (%s, ID %d)", user.Username, name, user.ID)
+	}
+	return fmt.Sprintf("%s (ID %d)", name, user.ID)
+}
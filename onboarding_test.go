@@ -0,0 +1,130 @@
+package memogram
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestAccessRequestApproval(t *testing.T) {
+	ctx := context.Background()
+	api, b := newFakeBotAPI(t)
+	api.enqueue("sendMessage", fakeSentMessage, fakeSentMessage, fakeSentMessage)
+	api.enqueue("editMessageText", fakeSentMessage)
+
+	s := newTestAccessService(t)
+	s.settings().allowedUserIDs[2] = struct{}{}
+	s.sender = newTestSender(b)
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := newAuditLog(auditPath, s.logger)
+	if err != nil {
+		t.Fatalf("open audit log: %v", err)
+	}
+	s.audit = audit
+
+	s.requestHandler(ctx, b, messageUpdate(42, commandRequest))
+	// One message to the admin and one confirmation to the requester.
+	if calls := api.callCount("sendMessage"); calls != 2 {
+		t.Fatalf("expected 2 sent messages, got %d", calls)
+	}
+	if _, ok := s.accessRequests.Load(int64(42)); !ok {
+		t.Fatal("expected a pending access request")
+	}
+
+	s.accessCallbackHandler(ctx, b, &models.Update{
+		CallbackQuery: &models.CallbackQuery{
+			ID:   "1",
+			From: models.User{ID: 1},
+			Data: "access approve 42",
+		},
+	})
+	if !s.isAllowed(42, "", 42) {
+		t.Fatal("expected user 42 to be allowed after approval")
+	}
+	if _, ok := s.accessRequests.Load(int64(42)); ok {
+		t.Fatal("expected the access request to be resolved")
+	}
+	if calls := api.callCount("editMessageText"); calls != 1 {
+		t.Fatalf("expected the admin message to be updated, got %d edits", calls)
+	}
+
+	data, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 audit events, got %q", data)
+	}
+	var event auditEvent
+	if err := json.Unmarshal([]byte(lines[1]), &event); err != nil {
+		t.Fatalf("decode audit event: %v", err)
+	}
+	if event.Action != "access.approve" || event.UserID != 1 || event.TargetUserID != 42 || event.Result != auditResultSuccess {
+		t.Fatalf("unexpected audit event: %+v", event)
+	}
+}
+
+func TestAccessCallbackRequiresAdmin(t *testing.T) {
+	ctx := context.Background()
+	api, b := newFakeBotAPI(t)
+
+	s := newTestAccessService(t)
+	s.settings().allowedUserIDs[2] = struct{}{}
+	s.sender = newTestSender(b)
+
+	s.accessCallbackHandler(ctx, b, &models.Update{
+		CallbackQuery: &models.CallbackQuery{
+			ID:   "1",
+			From: models.User{ID: 2},
+			Data: "access approve 42",
+		},
+	})
+	if s.isAllowed(42, "", 42) {
+		t.Fatal("expected non-admins to be unable to approve requests")
+	}
+	if calls := api.callCount("answerCallbackQuery"); calls != 1 {
+		t.Fatalf("expected the callback to be answered, got %d", calls)
+	}
+}
+
+func TestAccessRequestDenialCooldown(t *testing.T) {
+	ctx := context.Background()
+	api, b := newFakeBotAPI(t)
+	api.enqueue("sendMessage", fakeSentMessage, fakeSentMessage, fakeSentMessage, fakeSentMessage)
+
+	s := newTestAccessService(t)
+	s.settings().allowedUserIDs[2] = struct{}{}
+	s.sender = newTestSender(b)
+
+	s.requestHandler(ctx, b, messageUpdate(42, commandRequest))
+	s.accessCallbackHandler(ctx, b, &models.Update{
+		CallbackQuery: &models.CallbackQuery{
+			ID:   "1",
+			From: models.User{ID: 1},
+			Data: "access deny 42",
+		},
+	})
+	sent := api.callCount("sendMessage")
+
+	// The admins aren't asked again during the cooldown.
+	s.requestHandler(ctx, b, messageUpdate(42, commandRequest))
+	if calls := api.callCount("sendMessage"); calls != sent+1 {
+		t.Fatalf("expected only a reply to the requester, got %d new messages", calls-sent)
+	}
+	if _, ok := s.accessRequests.Load(int64(42)); ok {
+		t.Fatal("expected no pending access request during the cooldown")
+	}
+
+	s.accessDenials.Store(int64(42), time.Now().Add(-time.Second))
+	s.requestHandler(ctx, b, messageUpdate(42, commandRequest))
+	if _, ok := s.accessRequests.Load(int64(42)); !ok {
+		t.Fatal("expected a pending access request after the cooldown")
+	}
+}
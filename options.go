@@ -0,0 +1,93 @@
+package memogram
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/usememos/memogram/store"
+)
+
+// Store keeps the users' Memos accounts and the runtime allowlist.
+// *store.Store implements it with a data file.
+type Store interface {
+	GetUserCredential(userID int64) (store.Credential, bool)
+	GetUserAccountCredential(userID int64, account string) (store.Credential, bool)
+	ListUserCredentials(userID int64) ([]store.Credential, string)
+	ListUserIDs() []int64
+	SetUserCredential(userID int64, credential store.Credential)
+	SwitchUserAccount(userID int64, account string) error
+	RemoveUserCredential(userID int64, account string) (bool, error)
+
+	HasAccessEntry(entry store.AccessEntry) bool
+	ListAccessEntries() []store.AccessEntry
+	AddAccessEntry(entry store.AccessEntry) error
+	RemoveAccessEntry(entry store.AccessEntry) (bool, error)
+
+	// Reload reads the stored data again, after it was changed by another
+	// process.
+	Reload() error
+}
+
+// Option configures a Service created with New.
+type Option func(*options)
+
+type options struct {
+	httpClient *http.Client
+	store      Store
+	logger     *slog.Logger
+	botOptions []bot.Option
+	commands   []commandHandler
+}
+
+// commandHandler is a bot command added with WithCommandHandler.
+type commandHandler struct {
+	command     string
+	description string
+	handler     bot.HandlerFunc
+}
+
+// WithHTTPClient sends the requests to Memos and the downloads of Telegram
+// files with client. The memos_client settings of the config are then
+// ignored, except for the protocol.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = client
+	}
+}
+
+// WithStore keeps the users' accounts in st instead of the data file of the
+// config.
+func WithStore(st Store) Option {
+	return func(o *options) {
+		o.store = st
+	}
+}
+
+// WithLogger logs with logger instead of slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithBotOptions adds options to the bot, after Memogram's own.
+func WithBotOptions(botOptions ...bot.Option) Option {
+	return func(o *options) {
+		o.botOptions = append(o.botOptions, botOptions...)
+	}
+}
+
+// WithCommandHandler handles command, e.g. "/export", with handler. Like the
+// built-in commands, it is only available to allowed users and is listed in
+// the bot's menu with description.
+func WithCommandHandler(command, description string, handler bot.HandlerFunc) Option {
+	return func(o *options) {
+		o.commands = append(o.commands, commandHandler{
+			command:     "/" + strings.TrimPrefix(command, "/"),
+			description: description,
+			handler:     handler,
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package memogram
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/usememos/memogram/store"
+)
+
+// countingTransport counts the requests it sends.
+type countingTransport struct {
+	requests atomic.Int32
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestNewWithOptions(t *testing.T) {
+	memos, memosURL := newFakeMemos(t)
+	memos.addUser("token-1", 1, "ann")
+	api, _ := newFakeBotAPI(t)
+
+	dir := t.TempDir()
+	st := store.NewStore(filepath.Join(dir, "accounts.txt"))
+	if err := st.Init(); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	st.SetUserCredential(1, store.Credential{AccessToken: "token-1"})
+	st.SetUserCredential(2, store.Credential{AccessToken: "expired"})
+	transport := &countingTransport{}
+	var logs bytes.Buffer
+	var handled atomic.Bool
+
+	s, err := New(Config{
+		ServerAddr: memosURL,
+		BotToken:   "test-token",
+		Data:       filepath.Join(dir, "data.txt"),
+	},
+		WithHTTPClient(&http.Client{Transport: transport}),
+		WithStore(st),
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+		WithBotOptions(bot.WithServerURL(api.url), bot.WithSkipGetMe()),
+		WithCommandHandler("hello", "Say hello", func(ctx context.Context, b *bot.Bot, update *models.Update) {
+			handled.Store(true)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "data.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected the data file of the config to be unused, got %v", err)
+	}
+
+	ctx := context.Background()
+	s.handler(ctx, s.bot, messageUpdate(1, "/hello"))
+	if !handled.Load() {
+		t.Fatalf("expected the extra command to be handled")
+	}
+
+	s.handler(ctx, s.bot, messageUpdate(1, "Hello"))
+	if len(memos.allMemos()) != 1 {
+		t.Fatalf("expected a memo to be created")
+	}
+	if transport.requests.Load() == 0 {
+		t.Fatalf("expected the requests to Memos to use the HTTP client")
+	}
+
+	s.handler(ctx, s.bot, messageUpdate(2, "Hello"))
+	if !strings.Contains(logs.String(), "failed to create memo") {
+		t.Fatalf("expected the failure to be logged with the logger, got %q", logs.String())
+	}
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	if _, err := New(Config{ServerAddr: "http://localhost:5230"}); err == nil || !strings.Contains(err.Error(), "bot_token") {
+		t.Fatalf("expected the missing bot token to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package memogram
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"connectrpc.com/connect"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+)
+
+// maxMessageMemos is the number of messages whose memo is remembered for
+// reactions. Older messages are forgotten first.
+const maxMessageMemos = 10000
+
+type messageKey struct {
+	chatID    int64
+	messageID int
+}
+
+// messageMemo is the memo a message was saved as, or confirmed.
+type messageMemo struct {
+	userID  int64
+	account string
+	memo    string
+}
+
+// messageMemos maps captured messages and their confirmations to memos, so
+// that reactions to them can be applied to the memos. It only lives in
+// memory, so reactions to messages from before a restart are ignored.
+type messageMemos struct {
+	mutex sync.Mutex
+	memos map[messageKey]messageMemo
+	order []messageKey
+}
+
+func (m *messageMemos) add(chatID int64, messageID int, memo messageMemo) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.memos == nil {
+		m.memos = make(map[messageKey]messageMemo)
+	}
+	key := messageKey{chatID: chatID, messageID: messageID}
+	if _, ok := m.memos[key]; !ok {
+		m.order = append(m.order, key)
+	}
+	m.memos[key] = memo
+	for len(m.order) > maxMessageMemos {
+		delete(m.memos, m.order[0])
+		m.order = m.order[1:]
+	}
+}
+
+func (m *messageMemos) get(chatID int64, messageID int) (messageMemo, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	memo, ok := m.memos[messageKey{chatID: chatID, messageID: messageID}]
+	return memo, ok
+}
+
+// reactionEmojis returns the emojis of reactions. Custom and paid reactions
+// have no Memos counterpart and are skipped.
+func reactionEmojis(reactions []models.ReactionType) []string {
+	var emojis []string
+	for _, reaction := range reactions {
+		if reaction.ReactionTypeEmoji != nil && !slices.Contains(emojis, reaction.ReactionTypeEmoji.Emoji) {
+			emojis = append(emojis, reaction.ReactionTypeEmoji.Emoji)
+		}
+	}
+	return emojis
+}
+
+// reactionSummary counts the reactions of memo by emoji, like "⭐ 2  ✅ 1",
+// in the order they were first given.
+func reactionSummary(memo *v1pb.Memo) string {
+	var emojis []string
+	counts := make(map[string]int)
+	for _, reaction := range memo.GetReactions() {
+		if counts[reaction.ReactionType] == 0 {
+			emojis = append(emojis, reaction.ReactionType)
+		}
+		counts[reaction.ReactionType]++
+	}
+	parts := make([]string, 0, len(emojis))
+	for _, emoji := range emojis {
+		parts = append(parts, fmt.Sprintf("%s %d", emoji, counts[emoji]))
+	}
+	return strings.Join(parts, "  ")
+}
+
+// withReactions appends the reaction summary of memo to text, if it has any
+// reactions.
+func withReactions(text string, memo *v1pb.Memo) string {
+	if summary := reactionSummary(memo); summary != "" {
+		return text + "\n" + summary
+	}
+	return text
+}
+
+// reactionHandler mirrors the reactions of a user to their captured message
+// or its confirmation as Memos reactions to the memo.
+func (s *Service) reactionHandler(ctx context.Context, b *bot.Bot, m *models.Update) {
+	reaction := m.MessageReaction
+	// Anonymous reactions of chats can't be attributed to a user.
+	if reaction.User == nil {
+		return
+	}
+	target, ok := s.messageMemos.get(reaction.Chat.ID, reaction.MessageID)
+	if !ok || target.userID != reaction.User.ID {
+		return
+	}
+	if !s.isAllowed(reaction.User.ID, reaction.User.Username, reaction.Chat.ID) {
+		return
+	}
+	client, credential, ok := s.accountClient(target.userID, target.account)
+	if !ok {
+		return
+	}
+
+	before, after := reactionEmojis(reaction.OldReaction), reactionEmojis(reaction.NewReaction)
+	for _, emoji := range after {
+		if slices.Contains(before, emoji) {
+			continue
+		}
+		_, err := client.MemoService.UpsertMemoReaction(ctx, connect.NewRequest(&v1pb.UpsertMemoReactionRequest{
+			Name:     target.memo,
+			Reaction: &v1pb.Reaction{ContentId: target.memo, ReactionType: emoji},
+		}))
+		s.auditReaction(reaction, credential.Account, target.memo, "react "+emoji, err)
+		if err != nil {
+			s.logger.Error("failed to add memo reaction", slog.String("memo", target.memo), slog.Any("err", err))
+		}
+	}
+
+	var removed []string
+	for _, emoji := range before {
+		if !slices.Contains(after, emoji) {
+			removed = append(removed, emoji)
+		}
+	}
+	if len(removed) == 0 {
+		return
+	}
+	user, err := s.currentUser(ctx, credential.Instance, credential.AccessToken)
+	if err != nil {
+		s.logger.Error("failed to get current user", slog.Any("err", err))
+		return
+	}
+	resp, err := client.MemoService.ListMemoReactions(ctx, connect.NewRequest(&v1pb.ListMemoReactionsRequest{Name: target.memo}))
+	if err != nil {
+		s.logger.Error("failed to list memo reactions", slog.String("memo", target.memo), slog.Any("err", err))
+		return
+	}
+	for _, memoReaction := range resp.Msg.GetReactions() {
+		if memoReaction.Creator != user.Name || !slices.Contains(removed, memoReaction.ReactionType) {
+			continue
+		}
+		_, err := client.MemoService.DeleteMemoReaction(ctx, connect.NewRequest(&v1pb.DeleteMemoReactionRequest{Name: memoReaction.Name}))
+		s.auditReaction(reaction, credential.Account, target.memo, "unreact "+memoReaction.ReactionType, err)
+		if err != nil {
+			s.logger.Error("failed to delete memo reaction", slog.String("memo", target.memo), slog.Any("err", err))
+		}
+	}
+}
+
+func (s *Service) auditReaction(reaction *models.MessageReactionUpdated, account, memo, action string, err error) {
+	result, details := auditResult(err)
+	s.audit.Record(auditEvent{
+		Action:  auditActionMemoUpdate,
+		UserID:  reaction.User.ID,
+		ChatID:  reaction.Chat.ID,
+		Account: account,
+		Memo:    memo,
+		Result:  result,
+		Details: joinDetails(action, details),
+	})
+}
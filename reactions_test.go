@@ -0,0 +1,93 @@
+package memogram
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+)
+
+// react handles a change of the reactions of userID to the message with
+// messageID in the chat of user 1.
+func (e *e2e) react(userID int64, messageID int, before, after []string) {
+	emojis := func(emojis []string) []models.ReactionType {
+		var reactions []models.ReactionType
+		for _, emoji := range emojis {
+			reactions = append(reactions, models.ReactionType{
+				Type:              models.ReactionTypeTypeEmoji,
+				ReactionTypeEmoji: &models.ReactionTypeEmoji{Emoji: emoji},
+			})
+		}
+		return reactions
+	}
+	e.service.handler(context.Background(), e.bot, &models.Update{
+		MessageReaction: &models.MessageReactionUpdated{
+			Chat:        models.Chat{ID: 1},
+			MessageID:   messageID,
+			User:        &models.User{ID: userID},
+			OldReaction: emojis(before),
+			NewReaction: emojis(after),
+		},
+	})
+}
+
+func memoReactionTypes(memo *v1pb.Memo) []string {
+	var types []string
+	for _, reaction := range memo.Reactions {
+		types = append(types, reaction.ReactionType)
+	}
+	return types
+}
+
+func TestReactionSummary(t *testing.T) {
+	memo := &v1pb.Memo{Reactions: []*v1pb.Reaction{
+		{ReactionType: "⭐"}, {ReactionType: "✅"}, {ReactionType: "⭐"},
+	}}
+	if got := reactionSummary(memo); got != "⭐ 2  ✅ 1" {
+		t.Fatalf("unexpected summary %q", got)
+	}
+	if got := withReactions("memos/1", &v1pb.Memo{}); got != "memos/1" {
+		t.Fatalf("expected no summary without reactions, got %q", got)
+	}
+}
+
+func TestMessageMemosForgetsOldest(t *testing.T) {
+	var memos messageMemos
+	for i := range maxMessageMemos + 1 {
+		memos.add(1, i, messageMemo{memo: "memos/1"})
+	}
+	if _, ok := memos.get(1, 0); ok {
+		t.Fatal("expected the oldest message to be forgotten")
+	}
+	if _, ok := memos.get(1, maxMessageMemos); !ok {
+		t.Fatal("expected the newest message to be remembered")
+	}
+}
+
+func TestE2EReactions(t *testing.T) {
+	e := newE2E(t)
+	e.send(textMessage(1, "Hello"))
+
+	// Message 5 is the captured message and message 10 the confirmation.
+	e.react(1, 5, nil, []string{"⭐"})
+	e.react(1, 10, nil, []string{"✅"})
+	memo, _ := e.memos.memo("memos/1")
+	if got := strings.Join(memoReactionTypes(memo), " "); got != "⭐ ✅" {
+		t.Fatalf("expected both reactions, got %q", got)
+	}
+
+	e.react(2, 5, nil, []string{"👎"})
+	e.react(1, 6, nil, []string{"👎"})
+	e.react(1, 5, []string{"⭐"}, nil)
+	memo, _ = e.memos.memo("memos/1")
+	if got := strings.Join(memoReactionTypes(memo), " "); got != "✅" {
+		t.Fatalf("expected only the remaining reaction, got %q", got)
+	}
+
+	e.press(1, "pin memos/1")
+	if text := e.lastText(t, "editMessageText"); !strings.HasSuffix(text, "\n✅ 1") {
+		t.Fatalf("expected the reactions in the memo view, got %q", text)
+	}
+}
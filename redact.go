@@ -0,0 +1,114 @@
+package memogram
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// Logging policy: credentials are never written to the log. Attributes with a
+// sensitive key are dropped entirely, and anything that looks like a token is
+// scrubbed from messages, strings, errors and formatted values.
+var (
+	sensitiveLogKeys = []string{"token", "password", "secret", "authorization", "credential"}
+
+	secretPatterns = []*regexp.Regexp{
+		// Telegram bot tokens, also inside Bot API URLs like .../bot<token>/.
+		regexp.MustCompile(`\d{6,}:[A-Za-z0-9_-]{30,}`),
+		// JSON Web Tokens, as used for Memos access tokens.
+		regexp.MustCompile(`\beyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+		// Memos personal access tokens.
+		regexp.MustCompile(`\bmemos_pat_[A-Za-z0-9_-]+`),
+		// Authorization headers.
+		regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]+`),
+	}
+)
+
+// redactingHandler is a slog.Handler that removes credentials from records
+// before passing them on.
+type redactingHandler struct {
+	next slog.Handler
+}
+
+// NewRedactingHandler wraps next so that credentials never reach the log
+// output.
+func NewRedactingHandler(next slog.Handler) slog.Handler {
+	return &redactingHandler{next: next}
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redactedRecord := slog.NewRecord(record.Time, record.Level, redactSecrets(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redactedRecord.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return h.next.Handle(ctx, redactedRecord)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redactedAttrs := make([]slog.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		redactedAttrs = append(redactedAttrs, redactAttr(attr))
+	}
+	return &redactingHandler{next: h.next.WithAttrs(redactedAttrs)}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{next: h.next.WithGroup(name)}
+}
+
+func redactAttr(attr slog.Attr) slog.Attr {
+	if isSensitiveLogKey(attr.Key) {
+		return slog.String(attr.Key, redacted)
+	}
+
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, redactSecrets(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		redactedGroup := make([]any, 0, len(group))
+		for _, groupAttr := range group {
+			redactedGroup = append(redactedGroup, redactAttr(groupAttr))
+		}
+		return slog.Group(attr.Key, redactedGroup...)
+	case slog.KindAny:
+		if err, ok := value.Any().(error); ok {
+			return slog.String(attr.Key, redactSecrets(err.Error()))
+		}
+		// Only replace formatted values when they contain a secret, so that
+		// structured output stays structured otherwise.
+		formatted := fmt.Sprintf("%+v", value.Any())
+		if scrubbed := redactSecrets(formatted); scrubbed != formatted {
+			return slog.String(attr.Key, scrubbed)
+		}
+	}
+	return slog.Attr{Key: attr.Key, Value: value}
+}
+
+func isSensitiveLogKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveLogKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactSecrets replaces everything that looks like a credential in s.
+func redactSecrets(s string) string {
+	for _, pattern := range secretPatterns {
+		s = pattern.ReplaceAllString(s, redacted)
+	}
+	return s
+}
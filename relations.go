@@ -0,0 +1,259 @@
+package memogram
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+)
+
+const (
+	callbackRelatedPrefix = "rl "
+
+	// maxMemoReferences is the number of references in a message that are
+	// linked, since each of them is looked up in Memos.
+	maxMemoReferences = 10
+)
+
+// memoUIDPattern matches the UID of a memo.
+var memoUIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// memoReference matches a reference to another memo in a message, either
+// [[uid]], [[memos/uid]] or memos/uid. Links like https://…/memos/uid may
+// point to other instances and are left alone.
+var memoReference = regexp.MustCompile(`\[\[(?:memos/)?([A-Za-z0-9][A-Za-z0-9_-]*)\]\]|(?:^|[^A-Za-z0-9_/.-])memos/([A-Za-z0-9][A-Za-z0-9_-]*)`)
+
+// referencedMemos returns the names of the first maxMemoReferences memos
+// referenced in content, in order and without duplicates.
+func referencedMemos(content string) []string {
+	var names []string
+	for _, groups := range memoReference.FindAllStringSubmatch(content, -1) {
+		name := "memos/" + groups[1] + groups[2]
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+		if len(names) == maxMemoReferences {
+			break
+		}
+	}
+	return names
+}
+
+// parseMemoReference returns the name of the memo text refers to, which is
+// its name, UID, [[uid]] or URL.
+func parseMemoReference(text string) (string, bool) {
+	text = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(text), "[["), "]]")
+	if i := strings.LastIndex(text, "memos/"); i >= 0 {
+		text = text[i+len("memos/"):]
+	}
+	if !memoUIDPattern.MatchString(text) {
+		return "", false
+	}
+	return "memos/" + text, true
+}
+
+// linkMemos adds reference relations from memo to the related memos, keeping
+// its other relations. Memos only replaces the references of a memo at once.
+func linkMemos(ctx context.Context, client *MemosClient, memo string, related []string) error {
+	resp, err := client.MemoService.ListMemoRelations(ctx, connect.NewRequest(&v1pb.ListMemoRelationsRequest{Name: memo}))
+	if err != nil {
+		return fmt.Errorf("failed to list memo relations: %w", err)
+	}
+	var relations []*v1pb.MemoRelation
+	var linked []string
+	for _, relation := range resp.Msg.GetRelations() {
+		if relation.GetMemo().GetName() == memo && relation.Type == v1pb.MemoRelation_REFERENCE {
+			relations = append(relations, relation)
+			linked = append(linked, relation.GetRelatedMemo().GetName())
+		}
+	}
+	added := false
+	for _, name := range related {
+		if name == memo || slices.Contains(linked, name) {
+			continue
+		}
+		relations = append(relations, &v1pb.MemoRelation{
+			Memo:        &v1pb.MemoRelation_Memo{Name: memo},
+			RelatedMemo: &v1pb.MemoRelation_Memo{Name: name},
+			Type:        v1pb.MemoRelation_REFERENCE,
+		})
+		linked = append(linked, name)
+		added = true
+	}
+	if !added {
+		return nil
+	}
+	if _, err := client.MemoService.SetMemoRelations(ctx, connect.NewRequest(&v1pb.SetMemoRelationsRequest{Name: memo, Relations: relations})); err != nil {
+		return fmt.Errorf("failed to set memo relations: %w", err)
+	}
+	return nil
+}
+
+// linkReferencedMemos links a new memo created from m to the existing memos
+// its content references. References to unknown memos are ignored.
+func (s *Service) linkReferencedMemos(ctx context.Context, client *MemosClient, m *models.Update, account string, memo *v1pb.Memo, content string) {
+	var related []string
+	for _, name := range referencedMemos(content) {
+		if name == memo.Name {
+			continue
+		}
+		if _, err := client.MemoService.GetMemo(ctx, connect.NewRequest(&v1pb.GetMemoRequest{Name: name})); err != nil {
+			s.logger.Debug("skipping reference to unknown memo", slog.String("memo", name), slog.Any("err", err))
+			continue
+		}
+		related = append(related, name)
+	}
+	if len(related) == 0 {
+		return
+	}
+	err := linkMemos(ctx, client, memo.Name, related)
+	result, details := auditResult(err)
+	s.audit.Record(auditEvent{
+		Action:  auditActionMemoUpdate,
+		UserID:  m.Message.From.ID,
+		ChatID:  m.Message.Chat.ID,
+		Account: account,
+		Memo:    memo.Name,
+		Result:  result,
+		Details: joinDetails("link "+strings.Join(related, " "), details),
+	})
+	if err != nil {
+		s.logger.Error("failed to link referenced memos", slog.String("memo", memo.Name), slog.Any("err", err))
+	}
+}
+
+// linkHandler links the memo of the message /link replies to to another memo.
+func (s *Service) linkHandler(ctx context.Context, b *bot.Bot, m *models.Update) {
+	message := m.Message
+	related, ok := parseMemoReference(strings.TrimPrefix(message.Text, commandLink))
+	if !ok || message.ReplyToMessage == nil {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: message.Chat.ID,
+			Text:   "Usage: reply /link <memo> to a saved message or its confirmation, e.g. /link memos/abc",
+		})
+		return
+	}
+	target, ok := s.messageMemos.get(message.Chat.ID, message.ReplyToMessage.ID)
+	if !ok || target.userID != message.From.ID {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: message.Chat.ID,
+			Text:   "Reply to a message you saved recently, or to its confirmation, to link its memo",
+		})
+		return
+	}
+	if related == target.memo {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: message.Chat.ID,
+			Text:   "A memo can't be linked to itself",
+		})
+		return
+	}
+	client, credential, ok := s.accountClient(target.userID, target.account)
+	if !ok {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: message.Chat.ID,
+			Text:   "Please start the bot with /start <access_token>",
+		})
+		return
+	}
+	if _, err := client.MemoService.GetMemo(ctx, connect.NewRequest(&v1pb.GetMemoRequest{Name: related})); err != nil {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: message.Chat.ID,
+			Text:   fmt.Sprintf("Memo %s not found", related),
+		})
+		return
+	}
+
+	err := linkMemos(ctx, client, target.memo, []string{related})
+	result, details := auditResult(err)
+	s.audit.Record(auditEvent{
+		Action:  auditActionMemoUpdate,
+		UserID:  message.From.ID,
+		ChatID:  message.Chat.ID,
+		Account: credential.Account,
+		Memo:    target.memo,
+		Result:  result,
+		Details: joinDetails("link "+related, details),
+	})
+	if err != nil {
+		s.logger.Error("failed to link memos", slog.Any("err", err))
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: message.Chat.ID,
+			Text:   "Failed to link memos",
+		})
+		return
+	}
+	s.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: message.Chat.ID,
+		Text:   fmt.Sprintf("Linked %s to %s", target.memo, related),
+	})
+}
+
+// relatedView lists the memos memo refers to with → and the memos referring
+// to it with ←.
+func relatedView(memo string, relations []*v1pb.MemoRelation) string {
+	var sb strings.Builder
+	for _, relation := range relations {
+		arrow, other := "→", relation.GetRelatedMemo()
+		if other.GetName() == memo {
+			arrow, other = "←", relation.GetMemo()
+		}
+		fmt.Fprintf(&sb, "\n%s %s: %s", arrow, other.GetName(), memoSnippet(other.GetSnippet()))
+		if relation.Type == v1pb.MemoRelation_COMMENT {
+			sb.WriteString(" (comment)")
+		}
+	}
+	if sb.Len() == 0 {
+		return fmt.Sprintf("%s has no related memos.", memo)
+	}
+	return fmt.Sprintf("Related memos of %s:%s", memo, sb.String())
+}
+
+// relatedCallbackHandler lists the relations of a memo in a new message.
+func (s *Service) relatedCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	query := update.CallbackQuery
+	if !s.allowCallback(ctx, query) {
+		return
+	}
+	fields := strings.Fields(strings.TrimPrefix(query.Data, callbackRelatedPrefix))
+	if len(fields) != 2 || query.Message.Message == nil {
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            "Invalid command",
+			ShowAlert:       true,
+		})
+		return
+	}
+	client, _, ok := s.accountClient(query.From.ID, fields[1])
+	if !ok {
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            "Please start the bot with /start <access_token>",
+			ShowAlert:       true,
+		})
+		return
+	}
+	memo := "memos/" + fields[0]
+	resp, err := client.MemoService.ListMemoRelations(ctx, connect.NewRequest(&v1pb.ListMemoRelationsRequest{Name: memo}))
+	if err != nil {
+		s.logger.Error("failed to list memo relations", slog.String("memo", memo), slog.Any("err", err))
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            "Failed to list related memos",
+			ShowAlert:       true,
+		})
+		return
+	}
+	s.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: query.Message.Message.Chat.ID,
+		Text:   relatedView(memo, resp.Msg.GetRelations()),
+	})
+	s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: query.ID})
+}
@@ -0,0 +1,92 @@
+package memogram
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestReferencedMemos(t *testing.T) {
+	got := referencedMemos("memos/a1 see [[b2]], ([[memos/c-3]]) and memos/a1. Not https://x.com/memos/d4 or mymemos/e5")
+	if want := []string{"memos/a1", "memos/b2", "memos/c-3"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	content := strings.Repeat("[[a]] ", 3)
+	for i := range maxMemoReferences + 5 {
+		content += fmt.Sprintf("memos/m%d ", i)
+	}
+	got = referencedMemos(content)
+	if len(got) != maxMemoReferences || got[0] != "memos/a" || got[maxMemoReferences-1] != fmt.Sprintf("memos/m%d", maxMemoReferences-2) {
+		t.Fatalf("expected the first %d references, got %v", maxMemoReferences, got)
+	}
+}
+
+func TestParseMemoReference(t *testing.T) {
+	for text, want := range map[string]string{
+		" memos/abc":                      "memos/abc",
+		"abc":                             "memos/abc",
+		"[[abc]]":                         "memos/abc",
+		"https://memos.example/memos/abc": "memos/abc",
+		"":                                "",
+		"memos/a b":                       "",
+	} {
+		got, ok := parseMemoReference(text)
+		if got != want || ok != (want != "") {
+			t.Fatalf("%q: expected %q, got %q", text, want, got)
+		}
+	}
+}
+
+func TestE2ELinkMemos(t *testing.T) {
+	e := newE2E(t)
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	var err error
+	if e.service.audit, err = newAuditLog(auditPath, e.service.logger); err != nil {
+		t.Fatalf("open audit log: %v", err)
+	}
+	for i, text := range []string{"First", "Second, see [[1]] and memos/9", "Third"} {
+		message := textMessage(1, text)
+		message.ID = i + 1
+		e.send(message)
+	}
+	if text := e.lastText(t, "sendMessage"); !strings.Contains(text, "memos/3") {
+		t.Fatalf("expected the third memo to be saved, got %q", text)
+	}
+	if markup := e.api.sent("sendMessage")[0].Get("reply_markup"); !strings.Contains(markup, `"text":"Related","callback_data":"rl 1 default"`) {
+		t.Fatalf("expected a related button, got %s", markup)
+	}
+	audit, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	if !strings.Contains(string(audit), `"memo":"memos/2","result":"success","details":"link memos/1"`) {
+		t.Fatalf("expected the references to be audited, got:\n%s", audit)
+	}
+
+	e.send(textMessage(1, "/link memos/2"))
+	if text := e.lastText(t, "sendMessage"); !strings.HasPrefix(text, "Usage:") {
+		t.Fatalf("expected the usage without a reply, got %q", text)
+	}
+	link := textMessage(1, "/link memos/2")
+	link.ReplyToMessage = &models.Message{ID: 1}
+	e.send(link)
+	if text := e.lastText(t, "sendMessage"); text != "Linked memos/1 to memos/2" {
+		t.Fatalf("unexpected reply %q", text)
+	}
+
+	e.press(1, "rl 1 default")
+	want := "Related memos of memos/1:\n← memos/2: Second, see [[1]] and memos/9\n→ memos/2: Second, see [[1]] and memos/9"
+	if text := e.lastText(t, "sendMessage"); text != want {
+		t.Fatalf("expected %q, got %q", want, text)
+	}
+	e.press(1, "rl 3 default")
+	if text := e.lastText(t, "sendMessage"); text != "memos/3 has no related memos." {
+		t.Fatalf("unexpected view %q", text)
+	}
+}
@@ -0,0 +1,125 @@
+package memogram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// unsupportedTargetSchemes are gRPC resolver schemes whose targets Memogram
+// can't dial.
+var unsupportedTargetSchemes = []string{"unix", "unix-abstract", "vsock", "xds", "google-c2p"}
+
+// grpcTarget is a server address in the gRPC name syntax,
+// scheme:[//authority/]endpoint, with the dns or passthrough scheme.
+type grpcTarget struct {
+	scheme string
+	// authority is the DNS server of dns://authority/endpoint targets.
+	authority string
+	// endpoint is the host and port of the server.
+	endpoint string
+}
+
+// parseGRPCTarget parses addr as a gRPC target. It reports false for URLs
+// and plain host:port addresses, and an error for targets of schemes it
+// can't dial.
+func parseGRPCTarget(addr string) (grpcTarget, bool, error) {
+	scheme, rest, ok := strings.Cut(strings.TrimSpace(addr), ":")
+	if !ok {
+		return grpcTarget{}, false, nil
+	}
+	if slices.Contains(unsupportedTargetSchemes, scheme) {
+		return grpcTarget{}, false, fmt.Errorf("%s: targets are not supported, use a URL or a dns: or passthrough: target", scheme)
+	}
+	if scheme != "dns" && scheme != "passthrough" {
+		return grpcTarget{}, false, nil
+	}
+
+	target := grpcTarget{scheme: scheme, endpoint: rest}
+	if rest, ok := strings.CutPrefix(rest, "//"); ok {
+		target.authority, target.endpoint, _ = strings.Cut(rest, "/")
+	}
+	if target.endpoint == "" {
+		return grpcTarget{}, false, fmt.Errorf("%s target %q has no host", scheme, addr)
+	}
+	// passthrough targets ignore the authority, like in gRPC.
+	if scheme == "passthrough" {
+		target.authority = ""
+	}
+	if target.authority != "" {
+		if _, _, err := net.SplitHostPort(target.authority); err != nil {
+			target.authority = net.JoinHostPort(target.authority, "53")
+		}
+		if _, _, err := net.SplitHostPort(target.authority); err != nil {
+			return grpcTarget{}, false, fmt.Errorf("invalid DNS server %q: %w", target.authority, err)
+		}
+	}
+	return target, true, nil
+}
+
+// dialFunc is the signature of net.Dialer.DialContext.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// targetDialer resolves the host of a dns target itself, with the DNS server
+// of its authority if it has one, and connects to the resolved addresses in
+// turn, trying the next address when one fails. Connections to other hosts,
+// such as other instances or a proxy, are dialed as they are.
+type targetDialer struct {
+	host   string
+	lookup func(ctx context.Context, host string) ([]string, error)
+	dial   dialFunc
+	next   atomic.Uint32
+}
+
+// newTargetDialer returns the dialer of target, which connects with dial,
+// or nil if target needs no dialer of its own. Like in gRPC, passthrough
+// targets are handed to dial unchanged.
+func newTargetDialer(target grpcTarget, dial dialFunc) *targetDialer {
+	if target.scheme != "dns" {
+		return nil
+	}
+	resolver := net.DefaultResolver
+	if target.authority != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, target.authority)
+			},
+		}
+	}
+	host := target.endpoint
+	if u, err := url.Parse("http://" + target.endpoint); err == nil {
+		host = u.Hostname()
+	}
+	return &targetDialer{host: host, lookup: resolver.LookupHost, dial: dial}
+}
+
+func (d *targetDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != d.host {
+		return d.dial(ctx, network, addr)
+	}
+	hosts, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+	start := int(d.next.Add(1) - 1)
+	var errs []error
+	for i := range hosts {
+		conn, err := d.dial(ctx, network, net.JoinHostPort(hosts[(start+i)%len(hosts)], port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
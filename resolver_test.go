@@ -0,0 +1,111 @@
+package memogram
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGRPCTarget(t *testing.T) {
+	for addr, want := range map[string]grpcTarget{
+		"dns:localhost:5230":               {scheme: "dns", endpoint: "localhost:5230"},
+		"dns:///memos.io:5230":             {scheme: "dns", endpoint: "memos.io:5230"},
+		"dns://8.8.8.8/memos.io:5230":      {scheme: "dns", authority: "8.8.8.8:53", endpoint: "memos.io:5230"},
+		"dns://[::1]:5353/memos.io:5230":   {scheme: "dns", authority: "[::1]:5353", endpoint: "memos.io:5230"},
+		"passthrough://ignored/memos:5230": {scheme: "passthrough", endpoint: "memos:5230"},
+	} {
+		got, ok, err := parseGRPCTarget(addr)
+		if err != nil || !ok || got != want {
+			t.Fatalf("parseGRPCTarget(%q) = %+v, %t, %v, want %+v", addr, got, ok, err, want)
+		}
+	}
+	for _, addr := range []string{"localhost:5230", "https://memos.io", "memos.io"} {
+		if _, ok, err := parseGRPCTarget(addr); ok || err != nil {
+			t.Fatalf("expected %q not to be a target, got %t, %v", addr, ok, err)
+		}
+	}
+	for _, addr := range []string{"unix:///run/memos.sock", "xds:///memos", "dns:", "dns://8.8.8.8/"} {
+		if _, _, err := parseGRPCTarget(addr); err == nil {
+			t.Fatalf("expected %q to be rejected", addr)
+		}
+	}
+}
+
+func TestTargetDialerUsesAddressesInTurn(t *testing.T) {
+	var dialed []string
+	d := newTargetDialer(grpcTarget{scheme: "dns", endpoint: "memos.io:5230"}, func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		if strings.HasPrefix(addr, "10.0.0.2:") {
+			return nil, errors.New("connection refused")
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	})
+	d.lookup = func(ctx context.Context, host string) ([]string, error) {
+		if host != "memos.io" {
+			t.Fatalf("unexpected lookup of %s", host)
+		}
+		return []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, nil
+	}
+
+	for range 3 {
+		conn, err := d.DialContext(context.Background(), "tcp", "memos.io:5230")
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		conn.Close()
+	}
+	// The second connection fails over from 10.0.0.2 to 10.0.0.3.
+	want := []string{"10.0.0.1:5230", "10.0.0.2:5230", "10.0.0.3:5230", "10.0.0.3:5230"}
+	if !slices.Equal(dialed, want) {
+		t.Fatalf("expected %v, got %v", want, dialed)
+	}
+
+	// Other hosts, such as a proxy, are dialed as they are.
+	dialed = nil
+	if conn, err := d.DialContext(context.Background(), "tcp", "proxy:3128"); err == nil {
+		conn.Close()
+	}
+	if !slices.Equal(dialed, []string{"proxy:3128"}) {
+		t.Fatalf("expected the proxy to be dialed, got %v", dialed)
+	}
+}
+
+func TestTargetDialerAsksTheAuthority(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	queried := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 512)
+		if _, _, err := server.ReadFrom(buf); err == nil {
+			queried <- struct{}{}
+		}
+	}()
+
+	target, _, err := parseGRPCTarget("dns://" + server.LocalAddr().String() + "/memos.example:5230")
+	if err != nil {
+		t.Fatalf("parse target: %v", err)
+	}
+	d := newTargetDialer(target, func(ctx context.Context, network, addr string) (net.Conn, error) {
+		t.Fatalf("unexpected dial of %s", addr)
+		return nil, nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if _, err := d.DialContext(ctx, "tcp", "memos.example:5230"); err == nil {
+		t.Fatal("expected the lookup to fail")
+	}
+	select {
+	case <-queried:
+	case <-time.After(time.Second):
+		t.Fatal("expected the DNS server of the authority to be queried")
+	}
+}
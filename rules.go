@@ -0,0 +1,241 @@
+package memogram
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/go-telegram/bot/models"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+)
+
+// ruleMedia are the values of RuleMatch.Media.
+var ruleMedia = []string{"text", "photo", "document", "voice", "video"}
+
+func isValidRuleMedia(media string) bool {
+	return slices.Contains(ruleMedia, strings.ToLower(media))
+}
+
+// RuleInput is what rules know about a message.
+type RuleInput struct {
+	// Content is the text or caption of the message.
+	Content string
+	// ChatID is the chat the message was sent in.
+	ChatID int64
+	// ForwardName, ForwardUsername and ForwardChatID describe the origin of
+	// a forwarded message.
+	ForwardName     string
+	ForwardUsername string
+	ForwardChatID   int64
+	// Media is one of text, photo, document, voice or video.
+	Media string
+	// Hashtags are the hashtags of the message, without their #.
+	Hashtags []string
+}
+
+// rule is a compiled RuleConfig.
+type rule struct {
+	config     RuleConfig
+	content    *regexp.Regexp
+	strip      *regexp.Regexp
+	visibility v1pb.Visibility
+}
+
+// compileRules compiles the rules of the config, which were validated when it
+// was loaded. Unnamed rules are named by their position.
+func compileRules(configs []RuleConfig) ([]*rule, error) {
+	rules := make([]*rule, 0, len(configs))
+	for i, config := range configs {
+		if config.Name == "" {
+			config.Name = fmt.Sprintf("rules[%d]", i)
+		}
+		r := &rule{config: config}
+		var err error
+		if config.Match.Content != "" {
+			if r.content, err = regexp.Compile(config.Match.Content); err != nil {
+				return nil, fmt.Errorf("rule %q: %w", config.Name, err)
+			}
+		}
+		if config.Actions.Strip != "" {
+			if r.strip, err = regexp.Compile(config.Actions.Strip); err != nil {
+				return nil, fmt.Errorf("rule %q: %w", config.Name, err)
+			}
+		}
+		if config.Actions.Visibility != "" {
+			if r.visibility, err = parseVisibility(config.Actions.Visibility); err != nil {
+				return nil, fmt.Errorf("rule %q: %w", config.Name, err)
+			}
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// matches reports whether all conditions of the rule hold for input.
+func (r *rule) matches(input RuleInput) bool {
+	match := r.config.Match
+	if r.content != nil && !r.content.MatchString(input.Content) {
+		return false
+	}
+	if match.ForwardFrom != "" {
+		from := strings.TrimPrefix(match.ForwardFrom, "@")
+		if !strings.EqualFold(from, input.ForwardUsername) && !strings.EqualFold(match.ForwardFrom, input.ForwardName) {
+			return false
+		}
+	}
+	if match.ForwardChatID != 0 && match.ForwardChatID != input.ForwardChatID {
+		return false
+	}
+	if match.Media != "" && !strings.EqualFold(match.Media, input.Media) {
+		return false
+	}
+	if match.ChatID != 0 && match.ChatID != input.ChatID {
+		return false
+	}
+	if match.Hashtag != "" && !slices.ContainsFunc(input.Hashtags, func(hashtag string) bool {
+		return strings.EqualFold(hashtag, strings.TrimPrefix(match.Hashtag, "#"))
+	}) {
+		return false
+	}
+	return true
+}
+
+// ruleResult combines the actions of the rules that matched a message.
+type ruleResult struct {
+	// matched are the names of the matching rules, in order.
+	matched []string
+	// droppedBy is the name of the rule that dropped the message, if any.
+	droppedBy  string
+	tags       []string
+	visibility v1pb.Visibility
+	// pinnedBy is the name of the first rule that pins the memo, if any.
+	pinnedBy string
+	account  string
+	strip    []*regexp.Regexp
+}
+
+// applyRules runs rules in order. Later rules override the visibility and
+// account of earlier ones, and a dropping rule ends the evaluation.
+func applyRules(rules []*rule, input RuleInput) ruleResult {
+	var result ruleResult
+	for _, r := range rules {
+		if !r.matches(input) {
+			continue
+		}
+		actions := r.config.Actions
+		result.matched = append(result.matched, r.config.Name)
+		if actions.Drop {
+			result.droppedBy = r.config.Name
+			return result
+		}
+		result.tags = mergeTags(result.tags, actions.Tags)
+		if r.visibility != v1pb.Visibility_VISIBILITY_UNSPECIFIED {
+			result.visibility = r.visibility
+		}
+		if actions.Pin && result.pinnedBy == "" {
+			result.pinnedBy = r.config.Name
+		}
+		if actions.Account != "" {
+			result.account = actions.Account
+		}
+		if r.strip != nil {
+			result.strip = append(result.strip, r.strip)
+		}
+	}
+	return result
+}
+
+// mergeTags appends the tags of more that aren't in tags yet, comparing them
+// without their leading #.
+func mergeTags(tags, more []string) []string {
+	merged := make([]string, 0, len(tags)+len(more))
+	for _, tag := range append(slices.Clip(tags), more...) {
+		tag = strings.TrimPrefix(tag, "#")
+		if !slices.Contains(merged, tag) {
+			merged = append(merged, tag)
+		}
+	}
+	return merged
+}
+
+// stripContent removes the matches of the strip actions from content.
+func (r ruleResult) stripContent(content string) string {
+	if len(r.strip) == 0 {
+		return content
+	}
+	for _, strip := range r.strip {
+		content = strip.ReplaceAllString(content, "")
+	}
+	return strings.TrimSpace(content)
+}
+
+// ruleInputFromMessage describes message, whose text or caption without an
+// account prefix is content, to the rules.
+func ruleInputFromMessage(message *models.Message, content string, entities []models.MessageEntity) RuleInput {
+	input := RuleInput{
+		Content: content,
+		ChatID:  message.Chat.ID,
+		Media:   messageMedia(message),
+	}
+	if message.ForwardOrigin != nil {
+		input.ForwardName, input.ForwardUsername, input.ForwardChatID = forwardOrigin(message.ForwardOrigin)
+	}
+	for _, entity := range entities {
+		if entity.Type == models.MessageEntityTypeHashtag {
+			input.Hashtags = append(input.Hashtags, normalizeHashtag(utf16Substring(content, entity.Offset, entity.Length)))
+		}
+	}
+	return input
+}
+
+// messageMedia returns the kind of attachment of message, or text.
+func messageMedia(message *models.Message) string {
+	switch {
+	case len(message.Photo) > 0:
+		return "photo"
+	case message.Document != nil:
+		return "document"
+	case message.Voice != nil:
+		return "voice"
+	case message.Video != nil:
+		return "video"
+	}
+	return "text"
+}
+
+// forwardOrigin returns the name and username of the sender of a forwarded
+// message and, for chats and channels, their ID.
+func forwardOrigin(origin *models.MessageOrigin) (name, username string, chatID int64) {
+	switch {
+	case origin.MessageOriginUser != nil:
+		user := origin.MessageOriginUser.SenderUser
+		name = user.FirstName
+		if user.LastName != "" {
+			name = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
+		}
+		username = user.Username
+	case origin.MessageOriginHiddenUser != nil:
+		name = origin.MessageOriginHiddenUser.SenderUserName
+		if name == "" {
+			name = "Hidden User"
+		}
+	case origin.MessageOriginChat != nil:
+		chat := origin.MessageOriginChat.SenderChat
+		name, username, chatID = chat.Title, chat.Username, chat.ID
+	case origin.MessageOriginChannel != nil:
+		channel := origin.MessageOriginChannel.Chat
+		name, username, chatID = channel.Title, channel.Username, channel.ID
+	}
+	return name, username, chatID
+}
+
+// utf16Substring returns length UTF-16 code units of text from offset, the
+// way Telegram addresses entities.
+func utf16Substring(text string, offset, length int) string {
+	encoded := utf16.Encode([]rune(text))
+	start := min(max(offset, 0), len(encoded))
+	end := min(max(start+length, start), len(encoded))
+	return string(utf16.Decode(encoded[start:end]))
+}
@@ -0,0 +1,101 @@
+package memogram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+)
+
+func TestApplyRules(t *testing.T) {
+	rules, err := compileRules([]RuleConfig{
+		{
+			Name:    "releases",
+			Match:   RuleMatch{ForwardFrom: "Release notes"},
+			Actions: RuleActions{Tags: []string{"#releases"}, Visibility: "PROTECTED"},
+		},
+		{
+			Name:    "work photos",
+			Match:   RuleMatch{Media: "photo", Hashtag: "#work"},
+			Actions: RuleActions{Account: "work", Pin: true, Tags: []string{"releases", "photos"}},
+		},
+		{
+			Match:   RuleMatch{Content: `(?i)^ad:`},
+			Actions: RuleActions{Drop: true},
+		},
+		{
+			Match:   RuleMatch{ChatID: 7},
+			Actions: RuleActions{Strip: `\s*via @\w+`, Visibility: "PUBLIC"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("compile rules: %v", err)
+	}
+
+	result := applyRules(rules, RuleInput{Content: "v1.2", ForwardName: "release NOTES", Media: "text"})
+	if strings.Join(result.matched, ",") != "releases" || result.visibility != v1pb.Visibility_PROTECTED {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	result = applyRules(rules, RuleInput{Media: "photo", ForwardName: "Release notes", Hashtags: []string{"work"}})
+	if result.account != "work" || result.pinnedBy != "work photos" || strings.Join(result.tags, ",") != "releases,photos" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if result := applyRules(rules, RuleInput{Media: "text", Hashtags: []string{"work"}}); len(result.matched) != 0 {
+		t.Fatalf("expected no rule to match, got %v", result.matched)
+	}
+
+	result = applyRules(rules, RuleInput{Content: "Ad: buy now", ChatID: 7})
+	if result.droppedBy != "rules[2]" || result.visibility != v1pb.Visibility_VISIBILITY_UNSPECIFIED {
+		t.Fatalf("expected rules[2] to drop the message, got %+v", result)
+	}
+
+	result = applyRules(rules, RuleInput{Content: "Hello via @somebot", ChatID: 7})
+	if got := result.stripContent("Hello via @somebot"); got != "Hello" || result.visibility != v1pb.Visibility_PUBLIC {
+		t.Fatalf("unexpected content %q and result %+v", got, result)
+	}
+}
+
+func TestRuleInputFromMessage(t *testing.T) {
+	message := &models.Message{
+		Chat:    models.Chat{ID: 3},
+		Caption: "😀 #Work",
+		Photo:   []models.PhotoSize{{FileID: "photo"}},
+		ForwardOrigin: &models.MessageOrigin{
+			MessageOriginChannel: &models.MessageOriginChannel{Chat: models.Chat{ID: -100, Title: "Release notes", Username: "releases"}},
+		},
+	}
+	input := ruleInputFromMessage(message, message.Caption, []models.MessageEntity{{Type: models.MessageEntityTypeHashtag, Offset: 3, Length: 5}})
+	if input.Media != "photo" || input.ChatID != 3 || input.ForwardChatID != -100 || input.ForwardName != "Release notes" || input.ForwardUsername != "releases" {
+		t.Fatalf("unexpected input %+v", input)
+	}
+	if len(input.Hashtags) != 1 || input.Hashtags[0] != "Work" {
+		t.Fatalf("unexpected hashtags %v", input.Hashtags)
+	}
+}
+
+func TestTestRules(t *testing.T) {
+	config := &Config{Rules: []RuleConfig{
+		{Name: "releases", Match: RuleMatch{ForwardFrom: "@releases"}, Actions: RuleActions{Tags: []string{"releases"}, Visibility: "PROTECTED"}},
+		{Name: "drafts", Match: RuleMatch{Hashtag: "draft"}, Actions: RuleActions{Drop: true}},
+	}}
+
+	var out strings.Builder
+	if err := TestRules(config, RuleInput{Content: "v1.2", ForwardUsername: "releases"}, &out); err != nil {
+		t.Fatalf("test rules: %v", err)
+	}
+	for _, want := range []string{"+ releases\n", "- drafts\n", "PROTECTED", `"v1.2\n\n#releases"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q in:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := TestRules(config, RuleInput{Content: "idea #draft"}, &out); err != nil {
+		t.Fatalf("test rules: %v", err)
+	}
+	if !strings.Contains(out.String(), "dropped by drafts") {
+		t.Fatalf("expected the message to be dropped:\n%s", out.String())
+	}
+}
@@ -0,0 +1,109 @@
+package memogram
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-telegram/bot"
+)
+
+// scheduleInterval is how often the schedules are checked. It is shorter than
+// a minute so that no minute is missed.
+const scheduleInterval = 20 * time.Second
+
+// scheduleLists maps the lists a schedule may send to their memo list kinds.
+var scheduleLists = map[string]string{
+	"recent":   "r",
+	"pinned":   "p",
+	"archived": "a",
+}
+
+// schedule is a compiled ScheduleConfig.
+type schedule struct {
+	name    string
+	userID  int64
+	account string
+	kind    string
+	// hour and minute are the local time of day the list is sent at.
+	hour, minute int
+}
+
+func compileSchedules(configs []ScheduleConfig) ([]*schedule, error) {
+	schedules := make([]*schedule, 0, len(configs))
+	for _, config := range configs {
+		kind, ok := scheduleLists[config.List]
+		if !ok {
+			return nil, fmt.Errorf("schedule %q: %q is not one of recent, pinned or archived", config.Name, config.List)
+		}
+		at, err := time.Parse("15:04", config.At)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %q is not a time like 09:00", config.Name, config.At)
+		}
+		schedules = append(schedules, &schedule{
+			name:    config.Name,
+			userID:  config.UserID,
+			account: config.Account,
+			kind:    kind,
+			hour:    at.Hour(),
+			minute:  at.Minute(),
+		})
+	}
+	return schedules, nil
+}
+
+// runSchedules sends the memo lists of the schedules when they are due,
+// until ctx is done. The schedules are read from the live settings each
+// time, so reloads apply to them.
+func (s *Service) runSchedules(ctx context.Context) {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	sent := make(map[string]string)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.sendDueSchedules(ctx, now, sent)
+		}
+	}
+}
+
+// sendDueSchedules sends the lists of the schedules due at now, in local
+// time. sent maps the names of schedules to the day they were last sent, so
+// that each is sent at most once a day.
+func (s *Service) sendDueSchedules(ctx context.Context, now time.Time, sent map[string]string) {
+	day := now.Format(time.DateOnly)
+	for _, schedule := range s.settings().schedules {
+		if now.Hour() != schedule.hour || now.Minute() != schedule.minute || sent[schedule.name] == day {
+			continue
+		}
+		sent[schedule.name] = day
+		s.sendSchedule(ctx, schedule)
+	}
+}
+
+// sendSchedule sends the memo list of schedule to the private chat of its
+// user.
+func (s *Service) sendSchedule(ctx context.Context, schedule *schedule) {
+	client, credential, ok := s.accountClient(schedule.userID, schedule.account)
+	if !ok {
+		s.logger.Warn("skipping schedule of a user without the account", slog.String("schedule", schedule.name), slog.Int64("user_id", schedule.userID))
+		return
+	}
+	page := memoListPage{kind: schedule.kind, account: credential.Account}
+	if schedule.kind == "r" {
+		page.limit = defaultRecentCount
+	}
+	text, markup, err := s.renderMemoList(ctx, client, credential, page)
+	if err != nil {
+		s.logger.Error("failed to list memos for schedule", slog.String("schedule", schedule.name), slog.Any("err", err))
+		return
+	}
+	s.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      schedule.userID,
+		Text:        text,
+		ReplyMarkup: markup,
+	})
+}
@@ -0,0 +1,44 @@
+package memogram
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestE2ESchedules(t *testing.T) {
+	e := newE2E(t)
+	live, err := newLiveSettings(&Config{Schedules: []ScheduleConfig{
+		{Name: "morning", UserID: 1, List: "recent", At: "09:00"},
+		{Name: "signed out", UserID: 3, List: "pinned", At: "09:00"},
+	}})
+	if err != nil {
+		t.Fatalf("new live settings: %v", err)
+	}
+	e.service.live.Store(live)
+	e.send(textMessage(1, "Hello"))
+	replies := len(e.api.sent("sendMessage"))
+
+	ctx := context.Background()
+	sent := make(map[string]string)
+	e.service.sendDueSchedules(ctx, time.Date(2026, 10, 18, 8, 59, 0, 0, time.Local), sent)
+	if got := len(e.api.sent("sendMessage")); got != replies {
+		t.Fatalf("expected nothing to be sent before the schedule, got %d messages", got-replies)
+	}
+	for _, second := range []int{0, 20, 40} {
+		e.service.sendDueSchedules(ctx, time.Date(2026, 10, 18, 9, 0, second, 0, time.Local), sent)
+	}
+	sentMessages := e.api.sent("sendMessage")
+	if got := len(sentMessages); got != replies+1 {
+		t.Fatalf("expected one scheduled list, got %d messages", got-replies)
+	}
+	if message := sentMessages[len(sentMessages)-1]; message.Get("chat_id") != "1" || !strings.Contains(message.Get("text"), "1. Hello") {
+		t.Fatalf("unexpected scheduled list %v", message)
+	}
+
+	e.service.sendDueSchedules(ctx, time.Date(2026, 10, 19, 9, 0, 0, 0, time.Local), sent)
+	if got := len(e.api.sent("sendMessage")); got != replies+2 {
+		t.Fatalf("expected the list to be sent again the next day, got %d messages", got-replies)
+	}
+}
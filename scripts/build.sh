@@ -16,10 +16,13 @@ else
   OUTPUT="./build/memogram"
 fi
 
-echo "Building for $OS..."
+# Use the version from the environment, or describe the current commit
+VERSION=${VERSION:-$(git describe --tags --always --dirty 2>/dev/null || echo dev)}
+
+echo "Building $VERSION for $OS..."
 
 # Build the executable
-go build -o "$OUTPUT" ./bin/memogram/main.go
+go build -ldflags "-X main.version=$VERSION" -o "$OUTPUT" ./bin/memogram
 
 # Output the success message
 echo "Build successful!"
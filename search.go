@@ -0,0 +1,204 @@
+package memogram
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+)
+
+// searchUsage explains the query language of /search.
+const searchUsage = `Usage: /search <query>
+
+Memos must contain all words and "quoted phrases". Filters:
+tag:work, after:2025-01-01, before:2025-02-01 (UTC dates),
+visibility:public, pinned:true, has:attachment, has:link, has:task
+
+Put a - in front of a word or filter to exclude it, and join
+alternatives with OR, e.g. tag:work -tag:done "release notes" OR changelog`
+
+// searchHasFilters are the Memos filter expressions of the has: values.
+var searchHasFilters = map[string]string{
+	"attachment": "attachments.size() > 0",
+	"link":       "has_link",
+	"task":       "has_task_list",
+}
+
+// searchToken is a word, quoted phrase or filter of a search query.
+type searchToken struct {
+	text string
+	// quotedFrom is the index in text where the first quoted part starts, or
+	// -1 if nothing was quoted.
+	quotedFrom int
+	negated    bool
+}
+
+// isOr reports whether the token joins alternatives.
+func (t searchToken) isOr() bool {
+	return t.text == "OR" && t.quotedFrom < 0 && !t.negated
+}
+
+// tokenizeSearchQuery splits query at whitespace outside of double quotes.
+// Quoted parts may escape quotes and backslashes with a backslash.
+func tokenizeSearchQuery(query string) ([]searchToken, error) {
+	var tokens []searchToken
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		token := searchToken{quotedFrom: -1}
+		if runes[i] == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			token.negated = true
+			i++
+		}
+		var sb strings.Builder
+		for i < len(runes) && !unicode.IsSpace(runes[i]) {
+			if runes[i] != '"' {
+				sb.WriteRune(runes[i])
+				i++
+				continue
+			}
+			if token.quotedFrom < 0 {
+				token.quotedFrom = sb.Len()
+			}
+			i++
+			for {
+				if i == len(runes) {
+					return nil, errors.New("missing closing quote")
+				}
+				if runes[i] == '"' {
+					i++
+					break
+				}
+				if runes[i] == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\') {
+					i++
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+		}
+		token.text = sb.String()
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// compileSearchQuery compiles a /search query to a Memos filter expression.
+// Terms must all match, and terms joined by OR are alternatives.
+func compileSearchQuery(query string) (string, error) {
+	tokens, err := tokenizeSearchQuery(query)
+	if err != nil {
+		return "", err
+	}
+
+	var clauses [][]string
+	joinNext := false
+	for i, token := range tokens {
+		if token.isOr() {
+			if i == 0 || i == len(tokens)-1 || joinNext {
+				return "", errors.New("OR must be between two search terms")
+			}
+			joinNext = true
+			continue
+		}
+		expr, err := compileSearchTerm(token)
+		if err != nil {
+			return "", err
+		}
+		if joinNext {
+			clauses[len(clauses)-1] = append(clauses[len(clauses)-1], expr)
+			joinNext = false
+		} else {
+			clauses = append(clauses, []string{expr})
+		}
+	}
+	if len(clauses) == 0 {
+		return "", errors.New("empty search")
+	}
+
+	exprs := make([]string, 0, len(clauses))
+	for _, alternatives := range clauses {
+		if len(alternatives) == 1 {
+			exprs = append(exprs, alternatives[0])
+		} else {
+			exprs = append(exprs, "("+strings.Join(alternatives, " || ")+")")
+		}
+	}
+	return strings.Join(exprs, " && "), nil
+}
+
+// compileSearchTerm compiles a word, phrase or filter.
+func compileSearchTerm(token searchToken) (string, error) {
+	expr, err := compileSearchFilter(token)
+	if err != nil {
+		return "", err
+	}
+	if expr == "" {
+		if token.text == "" {
+			return "", errors.New("empty phrase")
+		}
+		expr = fmt.Sprintf("content.contains(%q)", token.text)
+	}
+	if token.negated {
+		return "!(" + expr + ")", nil
+	}
+	return expr, nil
+}
+
+// compileSearchFilter compiles a key:value filter, or returns "" if the token
+// is no filter. Keys must not be quoted.
+func compileSearchFilter(token searchToken) (string, error) {
+	key, value, ok := strings.Cut(token.text, ":")
+	if !ok || (token.quotedFrom >= 0 && token.quotedFrom <= len(key)) {
+		return "", nil
+	}
+	switch key {
+	case "tag", "after", "before", "visibility", "pinned", "has":
+	default:
+		return "", nil
+	}
+	if value == "" {
+		return "", fmt.Errorf("%s: needs a value", key)
+	}
+
+	switch key {
+	case "tag":
+		if !isValidTag(value) {
+			return "", fmt.Errorf("tag: %q is not a valid tag", value)
+		}
+		return fmt.Sprintf("tag in [%q]", strings.TrimPrefix(value, "#")), nil
+	case "after", "before":
+		date, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return "", fmt.Errorf("%s: %q is not a date like 2025-01-31", key, value)
+		}
+		if key == "after" {
+			return fmt.Sprintf("created_ts >= %d", date.Unix()), nil
+		}
+		return fmt.Sprintf("created_ts < %d", date.Unix()), nil
+	case "visibility":
+		visibility, err := parseVisibility(value)
+		if err != nil {
+			return "", fmt.Errorf("visibility: %w", err)
+		}
+		return fmt.Sprintf("visibility == %q", v1pb.Visibility_name[int32(visibility)]), nil
+	case "pinned":
+		pinned, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("pinned: %q is not true or false", value)
+		}
+		return fmt.Sprintf("pinned == %t", pinned), nil
+	default:
+		expr, ok := searchHasFilters[strings.ToLower(value)]
+		if !ok {
+			return "", fmt.Errorf("has: %q is not one of attachment, link or task", value)
+		}
+		return expr, nil
+	}
+}
@@ -0,0 +1,315 @@
+package memogram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// Telegram allows about one message per second in a single chat (with short
+// bursts) and about thirty messages per second across all chats.
+const (
+	defaultGlobalSendInterval  = time.Second / 30
+	defaultGlobalSendBurst     = 30
+	defaultPerChatSendInterval = time.Second
+	defaultPerChatSendBurst    = 3
+	defaultSendMaxRetries      = 3
+	defaultSendRetryBackoff    = 500 * time.Millisecond
+
+	// chatLimiterSweepInterval is how often the limiters of chats that
+	// haven't been sent to lately are dropped.
+	chatLimiterSweepInterval = time.Minute
+)
+
+// telegramServerError matches the error go-telegram/bot returns for error
+// responses it has no error value for, such as a 502 from Telegram.
+var telegramServerError = regexp.MustCompile(`error response from telegram for method \S+, 5\d\d `)
+
+// botAPI is the part of the Bot API Memogram uses. It is implemented by
+// *bot.Bot.
+type botAPI interface {
+	SendMessage(ctx context.Context, params *bot.SendMessageParams) (*models.Message, error)
+	EditMessageText(ctx context.Context, params *bot.EditMessageTextParams) (*models.Message, error)
+	DeleteMessage(ctx context.Context, params *bot.DeleteMessageParams) (bool, error)
+	AnswerCallbackQuery(ctx context.Context, params *bot.AnswerCallbackQueryParams) (bool, error)
+	GetFile(ctx context.Context, params *bot.GetFileParams) (*models.File, error)
+	SetMyCommands(ctx context.Context, params *bot.SetMyCommandsParams) (bool, error)
+	FileDownloadLink(file *models.File) string
+}
+
+// sender routes outgoing Bot API calls through per-chat and global rate
+// limiters, honors `retry_after` responses and retries transient failures.
+type sender struct {
+	bot    botAPI
+	logger *slog.Logger
+
+	global *rateLimiter
+
+	chatsMutex      sync.Mutex
+	chats           map[string]*rateLimiter
+	chatsSweptAt    time.Time
+	perChatInterval time.Duration
+	perChatBurst    int
+
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+func newSender(b botAPI) *sender {
+	return &sender{
+		bot:             b,
+		logger:          slog.Default(),
+		global:          newRateLimiter(defaultGlobalSendInterval, defaultGlobalSendBurst),
+		chats:           make(map[string]*rateLimiter),
+		perChatInterval: defaultPerChatSendInterval,
+		perChatBurst:    defaultPerChatSendBurst,
+		maxRetries:      defaultSendMaxRetries,
+		retryBackoff:    defaultSendRetryBackoff,
+	}
+}
+
+func (s *sender) SendMessage(ctx context.Context, params *bot.SendMessageParams) (*models.Message, error) {
+	var message *models.Message
+	err := s.do(ctx, "sendMessage", params.ChatID, func(ctx context.Context) error {
+		var err error
+		message, err = s.bot.SendMessage(ctx, params)
+		return err
+	})
+	return message, err
+}
+
+func (s *sender) EditMessageText(ctx context.Context, params *bot.EditMessageTextParams) (*models.Message, error) {
+	var message *models.Message
+	err := s.do(ctx, "editMessageText", params.ChatID, func(ctx context.Context) error {
+		var err error
+		message, err = s.bot.EditMessageText(ctx, params)
+		return err
+	})
+	return message, err
+}
+
+func (s *sender) DeleteMessage(ctx context.Context, params *bot.DeleteMessageParams) (bool, error) {
+	var ok bool
+	err := s.do(ctx, "deleteMessage", params.ChatID, func(ctx context.Context) error {
+		var err error
+		ok, err = s.bot.DeleteMessage(ctx, params)
+		return err
+	})
+	return ok, err
+}
+
+func (s *sender) AnswerCallbackQuery(ctx context.Context, params *bot.AnswerCallbackQueryParams) (bool, error) {
+	var ok bool
+	err := s.do(ctx, "answerCallbackQuery", nil, func(ctx context.Context) error {
+		var err error
+		ok, err = s.bot.AnswerCallbackQuery(ctx, params)
+		return err
+	})
+	return ok, err
+}
+
+func (s *sender) GetFile(ctx context.Context, params *bot.GetFileParams) (*models.File, error) {
+	var file *models.File
+	err := s.do(ctx, "getFile", nil, func(ctx context.Context) error {
+		var err error
+		file, err = s.bot.GetFile(ctx, params)
+		return err
+	})
+	return file, err
+}
+
+func (s *sender) SetMyCommands(ctx context.Context, params *bot.SetMyCommandsParams) (bool, error) {
+	var ok bool
+	err := s.do(ctx, "setMyCommands", nil, func(ctx context.Context) error {
+		var err error
+		ok, err = s.bot.SetMyCommands(ctx, params)
+		return err
+	})
+	return ok, err
+}
+
+// FileDownloadLink returns the URL to download file from. It makes no request.
+func (s *sender) FileDownloadLink(file *models.File) string {
+	return s.bot.FileDownloadLink(file)
+}
+
+// do waits for the rate limiters, performs the call and retries it when
+// Telegram asks us to slow down or the failure looks transient.
+func (s *sender) do(ctx context.Context, method string, chatID any, call func(context.Context) error) error {
+	chatLimiter := s.chatLimiter(chatID)
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		if chatLimiter != nil {
+			if err := chatLimiter.Wait(ctx); err != nil {
+				return err
+			}
+		}
+		if err := s.global.Wait(ctx); err != nil {
+			return err
+		}
+
+		err = call(ctx)
+		if err == nil {
+			return nil
+		}
+
+		delay, retryable := s.retryDelay(err, attempt)
+		if !retryable || attempt >= s.maxRetries || ctx.Err() != nil {
+			break
+		}
+		if chatLimiter != nil && bot.IsTooManyRequestsError(err) {
+			chatLimiter.Pause(delay)
+		}
+		s.logger.Warn("telegram request failed, retrying", slog.String("method", method), slog.Any("chat", chatID), slog.Int("attempt", attempt+1), slog.Duration("delay", delay), slog.Any("err", err))
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+
+	s.logger.Error("failed to send telegram request", slog.String("method", method), slog.Any("chat", chatID), slog.Any("err", err))
+	return err
+}
+
+// retryDelay reports how long to wait before retrying err and whether err is
+// worth retrying at all.
+func (s *sender) retryDelay(err error, attempt int) (time.Duration, bool) {
+	var tooManyRequests *bot.TooManyRequestsError
+	if errors.As(err, &tooManyRequests) {
+		delay := time.Duration(tooManyRequests.RetryAfter) * time.Second
+		if delay <= 0 {
+			delay = s.retryBackoff
+		}
+		return delay, true
+	}
+	if !isTransientSendError(err) {
+		return 0, false
+	}
+	return s.retryBackoff << attempt, true
+}
+
+func (s *sender) chatLimiter(chatID any) *rateLimiter {
+	if chatID == nil {
+		return nil
+	}
+	key := fmt.Sprint(chatID)
+	if key == "" || key == "0" {
+		return nil
+	}
+
+	s.chatsMutex.Lock()
+	defer s.chatsMutex.Unlock()
+	if now := time.Now(); now.Sub(s.chatsSweptAt) >= chatLimiterSweepInterval {
+		// An idle limiter allows a full burst, just like a new one.
+		for key, limiter := range s.chats {
+			if limiter.idle(now) {
+				delete(s.chats, key)
+			}
+		}
+		s.chatsSweptAt = now
+	}
+	limiter, ok := s.chats[key]
+	if !ok {
+		limiter = newRateLimiter(s.perChatInterval, s.perChatBurst)
+		s.chats[key] = limiter
+	}
+	return limiter
+}
+
+// isTransientSendError reports whether err is a server-side failure or a
+// failure to connect, from before the request was sent. Other network errors
+// aren't retried: Telegram may have carried out the request already, and
+// sending a message again would duplicate it.
+func isTransientSendError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if telegramServerError.MatchString(err.Error()) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "proxyconnect")
+}
+
+// rateLimiter allows `burst` events at once and one event per `interval` on
+// average after that.
+type rateLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	burst    int
+	// next is the theoretical time at which the bucket is empty again.
+	next time.Time
+}
+
+func newRateLimiter(interval time.Duration, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		interval: interval,
+		burst:    burst,
+	}
+}
+
+// Wait blocks until the limiter allows one more event or ctx is done.
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	return sleepContext(ctx, l.reserve(time.Now()))
+}
+
+// Pause blocks all events for at least d from now.
+func (l *rateLimiter) Pause(d time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	until := time.Now().Add(d + time.Duration(l.burst-1)*l.interval)
+	if until.After(l.next) {
+		l.next = until
+	}
+}
+
+// idle reports whether the limiter allows a full burst at now.
+func (l *rateLimiter) idle(now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return !l.next.After(now)
+}
+
+// reserve books the next event and returns how long the caller must wait.
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	allowedAt := l.next.Add(-time.Duration(l.burst-1) * l.interval)
+	l.next = l.next.Add(l.interval)
+	if wait := allowedAt.Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,271 @@
+package memogram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+)
+
+// fakeBotAPI replies to Bot API methods with queued responses, or a default
+// response of the method, and records the calls and their parameters. It also
+// serves file downloads with the content fakeFileContent.
+type fakeBotAPI struct {
+	mutex     sync.Mutex
+	responses map[string][]string
+	calls     map[string]int
+	requests  []fakeBotRequest
+	url       string
+}
+
+// fakeBotRequest is a recorded Bot API call.
+type fakeBotRequest struct {
+	method string
+	params url.Values
+}
+
+// Default responses of methods whose result is not a boolean.
+const (
+	fakeSentMessage = `{"ok":true,"result":{"message_id":10,"date":0,"chat":{"id":1,"type":"private"}}}`
+	fakeFile        = `{"ok":true,"result":{"file_id":"file","file_unique_id":"file","file_size":12,"file_path":"photos/file.jpg"}}`
+	fakeFileContent = "file content"
+)
+
+var fakeDefaultResponses = map[string]string{
+	"sendMessage":     fakeSentMessage,
+	"editMessageText": fakeSentMessage,
+	"getFile":         fakeFile,
+}
+
+func newFakeBotAPI(t *testing.T) (*fakeBotAPI, *bot.Bot) {
+	t.Helper()
+	api := &fakeBotAPI{
+		responses: make(map[string][]string),
+		calls:     make(map[string]int),
+	}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	api.url = server.URL
+
+	b, err := bot.New("test-token", bot.WithServerURL(server.URL), bot.WithSkipGetMe())
+	if err != nil {
+		t.Fatalf("create bot: %v", err)
+	}
+	return api, b
+}
+
+func (f *fakeBotAPI) enqueue(method string, bodies ...string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.responses[method] = append(f.responses[method], bodies...)
+}
+
+func (f *fakeBotAPI) callCount(method string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.calls[method]
+}
+
+// sent returns the parameters of the calls of method in call order.
+func (f *fakeBotAPI) sent(method string) []url.Values {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var params []url.Values
+	for _, request := range f.requests {
+		if request.method == method {
+			params = append(params, request.params)
+		}
+	}
+	return params
+}
+
+func (f *fakeBotAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/file/") {
+		fmt.Fprint(w, fakeFileContent)
+		return
+	}
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	params := url.Values{}
+	if err := r.ParseMultipartForm(1 << 20); err == nil {
+		params = r.MultipartForm.Value
+	}
+
+	f.mutex.Lock()
+	f.calls[method]++
+	f.requests = append(f.requests, fakeBotRequest{method: method, params: params})
+	body := `{"ok":true,"result":true}`
+	if response, ok := fakeDefaultResponses[method]; ok {
+		body = response
+	}
+	if queued := f.responses[method]; len(queued) > 0 {
+		body = queued[0]
+		f.responses[method] = queued[1:]
+	}
+	f.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, body)
+}
+
+func newTestSender(b *bot.Bot) *sender {
+	s := newSender(b)
+	s.retryBackoff = time.Millisecond
+	return s
+}
+
+func TestSenderHonorsRetryAfter(t *testing.T) {
+	api, b := newFakeBotAPI(t)
+	api.enqueue("sendMessage",
+		`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`,
+		fakeSentMessage,
+	)
+
+	s := newTestSender(b)
+	started := time.Now()
+	message, err := s.SendMessage(context.Background(), &bot.SendMessageParams{ChatID: 1, Text: "hello"})
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if message.ID != 10 {
+		t.Fatalf("expected message 10, got %d", message.ID)
+	}
+	if calls := api.callCount("sendMessage"); calls != 2 {
+		t.Fatalf("expected 2 sendMessage calls, got %d", calls)
+	}
+	if elapsed := time.Since(started); elapsed < time.Second {
+		t.Fatalf("expected to wait for retry_after, waited %s", elapsed)
+	}
+}
+
+func TestSenderRetriesTransientFailures(t *testing.T) {
+	api, b := newFakeBotAPI(t)
+	api.enqueue("sendMessage",
+		`{"ok":false,"error_code":502,"description":"Bad Gateway"}`,
+		`{"ok":false,"error_code":500,"description":"Internal Server Error"}`,
+		fakeSentMessage,
+	)
+
+	s := newTestSender(b)
+	if _, err := s.SendMessage(context.Background(), &bot.SendMessageParams{ChatID: 1, Text: "hello"}); err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if calls := api.callCount("sendMessage"); calls != 3 {
+		t.Fatalf("expected 3 sendMessage calls, got %d", calls)
+	}
+}
+
+func TestSenderDoesNotRetryBadRequest(t *testing.T) {
+	api, b := newFakeBotAPI(t)
+	api.enqueue("sendMessage", `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`)
+
+	s := newTestSender(b)
+	if _, err := s.SendMessage(context.Background(), &bot.SendMessageParams{ChatID: 1, Text: "hello"}); err == nil {
+		t.Fatal("expected error for bad request")
+	}
+	if calls := api.callCount("sendMessage"); calls != 1 {
+		t.Fatalf("expected 1 sendMessage call, got %d", calls)
+	}
+}
+
+func TestSenderGivesUpAfterMaxRetries(t *testing.T) {
+	api, b := newFakeBotAPI(t)
+	for i := 0; i < 10; i++ {
+		api.enqueue("editMessageText", `{"ok":false,"error_code":500,"description":"Internal Server Error"}`)
+	}
+
+	s := newTestSender(b)
+	s.maxRetries = 2
+	if _, err := s.EditMessageText(context.Background(), &bot.EditMessageTextParams{ChatID: 1, MessageID: 1, Text: "hello"}); err == nil {
+		t.Fatal("expected error after retries")
+	}
+	if calls := api.callCount("editMessageText"); calls != 3 {
+		t.Fatalf("expected 3 editMessageText calls, got %d", calls)
+	}
+}
+
+func TestSenderDoesNotRetryAmbiguousFailures(t *testing.T) {
+	// The server drops the connection after reading the request, so the
+	// message may or may not have been sent.
+	var mutex sync.Mutex
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		calls++
+		mutex.Unlock()
+		panic(http.ErrAbortHandler)
+	}))
+	t.Cleanup(server.Close)
+	b, err := bot.New("test-token", bot.WithServerURL(server.URL), bot.WithSkipGetMe())
+	if err != nil {
+		t.Fatalf("create bot: %v", err)
+	}
+
+	s := newTestSender(b)
+	if _, err := s.SendMessage(context.Background(), &bot.SendMessageParams{ChatID: 1, Text: "hello"}); err == nil {
+		t.Fatal("expected error for a dropped connection")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if calls != 1 {
+		t.Fatalf("expected 1 sendMessage call, got %d", calls)
+	}
+}
+
+func TestIsTransientSendError(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{&url.Error{Op: "Post", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, true},
+		{&url.Error{Op: "Post", Err: &net.DNSError{Err: "no such host"}}, true},
+		{&url.Error{Op: "Post", Err: &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}}, false},
+		{fmt.Errorf("error response from telegram for method sendMessage, 502 Bad Gateway"), true},
+		{fmt.Errorf("%w, chat not found", bot.ErrorBadRequest), false},
+	} {
+		if got := isTransientSendError(test.err); got != test.want {
+			t.Fatalf("isTransientSendError(%v) = %t, want %t", test.err, got, test.want)
+		}
+	}
+}
+
+func TestSenderDropsIdleChatLimiters(t *testing.T) {
+	s := newSender(nil)
+	busy := s.chatLimiter(int64(1))
+	busy.Pause(time.Hour)
+	s.chatLimiter(int64(2))
+
+	s.chatsSweptAt = time.Time{}
+	s.chatLimiter(int64(3))
+	if _, ok := s.chats["2"]; ok {
+		t.Fatal("expected the idle limiter to be dropped")
+	}
+	if s.chats["1"] != busy || s.chats["3"] == nil {
+		t.Fatalf("expected the busy and the new limiter to be kept, got %v", s.chats)
+	}
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	limiter := newRateLimiter(time.Second, 2)
+	now := time.Unix(1000, 0)
+
+	for i, want := range []time.Duration{0, 0, time.Second, 2 * time.Second} {
+		if got := limiter.reserve(now); got != want {
+			t.Fatalf("reservation %d: expected wait %s, got %s", i, want, got)
+		}
+	}
+
+	// After the bucket refills, bursts are allowed again.
+	later := now.Add(10 * time.Second)
+	if got := limiter.reserve(later); got != 0 {
+		t.Fatalf("expected no wait after refill, got %s", got)
+	}
+}
@@ -0,0 +1,250 @@
+package memogram
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+)
+
+// configPollInterval is how often the config file is checked for changes.
+const configPollInterval = 5 * time.Second
+
+const (
+	defaultWelcomeTemplate = "Hello {{.Name}}! Your memos are saved with the {{.Account}} account."
+	defaultSavedTemplate   = "Content saved as {{.Visibility}} with [{{.Memo}}]({{.URL}})"
+)
+
+// liveSettings are the settings that are reloaded while the bot is running.
+// They are replaced as a whole and never modified once in use.
+type liveSettings struct {
+	allowedUsernames map[string]struct{}
+	allowedUserIDs   map[int64]struct{}
+	allowedChatIDs   map[int64]struct{}
+	adminUserIDs     map[int64]struct{}
+	allowedInstances map[string]struct{}
+
+	limits    LimitsConfig
+	templates *messageTemplates
+	routes    map[int64]RouteConfig
+	rules     []*rule
+	schedules []*schedule
+}
+
+func newLiveSettings(config *Config) (*liveSettings, error) {
+	allowedUserIDs, err := parseIDs(string(config.AllowedUserIDs))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ALLOWED_USER_IDS: %w", err)
+	}
+	allowedChatIDs, err := parseIDs(string(config.AllowedChatIDs))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ALLOWED_CHAT_IDS: %w", err)
+	}
+	adminUserIDs, err := parseIDs(string(config.AdminUserIDs))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ADMIN_USER_IDS: %w", err)
+	}
+	templates, err := newMessageTemplates(config.Templates)
+	if err != nil {
+		return nil, fmt.Errorf("invalid templates: %w", err)
+	}
+	routes := make(map[int64]RouteConfig, len(config.Routes))
+	for _, route := range config.Routes {
+		routes[route.ChatID] = route
+	}
+	rules, err := compileRules(config.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rules: %w", err)
+	}
+	schedules, err := compileSchedules(config.Schedules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedules: %w", err)
+	}
+	return &liveSettings{
+		allowedUsernames: parseAllowedUsernames(string(config.AllowedUsernames)),
+		allowedUserIDs:   allowedUserIDs,
+		allowedChatIDs:   allowedChatIDs,
+		adminUserIDs:     adminUserIDs,
+		allowedInstances: parseAllowedInstances(string(config.AllowedInstances)),
+		limits:           config.Limits,
+		templates:        templates,
+		routes:           routes,
+		rules:            rules,
+		schedules:        schedules,
+	}, nil
+}
+
+// settings returns the current live settings.
+func (s *Service) settings() *liveSettings {
+	return s.live.Load()
+}
+
+// watchConfig reloads the live settings when the process receives SIGHUP or
+// the config file changes, until ctx is done. SIGHUP also reloads the store.
+func (s *Service) watchConfig(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var poll <-chan time.Time
+	var modTime time.Time
+	if s.config.File != "" {
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+		modTime = fileModTime(s.config.File)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			s.reloadConfig()
+			// The store may have been changed with the CLI.
+			if err := s.store.Reload(); err != nil {
+				s.logger.Error("failed to reload store", slog.Any("err", err))
+			}
+		case <-poll:
+			if current := fileModTime(s.config.File); !current.Equal(modTime) {
+				modTime = current
+				s.reloadConfig()
+			}
+		}
+	}
+}
+
+// reloadConfig reads the configuration again and applies the live settings.
+// An invalid configuration is rejected as a whole and the current settings
+// stay in effect.
+func (s *Service) reloadConfig() error {
+	config, err := s.loadConfig()
+	if err != nil {
+		s.logger.Error("failed to reload config, keeping the current settings", slog.Any("err", err))
+		return err
+	}
+	live, err := newLiveSettings(config)
+	if err != nil {
+		s.logger.Error("failed to reload config, keeping the current settings", slog.Any("err", err))
+		return err
+	}
+	previous := s.loadedConfig
+	if previous == nil {
+		previous = s.config
+	}
+	for _, key := range restartRequiredChanges(previous, config) {
+		s.logger.Warn("config change takes effect after a restart", slog.String("setting", key))
+	}
+	s.loadedConfig = config
+	s.live.Store(live)
+	s.logger.Info("config reloaded")
+	return nil
+}
+
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// messageTemplates are the parsed reply templates.
+type messageTemplates struct {
+	welcome *template.Template
+	saved   *template.Template
+}
+
+// welcomeData is available to the welcome template.
+type welcomeData struct {
+	Name    string
+	Account string
+}
+
+// savedData is available to the saved template.
+type savedData struct {
+	Visibility string
+	Memo       string
+	URL        string
+}
+
+func newMessageTemplates(config TemplatesConfig) (*messageTemplates, error) {
+	welcome, err := parseTemplate("welcome", config.Welcome, defaultWelcomeTemplate, welcomeData{})
+	if err != nil {
+		return nil, err
+	}
+	saved, err := parseTemplate("saved", config.Saved, defaultSavedTemplate, savedData{})
+	if err != nil {
+		return nil, err
+	}
+	return &messageTemplates{welcome: welcome, saved: saved}, nil
+}
+
+// parseTemplate parses text, or fallback if text is empty, and executes it
+// once with sample so that references to unknown fields fail early.
+func parseTemplate(name, text, fallback string, sample any) (*template.Template, error) {
+	if text == "" {
+		text = fallback
+	}
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// render executes tmpl, which was checked when it was parsed, and logs
+// errors to logger.
+func render(logger *slog.Logger, tmpl *template.Template, data any) string {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		logger.Error("failed to render template", slog.String("template", tmpl.Name()), slog.Any("err", err))
+	}
+	return sb.String()
+}
+
+// parseVisibility parses a visibility name such as PUBLIC.
+func parseVisibility(name string) (v1pb.Visibility, error) {
+	switch strings.ToUpper(name) {
+	case "PUBLIC":
+		return v1pb.Visibility_PUBLIC, nil
+	case "PROTECTED":
+		return v1pb.Visibility_PROTECTED, nil
+	case "PRIVATE":
+		return v1pb.Visibility_PRIVATE, nil
+	}
+	return v1pb.Visibility_VISIBILITY_UNSPECIFIED, fmt.Errorf("%q is not one of PUBLIC, PROTECTED or PRIVATE", name)
+}
+
+// isValidTag reports whether tag, with or without its leading #, can be
+// appended to a memo as a single tag.
+func isValidTag(tag string) bool {
+	tag = strings.TrimPrefix(tag, "#")
+	return tag != "" && !strings.ContainsAny(tag, "# \t\n")
+}
+
+// appendTags appends tags as hashtags on a new line of content.
+func appendTags(content string, tags []string) string {
+	if len(tags) == 0 {
+		return content
+	}
+	hashtags := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		hashtags = append(hashtags, "#"+strings.TrimPrefix(tag, "#"))
+	}
+	if content == "" {
+		return strings.Join(hashtags, " ")
+	}
+	return content + "\n\n" + strings.Join(hashtags, " ")
+}
@@ -0,0 +1,70 @@
+package memogram
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+)
+
+// shortcutsHandler lists the shortcuts of the user's active account as
+// buttons, which show the memos matching the shortcut's filter.
+func (s *Service) shortcutsHandler(ctx context.Context, b *bot.Bot, m *models.Update) {
+	client, credential, ok := s.userClient(m.Message.From.ID)
+	if !ok {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   "Please start the bot with /start <access_token>",
+		})
+		return
+	}
+	user, err := s.currentUser(ctx, credential.Instance, credential.AccessToken)
+	if err != nil {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   "Invalid access token",
+		})
+		return
+	}
+	resp, err := client.ShortcutService.ListShortcuts(ctx, connect.NewRequest(&v1pb.ListShortcutsRequest{
+		Parent: user.GetName(),
+	}))
+	if err != nil {
+		s.logger.Error("failed to list shortcuts", slog.Any("err", err))
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   "Failed to list shortcuts",
+		})
+		return
+	}
+
+	keyboard := &models.InlineKeyboardMarkup{}
+	for _, shortcut := range resp.Msg.GetShortcuts() {
+		id := shortcut.GetName()[strings.LastIndex(shortcut.GetName(), "/")+1:]
+		page := memoListPage{kind: shortcutListKind + id, account: credential.Account}
+		data := page.callbackData("", "")
+		// Telegram rejects callback data longer than 64 bytes.
+		if id == "" || len(data) > 64 {
+			continue
+		}
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []models.InlineKeyboardButton{
+			{Text: shortcut.GetTitle(), CallbackData: data},
+		})
+	}
+	if len(keyboard.InlineKeyboard) == 0 {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   "No shortcuts yet. Create them in Memos to run them here.",
+		})
+		return
+	}
+	s.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      m.Message.Chat.ID,
+		Text:        "Your shortcuts:",
+		ReplyMarkup: keyboard,
+	})
+}
@@ -0,0 +1,38 @@
+package memogram
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestE2EShortcuts(t *testing.T) {
+	e := newE2E(t)
+	e.send(textMessage(1, "/shortcuts"))
+	if text := e.lastText(t, "sendMessage"); !strings.HasPrefix(text, "No shortcuts yet") {
+		t.Fatalf("unexpected reply %q", text)
+	}
+
+	e.memos.addShortcut("users/1", "open-tasks", "Open tasks", `tag in ["todo"]`)
+	e.memos.addShortcut("users/2", "other", "Other", `tag in ["todo"]`)
+	e.send(textMessage(1, "Buy milk #todo"))
+	e.send(textMessage(1, "Read book"))
+	e.send(textMessage(2, "Not mine #todo"))
+
+	e.send(textMessage(1, "/shortcuts"))
+	reply := e.api.sent("sendMessage")
+	markup := reply[len(reply)-1].Get("reply_markup")
+	if !strings.Contains(markup, `"text":"Open tasks","callback_data":"ls s:open-tasks 0 0 default"`) || strings.Contains(markup, "Other") {
+		t.Fatalf("expected only the user's shortcuts, got %s", markup)
+	}
+
+	e.press(1, "ls s:open-tasks 0 0 default")
+	if text := e.lastText(t, "editMessageText"); text != "Open tasks, page 1:\n1. Buy milk #todo" {
+		t.Fatalf("unexpected results %q", text)
+	}
+
+	// Shortcuts of other users can't be run.
+	e.press(1, "ls s:other 0 0 default")
+	if text := e.lastText(t, "answerCallbackQuery"); text != "Failed to list memos" {
+		t.Fatalf("unexpected answer %q", text)
+	}
+}
@@ -0,0 +1,152 @@
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AccessKind is what an allowlist entry matches on.
+type AccessKind string
+
+const (
+	// AccessUser matches a numeric Telegram user ID.
+	AccessUser AccessKind = "user"
+	// AccessChat matches a numeric Telegram chat ID.
+	AccessChat AccessKind = "chat"
+	// AccessUsername matches a Telegram username, case-insensitively.
+	AccessUsername AccessKind = "username"
+)
+
+// AccessEntry is an allowlist entry managed at runtime.
+type AccessEntry struct {
+	Kind  AccessKind
+	Value string
+}
+
+func (e AccessEntry) String() string {
+	return fmt.Sprintf("%s:%s", e.Kind, e.Value)
+}
+
+// NewAccessEntry returns a normalized allowlist entry.
+func NewAccessEntry(kind AccessKind, value string) (AccessEntry, error) {
+	value = strings.TrimSpace(value)
+	switch kind {
+	case AccessUser, AccessChat:
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id == 0 {
+			return AccessEntry{}, fmt.Errorf("invalid %s ID %q", kind, value)
+		}
+		value = strconv.FormatInt(id, 10)
+	case AccessUsername:
+		value = strings.ToLower(strings.TrimPrefix(value, "@"))
+		if value == "" {
+			return AccessEntry{}, fmt.Errorf("empty username")
+		}
+	default:
+		return AccessEntry{}, fmt.Errorf("unknown access kind %q", kind)
+	}
+	return AccessEntry{Kind: kind, Value: value}, nil
+}
+
+// HasAccessEntry reports whether the entry is in the allowlist.
+func (s *Store) HasAccessEntry(entry AccessEntry) bool {
+	_, ok := s.accessEntries.Load(entry)
+	return ok
+}
+
+// ListAccessEntries returns the allowlist sorted by kind and value.
+func (s *Store) ListAccessEntries() []AccessEntry {
+	entries := make([]AccessEntry, 0)
+	s.accessEntries.Range(func(key, value interface{}) bool {
+		entries = append(entries, key.(AccessEntry))
+		return true
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Kind == entries[j].Kind {
+			return entries[i].Value < entries[j].Value
+		}
+		return entries[i].Kind < entries[j].Kind
+	})
+	return entries
+}
+
+// AddAccessEntry adds the entry to the allowlist and persists it.
+func (s *Store) AddAccessEntry(entry AccessEntry) error {
+	s.accessEntries.Store(entry, struct{}{})
+	if err := s.saveAccessEntriesToFile(); err != nil {
+		return fmt.Errorf("save access entries: %w", err)
+	}
+	return nil
+}
+
+// RemoveAccessEntry removes the entry from the allowlist and persists it. It
+// reports whether the entry was in the allowlist.
+func (s *Store) RemoveAccessEntry(entry AccessEntry) (bool, error) {
+	if _, ok := s.accessEntries.LoadAndDelete(entry); !ok {
+		return false, nil
+	}
+	if err := s.saveAccessEntriesToFile(); err != nil {
+		return true, fmt.Errorf("save access entries: %w", err)
+	}
+	return true, nil
+}
+
+func (s *Store) saveAccessEntriesToFile() error {
+	if s.Format == FormatJSON {
+		// The users share the file, so reload them like the user writes do
+		// rather than writing back stale cached accounts.
+		s.userAccountsMutex.Lock()
+		defer s.userAccountsMutex.Unlock()
+		s.refreshUserAccounts()
+		return s.writeJSONFile()
+	}
+	s.accessFileMutex.Lock()
+	defer s.accessFileMutex.Unlock()
+
+	entries := s.ListAccessEntries()
+	return writeFileAtomic(s.AccessData, func(writer *bufio.Writer) error {
+		for _, entry := range entries {
+			if _, err := fmt.Fprintln(writer, entry.String()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) loadAccessEntriesFromFile() (map[AccessEntry]struct{}, error) {
+	entries := make(map[AccessEntry]struct{})
+	file, err := os.Open(s.AccessData)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kind, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		entry, err := NewAccessEntry(AccessKind(kind), value)
+		if err != nil {
+			continue
+		}
+		entries[entry] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
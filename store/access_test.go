@@ -0,0 +1,81 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNewAccessEntry(t *testing.T) {
+	entry, err := NewAccessEntry(AccessUsername, " @Alice ")
+	if err != nil || entry != (AccessEntry{Kind: AccessUsername, Value: "alice"}) {
+		t.Fatalf("unexpected entry %+v: %v", entry, err)
+	}
+	entry, err = NewAccessEntry(AccessChat, "-100123")
+	if err != nil || entry.Value != "-100123" {
+		t.Fatalf("unexpected entry %+v: %v", entry, err)
+	}
+	if _, err := NewAccessEntry(AccessUser, "alice"); err == nil {
+		t.Fatal("expected error for non-numeric user ID")
+	}
+}
+
+func TestSaveAndLoadAccessEntries(t *testing.T) {
+	dataPath := filepath.Join(t.TempDir(), "data.txt")
+
+	store := NewStore(dataPath)
+	if err := store.Init(); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+
+	user, _ := NewAccessEntry(AccessUser, "42")
+	chat, _ := NewAccessEntry(AccessChat, "-100123")
+	username, _ := NewAccessEntry(AccessUsername, "bob")
+	for _, entry := range []AccessEntry{user, chat, username} {
+		if err := store.AddAccessEntry(entry); err != nil {
+			t.Fatalf("add %s: %v", entry, err)
+		}
+	}
+	if removed, err := store.RemoveAccessEntry(chat); err != nil || !removed {
+		t.Fatalf("remove %s: %v", chat, err)
+	}
+
+	reloaded := NewStore(dataPath)
+	if err := reloaded.Init(); err != nil {
+		t.Fatalf("init reloaded store: %v", err)
+	}
+	if !reloaded.HasAccessEntry(user) || !reloaded.HasAccessEntry(username) || reloaded.HasAccessEntry(chat) {
+		t.Fatalf("unexpected access entries %v", reloaded.ListAccessEntries())
+	}
+}
+
+func TestAccessWriteKeepsRevocationOfAnotherStore(t *testing.T) {
+	dataPath := filepath.Join(t.TempDir(), "data.json")
+	bot := NewStore(dataPath)
+	if err := bot.Init(); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	bot.SetUserAccessToken(42, "token-one")
+
+	cli := NewStore(dataPath)
+	if err := cli.Init(); err != nil {
+		t.Fatalf("init CLI store: %v", err)
+	}
+	if removed, err := cli.RemoveUserCredential(42, ""); !removed || err != nil {
+		t.Fatalf("revoke: %v %v", removed, err)
+	}
+	entry, _ := NewAccessEntry(AccessUser, "7")
+	if err := bot.AddAccessEntry(entry); err != nil {
+		t.Fatalf("add %s: %v", entry, err)
+	}
+
+	reloaded := NewStore(dataPath)
+	if err := reloaded.Init(); err != nil {
+		t.Fatalf("init reloaded store: %v", err)
+	}
+	if _, ok := reloaded.GetUserAccessToken(42); ok {
+		t.Fatal("expected the revoked user to stay removed")
+	}
+	if !reloaded.HasAccessEntry(entry) {
+		t.Fatalf("expected %s to be saved", entry)
+	}
+}
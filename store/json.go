@@ -0,0 +1,117 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// jsonData is the document of the JSON format.
+type jsonData struct {
+	Users  []jsonUser        `json:"users"`
+	Access []jsonAccessEntry `json:"access"`
+}
+
+type jsonUser struct {
+	ID       int64         `json:"id"`
+	Active   string        `json:"active"`
+	Accounts []jsonAccount `json:"accounts"`
+}
+
+type jsonAccount struct {
+	Name        string `json:"name"`
+	Instance    string `json:"instance,omitempty"`
+	AccessToken string `json:"access_token"`
+}
+
+type jsonAccessEntry struct {
+	Kind  AccessKind `json:"kind"`
+	Value string     `json:"value"`
+}
+
+func (s *Store) readJSONFile() (map[int64]*userAccounts, map[AccessEntry]struct{}, error) {
+	users := make(map[int64]*userAccounts)
+	entries := make(map[AccessEntry]struct{})
+	content, err := os.ReadFile(s.Data)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return users, entries, nil
+		}
+		return nil, nil, err
+	}
+	if len(content) == 0 {
+		return users, entries, nil
+	}
+
+	var data jsonData
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, nil, fmt.Errorf("parse %s: %w", s.Data, err)
+	}
+	for _, user := range data.Users {
+		if user.ID == 0 || len(user.Accounts) == 0 {
+			continue
+		}
+		accounts := &userAccounts{active: user.Active, accounts: map[string]Credential{}}
+		for _, account := range user.Accounts {
+			name := account.Name
+			if name == "" {
+				name = DefaultAccount
+			}
+			accounts.accounts[name] = Credential{
+				Account:     name,
+				Instance:    account.Instance,
+				AccessToken: account.AccessToken,
+			}
+		}
+		if _, ok := accounts.accounts[accounts.active]; !ok {
+			accounts.active = user.Accounts[0].Name
+			if accounts.active == "" {
+				accounts.active = DefaultAccount
+			}
+		}
+		users[user.ID] = accounts
+	}
+	for _, access := range data.Access {
+		entry, err := NewAccessEntry(access.Kind, access.Value)
+		if err != nil {
+			continue
+		}
+		entries[entry] = struct{}{}
+	}
+	return users, entries, nil
+}
+
+// writeJSONFile writes the users and the allowlist, which share the file.
+func (s *Store) writeJSONFile() error {
+	s.accessFileMutex.Lock()
+	defer s.accessFileMutex.Unlock()
+
+	data := jsonData{
+		Users:  make([]jsonUser, 0),
+		Access: make([]jsonAccessEntry, 0),
+	}
+	for _, entry := range s.snapshotAccessTokens() {
+		if len(data.Users) == 0 || data.Users[len(data.Users)-1].ID != entry.userID {
+			data.Users = append(data.Users, jsonUser{ID: entry.userID})
+		}
+		user := &data.Users[len(data.Users)-1]
+		if entry.active || user.Active == "" {
+			user.Active = entry.credential.Account
+		}
+		user.Accounts = append(user.Accounts, jsonAccount{
+			Name:        entry.credential.Account,
+			Instance:    entry.credential.Instance,
+			AccessToken: entry.credential.AccessToken,
+		})
+	}
+	for _, entry := range s.ListAccessEntries() {
+		data.Access = append(data.Access, jsonAccessEntry{Kind: entry.Kind, Value: entry.Value})
+	}
+
+	return writeFileAtomic(s.Data, func(writer *bufio.Writer) error {
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(data)
+	})
+}
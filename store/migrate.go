@@ -0,0 +1,47 @@
+package store
+
+import (
+	"fmt"
+	"os"
+)
+
+// Migrate copies all users and allowlist entries from the store at `from` to
+// a new store at `to`, converting between the formats of the two paths. It
+// never overwrites an existing store.
+func Migrate(from, to string) error {
+	if _, err := os.Stat(from); err != nil {
+		return fmt.Errorf("source store: %w", err)
+	}
+	source := NewStore(from)
+	if err := source.Init(); err != nil {
+		return fmt.Errorf("init source store: %w", err)
+	}
+
+	target := NewStore(to)
+	for _, path := range []string{target.Data, target.AccessData} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("target %s already exists", path)
+		}
+	}
+	source.userAccessTokenCache.Range(func(key, value interface{}) bool {
+		target.userAccessTokenCache.Store(key, value)
+		return true
+	})
+	entries := source.ListAccessEntries()
+	for _, entry := range entries {
+		target.accessEntries.Store(entry, struct{}{})
+	}
+
+	if err := target.SaveUserAccessTokenMapToFile(); err != nil {
+		return fmt.Errorf("save target store: %w", err)
+	}
+	if target.Format == FormatText && len(entries) > 0 {
+		if err := target.saveAccessEntriesToFile(); err != nil {
+			return fmt.Errorf("save target allowlist: %w", err)
+		}
+	}
+	return nil
+}
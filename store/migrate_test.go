@@ -0,0 +1,58 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateBetweenFormats(t *testing.T) {
+	dir := t.TempDir()
+	textPath := filepath.Join(dir, "data.txt")
+
+	source := NewStore(textPath)
+	if err := source.Init(); err != nil {
+		t.Fatalf("init source store: %v", err)
+	}
+	source.SetUserCredential(1, Credential{AccessToken: "token-1"})
+	source.SetUserCredential(2, Credential{Account: "work", Instance: "https://memos.example.com", AccessToken: "token-2"})
+	source.SetUserCredential(2, Credential{AccessToken: "token-3"})
+	if err := source.SwitchUserAccount(2, "work"); err != nil {
+		t.Fatalf("switch account: %v", err)
+	}
+	entry, _ := NewAccessEntry(AccessChat, "-100")
+	if err := source.AddAccessEntry(entry); err != nil {
+		t.Fatalf("add access entry: %v", err)
+	}
+
+	jsonPath := filepath.Join(dir, "data.json")
+	if err := Migrate(textPath, jsonPath); err != nil {
+		t.Fatalf("migrate to JSON: %v", err)
+	}
+	if err := Migrate(textPath, jsonPath); err == nil {
+		t.Fatal("expected migrate to refuse overwriting an existing store")
+	}
+	backPath := filepath.Join(dir, "back.txt")
+	if err := Migrate(jsonPath, backPath); err != nil {
+		t.Fatalf("migrate back to text: %v", err)
+	}
+
+	for _, path := range []string{jsonPath, backPath} {
+		store := NewStore(path)
+		if err := store.Init(); err != nil {
+			t.Fatalf("init %s: %v", path, err)
+		}
+		if token, ok := store.GetUserAccessToken(1); !ok || token != "token-1" {
+			t.Fatalf("%s: unexpected token of user 1: %q", path, token)
+		}
+		credential, ok := store.GetUserCredential(2)
+		if !ok || credential.Account != "work" || credential.Instance != "https://memos.example.com" || credential.AccessToken != "token-2" {
+			t.Fatalf("%s: unexpected active credential of user 2: %+v", path, credential)
+		}
+		if credentials, _ := store.ListUserCredentials(2); len(credentials) != 2 {
+			t.Fatalf("%s: expected 2 accounts, got %+v", path, credentials)
+		}
+		if !store.HasAccessEntry(entry) {
+			t.Fatalf("%s: expected access entry %s", path, entry)
+		}
+	}
+}
@@ -1,28 +1,181 @@
 package store
 
 import (
+	"bufio"
 	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 )
 
+// Format is the file format of a store.
+type Format string
+
+const (
+	// FormatText keeps one user account per line in the data file and the
+	// allowlist in a second file next to it.
+	FormatText Format = "text"
+	// FormatJSON keeps users and the allowlist in a single JSON document.
+	FormatJSON Format = "json"
+)
+
+// FormatForPath returns the format of a data file by its extension.
+func FormatForPath(path string) Format {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return FormatJSON
+	}
+	return FormatText
+}
+
 type Store struct {
-	Data string
+	Data   string
+	Format Format
+	// AccessData is the file with the allowlist managed at runtime. It is
+	// empty for formats that keep the allowlist in the data file.
+	AccessData string
+
+	userAccessTokenCache sync.Map // map[int64]*userAccounts
+	userAccountsMutex    sync.Mutex
 
-	userAccessTokenCache sync.Map // map[int64]string
+	accessEntries   sync.Map // map[AccessEntry]struct{}
+	accessFileMutex sync.Mutex
 }
 
 func NewStore(data string) *Store {
-	return &Store{
-		Data: data,
+	s := &Store{
+		Data:   data,
+		Format: FormatForPath(data),
 
 		userAccessTokenCache: sync.Map{},
 	}
+	if s.Format == FormatText {
+		s.AccessData = accessDataPath(data)
+	}
+	return s
 }
 
 func (s *Store) Init() error {
-	if err := s.loadUserAccessTokenMapFromFile(); err != nil {
+	return s.load()
+}
+
+// Reload replaces the cached users and allowlist with the contents of the
+// files, e.g. after they were changed with the CLI.
+func (s *Store) Reload() error {
+	return s.load()
+}
+
+func (s *Store) load() error {
+	if s.Format == FormatJSON {
+		users, entries, err := s.readJSONFile()
+		if err != nil {
+			return fmt.Errorf("failed to load data file: %w", err)
+		}
+		s.replaceUserAccounts(users)
+		s.replaceAccessEntries(entries)
+		return nil
+	}
+
+	users, err := s.loadUserAccessTokenMapFromFile()
+	if err != nil {
 		return fmt.Errorf("failed to load user access token map from file: %w", err)
 	}
+	entries, err := s.loadAccessEntriesFromFile()
+	if err != nil {
+		return fmt.Errorf("failed to load access entries from file: %w", err)
+	}
+	s.replaceUserAccounts(users)
+	s.replaceAccessEntries(entries)
+	return nil
+}
 
+func (s *Store) replaceUserAccounts(users map[int64]*userAccounts) {
+	s.userAccountsMutex.Lock()
+	defer s.userAccountsMutex.Unlock()
+	s.replaceUserAccountsLocked(users)
+}
+
+// refreshUserAccounts reads the users from the data file again before they
+// are changed, so that a change made with the CLI, like a revoked account,
+// isn't written back from the cache. The mutex must be held.
+func (s *Store) refreshUserAccounts() {
+	var users map[int64]*userAccounts
+	var err error
+	if s.Format == FormatJSON {
+		users, _, err = s.readJSONFile()
+	} else {
+		users, err = s.loadUserAccessTokenMapFromFile()
+	}
+	if err != nil {
+		// Keep the cached users, which are at most missing changes made
+		// with the CLI.
+		slog.Error("failed to refresh users from the data file", slog.Any("err", err))
+		return
+	}
+	s.replaceUserAccountsLocked(users)
+}
+
+// replaceUserAccountsLocked is replaceUserAccounts with the mutex held.
+func (s *Store) replaceUserAccountsLocked(users map[int64]*userAccounts) {
+	s.userAccessTokenCache.Range(func(key, value interface{}) bool {
+		if _, ok := users[key.(int64)]; !ok {
+			s.userAccessTokenCache.Delete(key)
+		}
+		return true
+	})
+	for userID, accounts := range users {
+		s.userAccessTokenCache.Store(userID, accounts)
+	}
+}
+
+func (s *Store) replaceAccessEntries(entries map[AccessEntry]struct{}) {
+	s.accessEntries.Range(func(key, value interface{}) bool {
+		if _, ok := entries[key.(AccessEntry)]; !ok {
+			s.accessEntries.Delete(key)
+		}
+		return true
+	})
+	for entry := range entries {
+		s.accessEntries.Store(entry, struct{}{})
+	}
+}
+
+// accessDataPath returns the allowlist file next to the data file, e.g.
+// `data.access.txt` for `data.txt`.
+func accessDataPath(data string) string {
+	ext := filepath.Ext(data)
+	return strings.TrimSuffix(data, ext) + ".access" + ext
+}
+
+// writeFileAtomic writes a file through a temporary file in the same
+// directory, so that readers never see a partially written file.
+func writeFileAtomic(path string, write func(*bufio.Writer) error) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), "memogram-*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	writer := bufio.NewWriter(tmpFile)
+	if err := write(writer); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("write data file: %w", err)
+	}
+	if err := writer.Flush(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("flush data file: %w", err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("sync data file: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("close data file: %w", err)
+	}
+
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		return fmt.Errorf("replace data file: %w", err)
+	}
 	return nil
 }
@@ -5,71 +5,222 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
-	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 )
 
-// GetUserAccessToken returns the access token for the user.
+// DefaultAccount is the name of the account created by a plain /start.
+const DefaultAccount = "default"
+
+// Credential is a named Memos access token together with the instance it
+// was issued by. An empty Instance means the default server.
+type Credential struct {
+	Account     string
+	Instance    string
+	AccessToken string
+}
+
+// userAccounts holds the Memos accounts of a Telegram user. Values stored in
+// the cache are never modified in place.
+type userAccounts struct {
+	active   string
+	accounts map[string]Credential
+}
+
+func (u *userAccounts) clone() *userAccounts {
+	cloned := &userAccounts{
+		active:   u.active,
+		accounts: make(map[string]Credential, len(u.accounts)),
+	}
+	for name, credential := range u.accounts {
+		cloned.accounts[name] = credential
+	}
+	return cloned
+}
+
+// GetUserAccessToken returns the access token of the user's active account.
 func (s *Store) GetUserAccessToken(userID int64) (string, bool) {
-	accessToken, ok := s.userAccessTokenCache.Load(userID)
+	credential, ok := s.GetUserCredential(userID)
 	if !ok {
 		return "", false
 	}
-	return accessToken.(string), true
+	return credential.AccessToken, true
 }
 
-// SetUserAccessToken sets the access token for the user.
+// SetUserAccessToken sets the access token of the user's default account on
+// the default server.
 func (s *Store) SetUserAccessToken(userID int64, accessToken string) {
-	s.userAccessTokenCache.Store(userID, accessToken)
+	s.SetUserCredential(userID, Credential{AccessToken: accessToken})
+}
+
+// GetUserCredential returns the credential of the user's active account.
+func (s *Store) GetUserCredential(userID int64) (Credential, bool) {
+	accounts, ok := s.loadUserAccounts(userID)
+	if !ok {
+		return Credential{}, false
+	}
+	credential, ok := accounts.accounts[accounts.active]
+	return credential, ok
+}
+
+// GetUserAccountCredential returns the credential of the named account.
+func (s *Store) GetUserAccountCredential(userID int64, account string) (Credential, bool) {
+	accounts, ok := s.loadUserAccounts(userID)
+	if !ok {
+		return Credential{}, false
+	}
+	credential, ok := accounts.accounts[account]
+	return credential, ok
+}
+
+// ListUserCredentials returns all accounts of the user sorted by name and the
+// name of the active one.
+func (s *Store) ListUserCredentials(userID int64) ([]Credential, string) {
+	accounts, ok := s.loadUserAccounts(userID)
+	if !ok {
+		return nil, ""
+	}
+	credentials := make([]Credential, 0, len(accounts.accounts))
+	for _, credential := range accounts.accounts {
+		credentials = append(credentials, credential)
+	}
+	sort.Slice(credentials, func(i, j int) bool {
+		return credentials[i].Account < credentials[j].Account
+	})
+	return credentials, accounts.active
+}
+
+// SetUserCredential adds or replaces the account of the credential and makes
+// it the user's active account.
+func (s *Store) SetUserCredential(userID int64, credential Credential) {
+	if credential.Account == "" {
+		credential.Account = DefaultAccount
+	}
+
+	s.userAccountsMutex.Lock()
+	defer s.userAccountsMutex.Unlock()
+	s.refreshUserAccounts()
+	accounts := &userAccounts{accounts: map[string]Credential{}}
+	if existing, ok := s.loadUserAccounts(userID); ok {
+		accounts = existing.clone()
+	}
+	accounts.accounts[credential.Account] = credential
+	accounts.active = credential.Account
+	s.userAccessTokenCache.Store(userID, accounts)
+
 	if err := s.SaveUserAccessTokenMapToFile(); err != nil {
 		slog.Error("failed to save user access token map to file", "error", err)
 	}
 }
 
-// SaveUserAccessTokenMapToFile saves the user access token map to a data file.
-func (s *Store) SaveUserAccessTokenMapToFile() error {
-	entries := s.snapshotAccessTokens()
-	dataDir := filepath.Dir(s.Data)
-	tmpFile, err := os.CreateTemp(dataDir, "memogram-*.tmp")
-	if err != nil {
-		return fmt.Errorf("create temp file: %w", err)
+// SwitchUserAccount makes the named account the user's active account.
+func (s *Store) SwitchUserAccount(userID int64, account string) error {
+	s.userAccountsMutex.Lock()
+	defer s.userAccountsMutex.Unlock()
+	s.refreshUserAccounts()
+	existing, ok := s.loadUserAccounts(userID)
+	if !ok {
+		return fmt.Errorf("user %d has no accounts", userID)
+	}
+	if _, ok := existing.accounts[account]; !ok {
+		return fmt.Errorf("account %q not found", account)
 	}
-	defer os.Remove(tmpFile.Name())
+	accounts := existing.clone()
+	accounts.active = account
+	s.userAccessTokenCache.Store(userID, accounts)
 
-	writer := bufio.NewWriter(tmpFile)
-	for _, entry := range entries {
-		if _, err := fmt.Fprintf(writer, "%d:%s\n", entry.userID, entry.accessToken); err != nil {
-			tmpFile.Close()
-			return fmt.Errorf("write data file: %w", err)
-		}
+	if err := s.SaveUserAccessTokenMapToFile(); err != nil {
+		return fmt.Errorf("save user access token map: %w", err)
 	}
-	if err := writer.Flush(); err != nil {
-		tmpFile.Close()
-		return fmt.Errorf("flush data file: %w", err)
+	return nil
+}
+
+func (s *Store) loadUserAccounts(userID int64) (*userAccounts, bool) {
+	accounts, ok := s.userAccessTokenCache.Load(userID)
+	if !ok {
+		return nil, false
 	}
-	if err := tmpFile.Sync(); err != nil {
-		tmpFile.Close()
-		return fmt.Errorf("sync data file: %w", err)
+	return accounts.(*userAccounts), true
+}
+
+// ListUserIDs returns the IDs of all users with an account, sorted.
+func (s *Store) ListUserIDs() []int64 {
+	userIDs := make([]int64, 0)
+	s.userAccessTokenCache.Range(func(key, value interface{}) bool {
+		userIDs = append(userIDs, key.(int64))
+		return true
+	})
+	sort.Slice(userIDs, func(i, j int) bool {
+		return userIDs[i] < userIDs[j]
+	})
+	return userIDs
+}
+
+// RemoveUserCredential removes the named account of the user, or all of the
+// user's accounts if account is empty, and reports whether anything was
+// removed. When the active account is removed, the first remaining one by
+// name becomes active.
+func (s *Store) RemoveUserCredential(userID int64, account string) (bool, error) {
+	s.userAccountsMutex.Lock()
+	defer s.userAccountsMutex.Unlock()
+	s.refreshUserAccounts()
+	existing, ok := s.loadUserAccounts(userID)
+	if !ok {
+		return false, nil
 	}
-	if err := tmpFile.Close(); err != nil {
-		return fmt.Errorf("close data file: %w", err)
+	if account == "" {
+		s.userAccessTokenCache.Delete(userID)
+	} else {
+		if _, ok := existing.accounts[account]; !ok {
+			return false, nil
+		}
+		accounts := existing.clone()
+		delete(accounts.accounts, account)
+		if len(accounts.accounts) == 0 {
+			s.userAccessTokenCache.Delete(userID)
+		} else {
+			if accounts.active == account {
+				names := make([]string, 0, len(accounts.accounts))
+				for name := range accounts.accounts {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				accounts.active = names[0]
+			}
+			s.userAccessTokenCache.Store(userID, accounts)
+		}
 	}
 
-	if err := os.Rename(tmpFile.Name(), s.Data); err != nil {
-		return fmt.Errorf("replace data file: %w", err)
+	if err := s.SaveUserAccessTokenMapToFile(); err != nil {
+		return true, fmt.Errorf("save user access token map: %w", err)
 	}
-	return nil
+	return true, nil
 }
 
-func (s *Store) loadUserAccessTokenMapFromFile() error {
+// SaveUserAccessTokenMapToFile saves the user access token map to a data file.
+func (s *Store) SaveUserAccessTokenMapToFile() error {
+	if s.Format == FormatJSON {
+		return s.writeJSONFile()
+	}
+	entries := s.snapshotAccessTokens()
+	return writeFileAtomic(s.Data, func(writer *bufio.Writer) error {
+		for _, entry := range entries {
+			if _, err := fmt.Fprintf(writer, "%d:%s\n", entry.userID, formatCredential(entry.credential, entry.active)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) loadUserAccessTokenMapFromFile() (map[int64]*userAccounts, error) {
 	// Check if the file exists
 	if _, err := os.Stat(s.Data); os.IsNotExist(err) {
 		// Create the file if it doesn't exist
 		file, err := os.Create(s.Data)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		defer file.Close()
 	}
@@ -77,11 +228,12 @@ func (s *Store) loadUserAccessTokenMapFromFile() error {
 	// Open the file
 	file, err := os.Open(s.Data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer file.Close()
 
 	// Read the file line by line
+	users := make(map[int64]*userAccounts)
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
@@ -93,13 +245,21 @@ func (s *Store) loadUserAccessTokenMapFromFile() error {
 		if userID == 0 || accessToken == "" {
 			continue
 		}
-		// Store the user ID and access token in the cache
-		s.userAccessTokenCache.Store(userID, accessToken)
+		credential, active := parseCredential(accessToken)
+		accounts, ok := users[userID]
+		if !ok {
+			accounts = &userAccounts{accounts: map[string]Credential{}}
+			users[userID] = accounts
+		}
+		accounts.accounts[credential.Account] = credential
+		if active || accounts.active == "" {
+			accounts.active = credential.Account
+		}
 	}
 	if err := scanner.Err(); err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return users, nil
 }
 
 func parseLine(line string) (int64, string) {
@@ -116,9 +276,53 @@ func parseLine(line string) (int64, string) {
 	return userID, accessToken
 }
 
+// parseCredential parses the value part of a data file line, which is
+// `<access_token>` optionally followed by `instance=<url>`, `account=<name>`
+// and `active` attributes. A bare attribute is read as the instance.
+func parseCredential(value string) (Credential, bool) {
+	fields := strings.Fields(value)
+	credential := Credential{Account: DefaultAccount}
+	if len(fields) == 0 {
+		return credential, false
+	}
+	credential.AccessToken = fields[0]
+
+	active := false
+	for _, field := range fields[1:] {
+		key, val, ok := strings.Cut(field, "=")
+		switch {
+		case !ok && key == "active":
+			active = true
+		case !ok:
+			credential.Instance = key
+		case key == "instance":
+			credential.Instance = val
+		case key == "account":
+			credential.Account = val
+		}
+	}
+	return credential, active
+}
+
+func formatCredential(credential Credential, active bool) string {
+	var sb strings.Builder
+	sb.WriteString(credential.AccessToken)
+	if credential.Instance != "" {
+		sb.WriteString(" instance=" + credential.Instance)
+	}
+	if credential.Account != DefaultAccount {
+		sb.WriteString(" account=" + credential.Account)
+	}
+	if active {
+		sb.WriteString(" active")
+	}
+	return sb.String()
+}
+
 type userAccessTokenEntry struct {
-	userID      int64
-	accessToken string
+	userID     int64
+	credential Credential
+	active     bool
 }
 
 func (s *Store) snapshotAccessTokens() []userAccessTokenEntry {
@@ -128,18 +332,25 @@ func (s *Store) snapshotAccessTokens() []userAccessTokenEntry {
 		if !ok {
 			return true
 		}
-		accessToken, ok := value.(string)
+		accounts, ok := value.(*userAccounts)
 		if !ok {
 			return true
 		}
-		entries = append(entries, userAccessTokenEntry{
-			userID:      userID,
-			accessToken: accessToken,
-		})
+		for name, credential := range accounts.accounts {
+			entries = append(entries, userAccessTokenEntry{
+				userID:     userID,
+				credential: credential,
+				// Only mark the active account when there is a choice.
+				active: name == accounts.active && len(accounts.accounts) > 1,
+			})
+		}
 		return true
 	})
 
 	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].userID == entries[j].userID {
+			return entries[i].credential.Account < entries[j].credential.Account
+		}
 		return entries[i].userID < entries[j].userID
 	})
 
@@ -41,3 +41,140 @@ func TestSaveAndLoadUserAccessTokens(t *testing.T) {
 		t.Fatalf("expected token:two for user 7, got %q", token)
 	}
 }
+
+func TestSaveAndLoadUserCredentials(t *testing.T) {
+	dataPath := filepath.Join(t.TempDir(), "data.txt")
+
+	store := NewStore(dataPath)
+	if err := store.Init(); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+
+	store.SetUserAccessToken(1, "default-token")
+	store.SetUserCredential(2, Credential{Instance: "https://memos.example.com", AccessToken: "team:token"})
+
+	reloaded := NewStore(dataPath)
+	if err := reloaded.Init(); err != nil {
+		t.Fatalf("init reloaded store: %v", err)
+	}
+
+	credential, ok := reloaded.GetUserCredential(1)
+	if !ok || credential != (Credential{Account: DefaultAccount, AccessToken: "default-token"}) {
+		t.Fatalf("unexpected credential for user 1: %+v", credential)
+	}
+
+	credential, ok = reloaded.GetUserCredential(2)
+	if !ok || credential != (Credential{Account: DefaultAccount, Instance: "https://memos.example.com", AccessToken: "team:token"}) {
+		t.Fatalf("unexpected credential for user 2: %+v", credential)
+	}
+}
+
+func TestUserAccountsAndSwitch(t *testing.T) {
+	dataPath := filepath.Join(t.TempDir(), "data.txt")
+
+	store := NewStore(dataPath)
+	if err := store.Init(); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+
+	store.SetUserAccessToken(42, "personal-token")
+	store.SetUserCredential(42, Credential{Account: "work", Instance: "https://team.example.com", AccessToken: "work-token"})
+
+	credential, ok := store.GetUserCredential(42)
+	if !ok || credential.Account != "work" {
+		t.Fatalf("expected work to be active after adding it, got %+v", credential)
+	}
+	if err := store.SwitchUserAccount(42, DefaultAccount); err != nil {
+		t.Fatalf("switch account: %v", err)
+	}
+	if err := store.SwitchUserAccount(42, "missing"); err == nil {
+		t.Fatal("expected error when switching to a missing account")
+	}
+
+	reloaded := NewStore(dataPath)
+	if err := reloaded.Init(); err != nil {
+		t.Fatalf("init reloaded store: %v", err)
+	}
+
+	credentials, active := reloaded.ListUserCredentials(42)
+	if len(credentials) != 2 || active != DefaultAccount {
+		t.Fatalf("unexpected accounts %+v with active %q", credentials, active)
+	}
+	token, ok := reloaded.GetUserAccessToken(42)
+	if !ok || token != "personal-token" {
+		t.Fatalf("expected personal-token for the active account, got %q", token)
+	}
+	credential, ok = reloaded.GetUserAccountCredential(42, "work")
+	if !ok || credential.Instance != "https://team.example.com" || credential.AccessToken != "work-token" {
+		t.Fatalf("unexpected work credential %+v", credential)
+	}
+}
+
+func TestRemoveUserCredentialAndReload(t *testing.T) {
+	dataPath := filepath.Join(t.TempDir(), "data.txt")
+	store := NewStore(dataPath)
+	if err := store.Init(); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	store.SetUserCredential(1, Credential{AccessToken: "token-1"})
+	store.SetUserCredential(1, Credential{Account: "work", AccessToken: "token-2"})
+	store.SetUserCredential(2, Credential{AccessToken: "token-3"})
+
+	// A second store on the same file, like the CLI next to the running bot.
+	other := NewStore(dataPath)
+	if err := other.Init(); err != nil {
+		t.Fatalf("init other store: %v", err)
+	}
+	if removed, err := other.RemoveUserCredential(1, "work"); err != nil || !removed {
+		t.Fatalf("remove account: %v, %v", removed, err)
+	}
+	if removed, err := other.RemoveUserCredential(2, ""); err != nil || !removed {
+		t.Fatalf("remove user: %v, %v", removed, err)
+	}
+	if removed, _ := other.RemoveUserCredential(3, ""); removed {
+		t.Fatal("expected nothing to remove for an unknown user")
+	}
+
+	if err := store.Reload(); err != nil {
+		t.Fatalf("reload store: %v", err)
+	}
+	if userIDs := store.ListUserIDs(); len(userIDs) != 1 || userIDs[0] != 1 {
+		t.Fatalf("unexpected users %v", userIDs)
+	}
+	credential, ok := store.GetUserCredential(1)
+	if !ok || credential.Account != DefaultAccount || credential.AccessToken != "token-1" {
+		t.Fatalf("expected the default account to become active, got %+v", credential)
+	}
+}
+
+func TestWriteKeepsRevocationOfAnotherStore(t *testing.T) {
+	for _, name := range []string{"data.txt", "data.json"} {
+		dataPath := filepath.Join(t.TempDir(), name)
+		bot := NewStore(dataPath)
+		if err := bot.Init(); err != nil {
+			t.Fatalf("init store: %v", err)
+		}
+		bot.SetUserAccessToken(42, "token-one")
+
+		// The CLI revokes the user while the bot keeps its cached accounts.
+		cli := NewStore(dataPath)
+		if err := cli.Init(); err != nil {
+			t.Fatalf("init CLI store: %v", err)
+		}
+		if removed, err := cli.RemoveUserCredential(42, ""); !removed || err != nil {
+			t.Fatalf("revoke: %v %v", removed, err)
+		}
+		bot.SetUserAccessToken(7, "token-two")
+
+		reloaded := NewStore(dataPath)
+		if err := reloaded.Init(); err != nil {
+			t.Fatalf("init reloaded store: %v", err)
+		}
+		if _, ok := reloaded.GetUserAccessToken(42); ok {
+			t.Fatalf("%s: expected the revoked user to stay removed", name)
+		}
+		if _, ok := reloaded.GetUserAccessToken(7); !ok {
+			t.Fatalf("%s: expected the new user to be saved", name)
+		}
+	}
+}
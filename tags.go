@@ -0,0 +1,114 @@
+package memogram
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"connectrpc.com/connect"
+	"github.com/usememos/memogram/store"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+)
+
+// maxTagSuggestions is the number of tags suggested below a saved memo.
+const maxTagSuggestions = 3
+
+// tagCountTTL is how long the tag counts of a user are cached for tag
+// suggestions. The memo being saved has its own tags left out anyway, so
+// slightly stale counts only miss tags that were new a moment ago.
+const tagCountTTL = time.Minute
+
+// callbackTagPrefix starts the action of a tag suggestion button, which is
+// followed by the tag.
+const callbackTagPrefix = "#"
+
+// normalizeHashtag turns a Telegram hashtag such as `#idea` or
+// `#idea@channel` into a Memos tag without the leading #. Letters, digits,
+// combining marks and _-/ are kept, and any other character becomes _.
+func normalizeHashtag(hashtag string) string {
+	tag, _, _ := strings.Cut(strings.TrimPrefix(hashtag, "#"), "@")
+	var sb strings.Builder
+	for _, r := range tag {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), unicode.IsMark(r), r == '_', r == '-', r == '/':
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('_')
+		}
+	}
+	return sb.String()
+}
+
+type cachedTagCount struct {
+	tagCount map[string]int32
+	expires  time.Time
+}
+
+// tagSuggestions returns the most used tags of the user that memo doesn't
+// have yet. Suggestions are best effort, so errors only leave them out.
+func (s *Service) tagSuggestions(ctx context.Context, client *MemosClient, credential store.Credential, memo *v1pb.Memo) []string {
+	tagCount, err := s.tagCount(ctx, client, credential)
+	if err != nil {
+		s.logger.Debug("failed to get user stats", slog.Any("err", err))
+		return nil
+	}
+	return suggestTags(tagCount, memo.GetTags())
+}
+
+// tagCount returns how often the user of credential used each tag, asking
+// Memos at most once per tagCountTTL.
+func (s *Service) tagCount(ctx context.Context, client *MemosClient, credential store.Credential) (map[string]int32, error) {
+	key := tokenKey{instance: credential.Instance, accessToken: credential.AccessToken}
+	if cached, ok := s.tagCounts.Load(key); ok && time.Now().Before(cached.(cachedTagCount).expires) {
+		return cached.(cachedTagCount).tagCount, nil
+	}
+	user, err := s.currentUser(ctx, credential.Instance, credential.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := client.UserService.GetUserStats(ctx, connect.NewRequest(&v1pb.GetUserStatsRequest{Name: user.GetName()}))
+	if err != nil {
+		return nil, err
+	}
+	tagCount := stats.Msg.GetTagCount()
+	s.tagCounts.Store(key, cachedTagCount{tagCount: tagCount, expires: time.Now().Add(tagCountTTL)})
+	return tagCount, nil
+}
+
+// suggestTags returns up to maxTagSuggestions tags of tagCount, most used
+// first, leaving out the existing ones.
+func suggestTags(tagCount map[string]int32, existing []string) []string {
+	skip := make(map[string]struct{}, len(existing))
+	for _, tag := range existing {
+		skip[tag] = struct{}{}
+	}
+	tags := make([]string, 0, len(tagCount))
+	for tag := range tagCount {
+		if _, ok := skip[tag]; !ok && isValidTag(tag) {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tagCount[tags[i]] != tagCount[tags[j]] {
+			return tagCount[tags[i]] > tagCount[tags[j]]
+		}
+		return tags[i] < tags[j]
+	})
+	if len(tags) > maxTagSuggestions {
+		tags = tags[:maxTagSuggestions]
+	}
+	return tags
+}
+
+// hasTag reports whether memo has tag.
+func hasTag(memo *v1pb.Memo, tag string) bool {
+	for _, existing := range memo.GetTags() {
+		if existing == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,285 @@
+package memogram
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"connectrpc.com/connect"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+	fieldmaskpb "google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+const (
+	callbackTaskPrefix = "tk "
+
+	// maxTaskButtonLength is the number of characters of a task shown on
+	// its button.
+	maxTaskButtonLength = 40
+)
+
+// taskItem matches a Markdown task list item: its prefix up to the box, the
+// mark in the box and the text.
+var taskItem = regexp.MustCompile(`^(\s*[-*+] \[)([ xX])\] (.*)$`)
+
+// listMarker matches the marker of a Markdown list item.
+var listMarker = regexp.MustCompile(`^[-*+]\s+`)
+
+// memoTask is a task list item of a memo.
+type memoTask struct {
+	// line is the index of the item's line in the content.
+	line int
+	done bool
+	text string
+}
+
+// parseTasks returns the task list items of content in order.
+func parseTasks(content string) []memoTask {
+	var tasks []memoTask
+	for i, line := range strings.Split(content, "\n") {
+		if groups := taskItem.FindStringSubmatch(line); groups != nil {
+			tasks = append(tasks, memoTask{line: i, done: groups[2] != " ", text: groups[3]})
+		}
+	}
+	return tasks
+}
+
+// taskHash returns a short hash of the text of a task, so that a button
+// can tell whether its task is still the one it shows.
+func taskHash(text string) string {
+	h := fnv.New32a()
+	h.Write([]byte(text))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// toggleTask checks or unchecks the nth task list item of content, if its
+// text still has hash. It reports false if there is no such item.
+func toggleTask(content string, n int, hash string) (string, bool) {
+	tasks := parseTasks(content)
+	if n < 0 || n >= len(tasks) || taskHash(tasks[n].text) != hash {
+		return content, false
+	}
+	lines := strings.Split(content, "\n")
+	groups := taskItem.FindStringSubmatch(lines[tasks[n].line])
+	mark := "x"
+	if tasks[n].done {
+		mark = " "
+	}
+	lines[tasks[n].line] = groups[1] + mark + "] " + groups[3]
+	return strings.Join(lines, "\n"), true
+}
+
+// checklist turns each non-empty line of text into an unchecked task list
+// item. Lines that already are items are kept.
+func checklist(text string) string {
+	var items []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case taskItem.MatchString(line):
+			items = append(items, line)
+		default:
+			items = append(items, "- [ ] "+listMarker.ReplaceAllString(line, ""))
+		}
+	}
+	return strings.Join(items, "\n")
+}
+
+// taskCallbackData returns the callback data of a button showing the tasks
+// of the memo with uid, or toggling its task index with text if index is not
+// negative.
+func taskCallbackData(uid, account string, index int, text string) string {
+	data := fmt.Sprintf("%s%s %s", callbackTaskPrefix, uid, account)
+	if index >= 0 {
+		data += " " + strconv.Itoa(index) + " " + taskHash(text)
+	}
+	return data
+}
+
+// tasksView returns the text and buttons of the tasks of memo.
+func tasksView(memo *v1pb.Memo, account string) (string, *models.InlineKeyboardMarkup) {
+	tasks := parseTasks(memo.Content)
+	uid, err := ExtractMemoUIDFromName(memo.Name)
+	if err != nil || len(tasks) == 0 {
+		return fmt.Sprintf("%s has no tasks.", memo.Name), nil
+	}
+	done := 0
+	keyboard := &models.InlineKeyboardMarkup{}
+	for i, task := range tasks {
+		box := "☐"
+		if task.done {
+			box = "☑"
+			done++
+		}
+		text := task.text
+		if utf8.RuneCountInString(text) > maxTaskButtonLength {
+			text = string([]rune(text)[:maxTaskButtonLength-1]) + "…"
+		}
+		data := taskCallbackData(uid, account, i, task.text)
+		// Telegram rejects callback data longer than 64 bytes.
+		if len(data) > 64 {
+			continue
+		}
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []models.InlineKeyboardButton{
+			{Text: box + " " + text, CallbackData: data},
+		})
+	}
+	if len(keyboard.InlineKeyboard) == 0 {
+		keyboard = nil
+	}
+	return fmt.Sprintf("Tasks of %s: %d of %d done", memo.Name, done, len(tasks)), keyboard
+}
+
+// taskCallbackHandler shows the tasks of a memo in a new message, or toggles
+// one of them and updates the message.
+func (s *Service) taskCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	query := update.CallbackQuery
+	if !s.allowCallback(ctx, query) {
+		return
+	}
+	fields := strings.Fields(strings.TrimPrefix(query.Data, callbackTaskPrefix))
+	index := -1
+	if len(fields) == 4 {
+		var err error
+		if index, err = strconv.Atoi(fields[2]); err != nil {
+			index = -2
+		}
+	}
+	if (len(fields) != 2 && len(fields) != 4) || index < -1 || query.Message.Message == nil {
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            "Invalid command",
+			ShowAlert:       true,
+		})
+		return
+	}
+	message := query.Message.Message
+	uid, account := fields[0], fields[1]
+	client, credential, ok := s.accountClient(query.From.ID, account)
+	if !ok {
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            "Please start the bot with /start <access_token>",
+			ShowAlert:       true,
+		})
+		return
+	}
+	resp, err := client.MemoService.GetMemo(ctx, connect.NewRequest(&v1pb.GetMemoRequest{Name: "memos/" + uid}))
+	if err != nil {
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            fmt.Sprintf("Memo memos/%s not found", uid),
+			ShowAlert:       true,
+		})
+		return
+	}
+	memo := resp.Msg
+
+	if index < 0 {
+		text, markup := tasksView(memo, credential.Account)
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:      message.Chat.ID,
+			Text:        text,
+			ReplyMarkup: markup,
+		})
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: query.ID})
+		return
+	}
+
+	content, ok := toggleTask(memo.Content, index, fields[3])
+	if !ok {
+		// The memo was changed since the buttons were shown.
+		text, markup := tasksView(memo, credential.Account)
+		s.sender.EditMessageText(ctx, &bot.EditMessageTextParams{
+			ChatID:      message.Chat.ID,
+			MessageID:   message.ID,
+			Text:        text,
+			ReplyMarkup: markup,
+		})
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            "The task no longer exists",
+		})
+		return
+	}
+	updated, err := client.MemoService.UpdateMemo(ctx, connect.NewRequest(&v1pb.UpdateMemoRequest{
+		Memo:       &v1pb.Memo{Name: memo.Name, Content: content},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"content"}},
+	}))
+	result, details := auditResult(err)
+	s.audit.Record(auditEvent{
+		Action:  auditActionMemoUpdate,
+		UserID:  query.From.ID,
+		ChatID:  message.Chat.ID,
+		Account: credential.Account,
+		Memo:    memo.Name,
+		Result:  result,
+		Details: joinDetails(fmt.Sprintf("toggle task %d", index+1), details),
+	})
+	if err != nil {
+		s.logger.Error("failed to update memo", slog.Any("err", err))
+		s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            "Failed to update memo",
+			ShowAlert:       true,
+		})
+		return
+	}
+
+	text, markup := tasksView(updated.Msg, credential.Account)
+	s.sender.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:      message.Chat.ID,
+		MessageID:   message.ID,
+		Text:        text,
+		ReplyMarkup: markup,
+	})
+	s.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: query.ID})
+}
+
+// todoHandler creates a checklist memo from the lines after /todo and shows
+// its tasks.
+func (s *Service) todoHandler(ctx context.Context, b *bot.Bot, m *models.Update) {
+	content := checklist(strings.TrimPrefix(m.Message.Text, commandTodo))
+	if content == "" {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   "Usage: /todo <task>, with one task per line",
+		})
+		return
+	}
+	client, credential, ok := s.userClient(m.Message.From.ID)
+	if !ok {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   "Please start the bot with /start <access_token>",
+		})
+		return
+	}
+
+	route := s.settings().routes[m.Message.Chat.ID]
+	// Routes are validated when the config is loaded.
+	visibility, _ := parseVisibility(route.Visibility)
+	memo, err := s.createMemo(ctx, client, appendTags(content, route.Tags), visibility)
+	s.auditMemoCreation(m, credential.Account, memo, err)
+	if err != nil {
+		s.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Message.Chat.ID,
+			Text:   "Failed to create memo",
+		})
+		return
+	}
+	text, markup := tasksView(memo, credential.Account)
+	s.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      m.Message.Chat.ID,
+		Text:        text,
+		ReplyMarkup: markup,
+	})
+}
@@ -0,0 +1,116 @@
+package memogram
+
+import (
+	"strings"
+	"testing"
+
+	v1pb "github.com/usememos/memos/proto/gen/api/v1"
+)
+
+func TestToggleTask(t *testing.T) {
+	content := "Shopping\n- [ ] Milk\n  * [x] Eggs\n- not a task\n+ [X] Bread"
+	tasks := parseTasks(content)
+	if len(tasks) != 3 || tasks[0].done || !tasks[1].done || !tasks[2].done || tasks[1].text != "Eggs" || tasks[1].line != 2 {
+		t.Fatalf("unexpected tasks %+v", tasks)
+	}
+
+	got, ok := toggleTask(content, 0, taskHash("Milk"))
+	if want := "Shopping\n- [x] Milk\n  * [x] Eggs\n- not a task\n+ [X] Bread"; !ok || got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	got, ok = toggleTask(content, 1, taskHash("Eggs"))
+	if want := "Shopping\n- [ ] Milk\n  * [ ] Eggs\n- not a task\n+ [X] Bread"; !ok || got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if _, ok := toggleTask(content, 3, taskHash("Bread")); ok {
+		t.Fatal("expected toggling a missing task to fail")
+	}
+	if _, ok := toggleTask(content, 0, taskHash("Eggs")); ok {
+		t.Fatal("expected toggling a changed task to fail")
+	}
+}
+
+func TestChecklist(t *testing.T) {
+	got := checklist(" Milk\n\n- Eggs\n- [x] Bread\n-5 degrees ")
+	want := "- [ ] Milk\n- [ ] Eggs\n- [x] Bread\n- [ ] -5 degrees"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestE2ETodo(t *testing.T) {
+	e := newE2E(t)
+	e.send(textMessage(1, "/todo\nMilk\nEggs"))
+
+	memo, ok := e.memos.memo("memos/1")
+	if !ok || memo.Content != "- [ ] Milk\n- [ ] Eggs" {
+		t.Fatalf("unexpected memo %+v", memo)
+	}
+	reply := e.api.sent("sendMessage")[0]
+	if reply.Get("text") != "Tasks of memos/1: 0 of 2 done" || !strings.Contains(reply.Get("reply_markup"), `"text":"☐ Eggs","callback_data":"`+taskCallbackData("1", "default", 1, "Eggs")+`"`) {
+		t.Fatalf("unexpected tasks view %q %s", reply.Get("text"), reply.Get("reply_markup"))
+	}
+
+	e.press(1, taskCallbackData("1", "default", 1, "Eggs"))
+	if memo, _ := e.memos.memo("memos/1"); memo.Content != "- [ ] Milk\n- [x] Eggs" {
+		t.Fatalf("expected the task to be checked, got %q", memo.Content)
+	}
+	if text := e.lastText(t, "editMessageText"); text != "Tasks of memos/1: 1 of 2 done" {
+		t.Fatalf("unexpected tasks view %q", text)
+	}
+
+	// A button whose task moved doesn't toggle the task now at its index.
+	e.press(1, taskCallbackData("1", "default", 0, "Eggs"))
+	if memo, _ := e.memos.memo("memos/1"); memo.Content != "- [ ] Milk\n- [x] Eggs" {
+		t.Fatalf("expected the memo to be unchanged, got %q", memo.Content)
+	}
+	if text := e.lastText(t, "answerCallbackQuery"); text != "The task no longer exists" {
+		t.Fatalf("unexpected answer %q", text)
+	}
+
+	e.send(textMessage(1, "/todo"))
+	if text := e.lastText(t, "sendMessage"); !strings.HasPrefix(text, "Usage: /todo") {
+		t.Fatalf("unexpected reply %q", text)
+	}
+}
+
+func TestE2ETasksButton(t *testing.T) {
+	e := newE2E(t)
+	e.send(textMessage(1, "Plan\n- [ ] Write\n- [x] Review"))
+	if markup := e.api.sent("sendMessage")[0].Get("reply_markup"); !strings.Contains(markup, `"text":"Tasks (2)","callback_data":"tk 1 default"`) {
+		t.Fatalf("expected a tasks button, got %s", markup)
+	}
+
+	e.press(1, "tk 1 default")
+	if text := e.lastText(t, "sendMessage"); text != "Tasks of memos/1: 1 of 2 done" {
+		t.Fatalf("unexpected tasks view %q", text)
+	}
+
+	// Other users can't toggle the tasks.
+	e.press(2, taskCallbackData("1", "default", 0, "Write"))
+	if memo, _ := e.memos.memo("memos/1"); memo.Content != "Plan\n- [ ] Write\n- [x] Review" {
+		t.Fatalf("expected the memo to be unchanged, got %q", memo.Content)
+	}
+}
+
+func TestTaskButtonsSkipLongCallbackData(t *testing.T) {
+	uid := strings.Repeat("u", 32)
+	memo := &v1pb.Memo{Name: "memos/" + uid, Content: "- [ ] Milk\n- [ ] Eggs"}
+	text, markup := tasksView(memo, "default")
+	if text != "Tasks of memos/"+uid+": 0 of 2 done" || markup == nil || len(markup.InlineKeyboard) != 2 {
+		t.Fatalf("unexpected tasks view %q %+v", text, markup)
+	}
+	memo.Name = "memos/" + strings.Repeat("u", 40)
+	if _, markup := tasksView(memo, strings.Repeat("a", 16)); markup != nil {
+		t.Fatalf("expected the buttons with long callback data to be skipped, got %+v", markup)
+	}
+
+	memo.Name = "memos/" + strings.Repeat("u", 64)
+	for _, row := range (&Service{}).keyboard(memo, "default", nil).InlineKeyboard {
+		for _, button := range row {
+			if strings.HasPrefix(button.CallbackData, callbackTaskPrefix) || strings.HasPrefix(button.CallbackData, callbackRelatedPrefix) {
+				t.Fatalf("expected the buttons with long callback data to be skipped, got %q", button.CallbackData)
+			}
+		}
+	}
+}